@@ -0,0 +1,302 @@
+// Admission Control
+//
+// The Router handles a limited number of uploads at once, over TCP or
+// pre-signed HTTP, further uploads wait in a bounded queue for a free slot.
+// Those which don't fit in the queue, or wait longer than the queue
+// timeout, are answered with _BUSY___, or 503 over HTTP, and closed. Limits
+// of 0 are off
+//
+// 	ROUTER_MAX_CONNECTIONS : Connections handled at once
+// 	ROUTER_CONNECTION_QUEUE : Connections waiting for a slot
+// 	ROUTER_QUEUE_TIMEOUT : Longest wait for a slot or a WriteNode, 30s
+// 	ROUTER_NODE_MAX_WRITES : Ticket writes outstanding on each WriteNode
+// 	ROUTER_NODE_BACKOFF : How long a busy WriteNode is skipped, 100ms
+//
+// WriteNodes push back by answering a write with the Busy status, or
+// ResourceExhausted, and report the writes waiting on them as queue_depth.
+// Tickets refused by a busy node are redirected to the next node and nodes
+// with the fewest writes waiting are preferred. Busy nodes are skipped for
+// the backoff, doubling each time they stay busy. A ticket only fails when
+// no node takes it within the queue timeout.
+package dataputter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// REPLY_BUSY Reply to a TCP connection refused by admission control
+	REPLY_BUSY = "_BUSY___"
+	// nodeDepthTTL: How long a reported queue depth is trusted
+	nodeDepthTTL = time.Second
+	// maxNodeBackoff: Longest a busy node is skipped
+	maxNodeBackoff = 5 * time.Second
+)
+
+var (
+	// ErrRouterBusy No connection slot was free in time
+	ErrRouterBusy = errors.New("Router busy")
+	// ErrNodeBusy The WriteNode refused a write until it catches up
+	ErrNodeBusy = errors.New("WriteNode busy")
+	// ErrNodesBusy No WriteNode took a write within the queue timeout
+	ErrNodesBusy = errors.New("All WriteNodes busy")
+
+	admissionMu sync.Mutex
+	admission   = Admission{QueueTimeout: 30 * time.Second, NodeBackoff: 100 * time.Millisecond}
+	// connectionSlots: Held by each connection handled, nil without a limit
+	connectionSlots chan struct{}
+	// connectionsWaiting: Connections queued for a slot
+	connectionsWaiting int
+	// nodeLoads: What is known of the load of each WriteNode
+	nodeLoads = map[string]*nodeLoad{}
+)
+
+func init() {
+	a := admission
+	envCounts := map[string]*int{
+		"ROUTER_MAX_CONNECTIONS":  &a.Connections,
+		"ROUTER_CONNECTION_QUEUE": &a.Queue,
+		"ROUTER_NODE_MAX_WRITES":  &a.NodeWrites,
+	}
+	for name, limit := range envCounts {
+		if v := os.Getenv(name); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Printf("Ignoring %s=%s: %v\n", name, v, err)
+				continue
+			}
+			*limit = n
+		}
+	}
+	envDurations := map[string]*time.Duration{
+		"ROUTER_QUEUE_TIMEOUT": &a.QueueTimeout,
+		"ROUTER_NODE_BACKOFF":  &a.NodeBackoff,
+	}
+	for name, duration := range envDurations {
+		if v := os.Getenv(name); len(v) > 0 {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Printf("Ignoring %s=%s: %v\n", name, v, err)
+				continue
+			}
+			*duration = d
+		}
+	}
+	UseAdmission(a)
+}
+
+// Admission Connections and WriteNode writes allowed at once, 0 is unlimited
+type Admission struct {
+	Connections int
+	Queue       int
+	// QueueTimeout: Longest wait for a connection slot or a WriteNode
+	QueueTimeout time.Duration
+	NodeWrites   int
+	NodeBackoff  time.Duration
+}
+
+// nodeLoad Writes outstanding on a WriteNode and what it last reported
+type nodeLoad struct {
+	inFlight   int
+	queueDepth int64
+	reportedAt time.Time
+	// busy: Writes refused in a row, busyUntil: When to try the node again
+	busy      int
+	busyUntil time.Time
+}
+
+// UseAdmission Replaces the admission limits, resetting what is known of
+// WriteNodes. Connections already handled keep their slots.
+func UseAdmission(a Admission) {
+	admissionMu.Lock()
+	defer admissionMu.Unlock()
+
+	admission = a
+	connectionSlots = nil
+	if a.Connections > 0 {
+		connectionSlots = make(chan struct{}, a.Connections)
+	}
+	nodeLoads = map[string]*nodeLoad{}
+}
+
+// AdmitConnection Takes a connection slot until release is called, waiting
+// in the queue when none is free. ErrRouterBusy when the queue is full or
+// no slot was freed within the queue timeout.
+func AdmitConnection() (release func(), err error) {
+	admissionMu.Lock()
+	slots, queue, timeout := connectionSlots, admission.Queue, admission.QueueTimeout
+	if slots == nil {
+		admissionMu.Unlock()
+		return func() {}, nil
+	}
+	release = func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		admissionMu.Unlock()
+		return release, nil
+	default:
+	}
+	if connectionsWaiting >= queue {
+		admissionMu.Unlock()
+		return nil, ErrRouterBusy
+	}
+	connectionsWaiting++
+	admissionMu.Unlock()
+	defer func() {
+		admissionMu.Lock()
+		connectionsWaiting--
+		admissionMu.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrRouterBusy
+	}
+}
+
+// admitCreateObject Handles a connection once it's admitted, answering
+// _BUSY___ when it isn't
+func admitCreateObject(c net.Conn, config RouterConfig) {
+	release, err := AdmitConnection()
+	if err != nil {
+		log.Printf("Refusing connection from %s: %v\n", c.RemoteAddr(), err)
+		c.Write([]byte(REPLY_BUSY))
+		c.Close()
+		return
+	}
+	defer release()
+	DoCreateObject(c, config)
+}
+
+// nodeAddresses host:port of each WriteNode in the order of the config
+func nodeAddresses(config RouterConfig) []string {
+	addresses := make([]string, len(config.Nodes))
+	for i, node := range config.Nodes {
+		addresses[i] = fmt.Sprintf("%s:%d", node.Host, node.Port)
+	}
+	return addresses
+}
+
+// loadOf The load of a WriteNode. Hold admissionMu.
+func loadOf(address string) *nodeLoad {
+	load, ok := nodeLoads[address]
+	if !ok {
+		load = &nodeLoad{}
+		nodeLoads[address] = load
+	}
+	return load
+}
+
+// pending Writes outstanding on the node and those it last reported waiting
+func (l *nodeLoad) pending(now time.Time) int64 {
+	if now.Sub(l.reportedAt) > nodeDepthTTL {
+		return int64(l.inFlight)
+	}
+	return int64(l.inFlight) + l.queueDepth
+}
+
+// acquireNode The index of the least loaded WriteNode taking writes,
+// starting from start for equally loaded nodes. Waits for a node until the
+// deadline, then ErrNodesBusy. Call release with the result of the write.
+func acquireNode(addresses []string, start int, deadline time.Time) (int, func(*NodeResponse, error), error) {
+	wait := 10 * time.Millisecond
+	for {
+		admissionMu.Lock()
+		now := time.Now()
+		chosen, least := -1, int64(0)
+		for i := range addresses {
+			index := (start + i) % len(addresses)
+			load := loadOf(addresses[index])
+			if now.Before(load.busyUntil) {
+				continue
+			}
+			if admission.NodeWrites > 0 && load.inFlight >= admission.NodeWrites {
+				continue
+			}
+			if pending := load.pending(now); chosen < 0 || pending < least {
+				chosen, least = index, pending
+			}
+		}
+		if chosen >= 0 {
+			load, backoff := loadOf(addresses[chosen]), admission.NodeBackoff
+			load.inFlight++
+			admissionMu.Unlock()
+			return chosen, func(response *NodeResponse, err error) {
+				admissionMu.Lock()
+				defer admissionMu.Unlock()
+				load.inFlight--
+				if response != nil {
+					load.queueDepth, load.reportedAt = response.QueueDepth, time.Now()
+				}
+				if err != ErrNodeBusy {
+					load.busy = 0
+					return
+				}
+				delay := backoff << uint(load.busy)
+				if delay > maxNodeBackoff || delay <= 0 {
+					delay = maxNodeBackoff
+				}
+				load.busy++
+				load.busyUntil = time.Now().Add(delay)
+			}, nil
+		}
+		admissionMu.Unlock()
+
+		if time.Now().Add(wait).After(deadline) {
+			return -1, nil, ErrNodesBusy
+		}
+		time.Sleep(wait)
+		if wait < 200*time.Millisecond {
+			wait *= 2
+		}
+	}
+}
+
+// writeTicketToNodes Writes a ticket to the least loaded of the WriteNodes,
+// redirecting it to another node while they're busy. Returns the index of
+// the node which took the write.
+func writeTicketToNodes(addresses []string, start int, writeRequest *NodeWriteRequest) (*NodeResponse, int, error) {
+	if len(addresses) == 0 {
+		return nil, -1, fmt.Errorf("No WriteNodes to write ticket %s to\n", writeRequest.TicketId)
+	}
+	admissionMu.Lock()
+	deadline := time.Now().Add(admission.QueueTimeout)
+	admissionMu.Unlock()
+
+	for {
+		index, release, err := acquireNode(addresses, start, deadline)
+		if err != nil {
+			log.Printf("Unable to find a WriteNode for ticket %s of %s: %v\n", writeRequest.TicketId, writeRequest.ObjectId, err)
+			return nil, -1, err
+		}
+		response, err := writeTicketToNode(addresses[index], writeRequest)
+		release(response, err)
+		if err != ErrNodeBusy {
+			return response, index, err
+		}
+		log.Printf("WriteNode %s is busy, redirecting ticket %s of %s\n", addresses[index], writeRequest.TicketId, writeRequest.ObjectId)
+		start = index + 1
+	}
+}
+
+// isNodeBusy True when a WriteNode refused a write until it catches up
+func isNodeBusy(response *NodeResponse, err error) bool {
+	if err != nil {
+		return status.Code(err) == codes.ResourceExhausted
+	}
+	return response.Status == NodeBusy
+}
@@ -0,0 +1,149 @@
+package dataputter
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	grpc "google.golang.org/grpc"
+)
+
+// fakeWriteNode Answers writes with a status and queue depth, and deletes
+// with deleteStatus
+type fakeWriteNode struct {
+	UnimplementedWriteNodeServer
+	status       int32
+	queueDepth   int64
+	writes       int32
+	deleteStatus int32
+	deletes      int32
+}
+
+func (n *fakeWriteNode) Delete(ctx context.Context, request *NodeDeleteRequest) (*NodeResponse, error) {
+	atomic.AddInt32(&n.deletes, 1)
+	return &NodeResponse{
+		Status:   atomic.LoadInt32(&n.deleteStatus),
+		ObjectId: request.ObjectId,
+		TicketId: request.TicketId,
+		NodeId:   "fake",
+	}, nil
+}
+
+func (n *fakeWriteNode) Write(ctx context.Context, request *NodeWriteRequest) (*NodeResponse, error) {
+	atomic.AddInt32(&n.writes, 1)
+	return &NodeResponse{
+		Status:     n.status,
+		QueueDepth: n.queueDepth,
+		ObjectId:   request.ObjectId,
+		TicketId:   request.TicketId,
+		NodeId:     "fake",
+		ByteStart:  request.ByteStart,
+		ByteEnd:    request.ByteEnd,
+		ByteCount:  request.ByteCount,
+	}, nil
+}
+
+func serveFakeWriteNode(t *testing.T, node *fakeWriteNode) string {
+	s, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected to listen, got %v\n", err)
+	}
+	server := grpc.NewServer()
+	RegisterWriteNodeServer(server, node)
+	go server.Serve(s)
+	t.Cleanup(server.Stop)
+	return s.Addr().String()
+}
+
+func TestAdmitConnection(t *testing.T) {
+	defer UseAdmission(admission)
+	UseAdmission(Admission{Connections: 1, Queue: 1, QueueTimeout: 50 * time.Millisecond})
+
+	release, err := AdmitConnection()
+	if err != nil {
+		t.Fatalf("Expected a connection slot, got %v\n", err)
+	}
+	if _, err := AdmitConnection(); err != ErrRouterBusy {
+		t.Errorf("Expected a queued connection to time out, got %v\n", err)
+	}
+
+	admitted := make(chan error)
+	go func() {
+		release, err := AdmitConnection()
+		if err == nil {
+			release()
+		}
+		admitted <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := AdmitConnection(); err != ErrRouterBusy {
+		t.Errorf("Expected a full queue to refuse the connection, got %v\n", err)
+	}
+	release()
+	if err := <-admitted; err != nil {
+		t.Errorf("Expected the queued connection to take the freed slot, got %v\n", err)
+	}
+}
+
+func TestWriteTicketToNodes(t *testing.T) {
+	defer UseAdmission(admission)
+	UseAdmission(Admission{QueueTimeout: 200 * time.Millisecond, NodeBackoff: time.Second})
+
+	busy := &fakeWriteNode{status: NodeBusy}
+	idle := &fakeWriteNode{status: NodeSuccess}
+	addresses := []string{serveFakeWriteNode(t, busy), serveFakeWriteNode(t, idle)}
+
+	request := &NodeWriteRequest{ObjectId: "admissionObject", TicketId: "admissionTicket1", ByteEnd: 4, ByteCount: 4, Data: []byte("data")}
+	response, index, err := writeTicketToNodes(addresses, 0, request)
+	if err != nil {
+		t.Fatalf("Expected the ticket to be redirected, got %v\n", err)
+	}
+	if index != 1 || response.Status != NodeSuccess {
+		t.Errorf("Expected node 1 to take the ticket, got node %d with status %d\n", index, response.Status)
+	}
+	if busy.writes != 1 || idle.writes != 1 {
+		t.Errorf("Expected one write to each node, got %d and %d\n", busy.writes, idle.writes)
+	}
+
+	// The busy node is skipped while it backs off
+	request.TicketId = "admissionTicket2"
+	if _, index, err = writeTicketToNodes(addresses, 0, request); err != nil || index != 1 {
+		t.Errorf("Expected node 1 to take the ticket, got node %d: %v\n", index, err)
+	}
+	if busy.writes != 1 {
+		t.Errorf("Expected the busy node to be skipped, got %d writes\n", busy.writes)
+	}
+
+	idle.status = NodeBusy
+	request.TicketId = "admissionTicket3"
+	if _, _, err = writeTicketToNodes(addresses, 0, request); err != ErrNodesBusy {
+		t.Errorf("Expected every node to be busy, got %v\n", err)
+	}
+}
+
+func TestAcquireNodePrefersShortQueues(t *testing.T) {
+	defer UseAdmission(admission)
+	UseAdmission(Admission{QueueTimeout: 50 * time.Millisecond, NodeWrites: 1, NodeBackoff: time.Second})
+
+	addresses := []string{"node-a:5002", "node-b:5002"}
+	deadline := time.Now().Add(50 * time.Millisecond)
+
+	index, release, err := acquireNode(addresses, 0, deadline)
+	if err != nil || index != 0 {
+		t.Fatalf("Expected node 0, got %d: %v\n", index, err)
+	}
+	release(&NodeResponse{QueueDepth: 10}, nil)
+
+	if index, release, err = acquireNode(addresses, 0, deadline); err != nil || index != 1 {
+		t.Fatalf("Expected the node with the shorter queue, got %d: %v\n", index, err)
+	}
+	if index, _, err = acquireNode(addresses, 1, deadline); err != nil || index != 0 {
+		t.Errorf("Expected node 0 while node 1 has its most writes, got %d: %v\n", index, err)
+	}
+	if _, _, err = acquireNode(addresses, 0, deadline); err != ErrNodesBusy {
+		t.Errorf("Expected every node to have its most writes, got %v\n", err)
+	}
+	release(nil, nil)
+}
@@ -0,0 +1,249 @@
+// Audit Log
+//
+// When AUDIT_LOG names a file every create, read and delete request, and
+// whether it succeeded, is appended to it as a line of JSON. So are objects
+// the Router deletes on its own, by GC, bucket retention, replacing the
+// object of a key or cleaning up after a shred, with the reason why. Each entry
+// holds the hash of the entry before it and its own hash covers that, so
+// changing or removing an entry breaks the chain from there on
+//
+// 	{"seq":1,"time":"...","op":"create","tenant":"tenant-a","object":"ObjectID","ok":true,"prev":"000...","hash":"..."}
+//
+// 	hash = hex(SHA-256(prev + JSON of the entry without its hash))
+//
+// The first entry follows a hash of 64 zeros. Entries are only appended,
+// a Router reopening the log continues the chain from its last entry.
+package dataputter
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuditCreate = "create"
+	AuditRead   = "read"
+	AuditDelete = "delete"
+	AuditShred  = "shred"
+
+	// Reasons the Router deletes objects on its own
+	AuditAbandoned = "abandoned"
+	AuditExpired   = "expired"
+	AuditReplaced  = "replaced"
+	AuditShredded  = "shredded"
+)
+
+var (
+	// auditGenesis Previous hash of the first entry
+	auditGenesis = strings.Repeat("0", 64)
+	// auditLog: Log operations are appended to, nil without AUDIT_LOG
+	auditLog *AuditLog
+)
+
+func init() {
+	if path := os.Getenv("AUDIT_LOG"); len(path) > 0 {
+		if err := UseAuditLog(path); err != nil {
+			log.Printf("Ignoring AUDIT_LOG=%s: %v\n", path, err)
+		}
+	}
+}
+
+// AuditEntry One operation on an object
+type AuditEntry struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"op"`
+	// Reason: Why the Router deleted an object on its own, empty for requests
+	Reason string `json:"reason,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	// ObjectID: Empty when the request failed before naming one
+	ObjectID string `json:"object,omitempty"`
+	TicketID string `json:"ticket,omitempty"`
+	Success  bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Previous string `json:"prev"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// chainHash The hash of an entry following the hash prev
+func (e AuditEntry) chainHash(prev string) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(prev), b...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditLog An append-only file of hash chained entries
+type AuditLog struct {
+	sync.Mutex
+	f        *os.File
+	seq      int64
+	lastHash string
+}
+
+// OpenAuditLog Opens, or creates, a log and continues from its last entry
+func OpenAuditLog(path string) (*AuditLog, error) {
+	l := &AuditLog{lastHash: auditGenesis}
+	if f, err := os.Open(path); err == nil {
+		err = scanAuditLog(f, func(entry AuditEntry) error {
+			l.seq, l.lastHash = entry.Seq, entry.Hash
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+	return l, nil
+}
+
+// UseAuditLog Appends operations to the log at path, an empty path stops
+// auditing
+func UseAuditLog(path string) error {
+	if auditLog != nil {
+		auditLog.Close()
+		auditLog = nil
+	}
+	if len(path) == 0 {
+		return nil
+	}
+	l, err := OpenAuditLog(path)
+	if err != nil {
+		return err
+	}
+	auditLog = l
+	return nil
+}
+
+// Append Chains an entry to the log and writes it
+func (l *AuditLog) Append(entry AuditEntry) error {
+	l.Lock()
+	defer l.Unlock()
+
+	entry.Seq = l.seq + 1
+	entry.Previous = l.lastHash
+	hash, err := entry.chainHash(l.lastHash)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.seq, l.lastHash = entry.Seq, entry.Hash
+	return nil
+}
+
+func (l *AuditLog) Close() error {
+	return l.f.Close()
+}
+
+// Audit Appends an operation to the audit log in use, failed when err isn't
+// nil
+func Audit(entry AuditEntry, err error) {
+	if auditLog == nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	entry.Success = err == nil
+	if err != nil {
+		entry.Error = strings.TrimSpace(err.Error())
+	}
+	if err := auditLog.Append(entry); err != nil {
+		log.Printf("Unable to audit %s of %s: %v\n", entry.Operation, entry.ObjectID, err)
+	}
+}
+
+// scanAuditLog Calls fn with each entry of a log in order
+func scanAuditLog(r io.Reader, fn func(AuditEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("Unreadable audit entry: %v\n", err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// VerifyAuditLog Checks the chain of every entry, returning how many were
+// verified before it broke
+func VerifyAuditLog(r io.Reader) (int, error) {
+	verified := 0
+	prev := auditGenesis
+	err := scanAuditLog(r, func(entry AuditEntry) error {
+		if entry.Seq != int64(verified+1) {
+			return fmt.Errorf("Audit entry %d follows entry %d\n", entry.Seq, verified)
+		}
+		hash, err := entry.chainHash(prev)
+		if err != nil {
+			return err
+		}
+		if entry.Previous != prev || entry.Hash != hash {
+			return fmt.Errorf("Audit chain broken at entry %d\n", entry.Seq)
+		}
+		prev = entry.Hash
+		verified++
+		return nil
+	})
+	return verified, err
+}
+
+// AuditFilter Entries of an object, a tenant or a time range, empty fields
+// match everything
+type AuditFilter struct {
+	ObjectID, Tenant string
+	Since, Until     time.Time
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	switch {
+	case len(f.ObjectID) > 0 && entry.ObjectID != f.ObjectID:
+		return false
+	case len(f.Tenant) > 0 && entry.Tenant != f.Tenant:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// FilterAuditLog The entries of a log matching the filter
+func FilterAuditLog(r io.Reader, filter AuditFilter) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := scanAuditLog(r, func(entry AuditEntry) error {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
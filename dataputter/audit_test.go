@@ -0,0 +1,94 @@
+package dataputter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTempAuditLog Audits to a new log until the returned func is called
+func useTempAuditLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "audit.log")
+	if err := UseAuditLog(path); err != nil {
+		t.Fatalf("Expected to open the audit log, got %v\n", err)
+	}
+	return path, func() {
+		UseAuditLog("")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	path, cleanup := useTempAuditLog(t)
+	defer cleanup()
+
+	Audit(AuditEntry{Operation: AuditCreate, Tenant: "tenant-a", ObjectID: "AUDIT_A"}, nil)
+	Audit(AuditEntry{Operation: AuditRead, Tenant: "tenant-b", ObjectID: "AUDIT_A"}, ErrForbidden)
+	// Reopening continues the chain
+	UseAuditLog(path)
+	Audit(AuditEntry{Operation: AuditDelete, Tenant: "tenant-a", ObjectID: "AUDIT_A"}, nil)
+	Audit(AuditEntry{Operation: AuditCreate, Tenant: "tenant-b", ObjectID: "AUDIT_B"}, errors.New("Node failed\n"))
+
+	log, _ := ioutil.ReadFile(path)
+	if verified, err := VerifyAuditLog(bytes.NewReader(log)); err != nil || verified != 4 {
+		t.Errorf("Expected 4 verified entries, got %d %v\n", verified, err)
+	}
+
+	entries, _ := FilterAuditLog(bytes.NewReader(log), AuditFilter{ObjectID: "AUDIT_A"})
+	if len(entries) != 3 || entries[1].Success || entries[1].Error != ErrForbidden.Error() {
+		t.Errorf("Expected 3 entries of AUDIT_A with a refused read, got %+v\n", entries)
+	}
+	if entries, _ := FilterAuditLog(bytes.NewReader(log), AuditFilter{Tenant: "tenant-b"}); len(entries) != 2 {
+		t.Errorf("Expected 2 entries of tenant-b, got %+v\n", entries)
+	}
+	if entries, _ := FilterAuditLog(bytes.NewReader(log), AuditFilter{Until: time.Now().Add(-time.Hour)}); len(entries) != 0 {
+		t.Errorf("Expected no entries before an hour ago, got %+v\n", entries)
+	}
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	path, cleanup := useTempAuditLog(t)
+	defer cleanup()
+	for _, objectID := range []string{"AUDIT_1", "AUDIT_2", "AUDIT_3"} {
+		Audit(AuditEntry{Operation: AuditCreate, Tenant: "tenant-a", ObjectID: objectID}, nil)
+	}
+	log, _ := ioutil.ReadFile(path)
+	lines := bytes.SplitAfter(log, []byte("\n"))
+
+	edited := bytes.Replace(log, []byte("AUDIT_2"), []byte("AUDIT_9"), 1)
+	if verified, err := VerifyAuditLog(bytes.NewReader(edited)); err == nil || verified != 1 {
+		t.Errorf("Expected an edited entry to break the chain after 1 entry, got %d %v\n", verified, err)
+	}
+	removed := append(append([]byte{}, lines[0]...), lines[2]...)
+	if verified, err := VerifyAuditLog(bytes.NewReader(removed)); err == nil || verified != 1 {
+		t.Errorf("Expected a removed entry to break the chain after 1 entry, got %d %v\n", verified, err)
+	}
+}
+
+func TestAuditRouterDelete(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+	path, cleanup := useTempAuditLog(t)
+	defer cleanup()
+
+	CreateObject("AUDIT_OBJECT", "AUDIT_T1")
+	SetObjectOwner("AUDIT_OBJECT", "tenant-a")
+	asB := context.WithValue(context.Background(), tenantKey{}, "tenant-b")
+	(&routerServer{}).DeleteObject(asB, &DeleteObjectRequest{ObjectId: "AUDIT_OBJECT"})
+
+	log, _ := ioutil.ReadFile(path)
+	entries, _ := FilterAuditLog(bytes.NewReader(log), AuditFilter{ObjectID: "AUDIT_OBJECT"})
+	if len(entries) != 1 || entries[0].Operation != AuditDelete || entries[0].Tenant != "tenant-b" || entries[0].Success {
+		t.Errorf("Expected the refused delete of tenant-b, got %+v\n", entries)
+	}
+}
@@ -0,0 +1,175 @@
+// Authentication
+//
+// When ROUTER_AUTH is set every create, read and delete request to a Router
+// must carry the API key of a tenant. Objects and buckets record the tenant
+// which created them as their owner. Only the owner, or tenants the policy
+// of a bucket allows, may read, list, stat or delete them. Objects created
+// before authentication have no owner, they can only be managed from the
+// command line.
+//
+// API keys are APIKeyLength characters and are kept by their SHA-256
+//
+// 	apiKeys : Tenant of each API key {sha256(key): tenant}
+//
+// TCP requests carry the key in the 16 bytes reserved for the authenticity
+// token, or before the request when it has none
+//
+// 	[8B "0KEY0KEY"][16B API Key][8B size][data]
+// 	[8B "0KEY0KEY"][16B API Key][8B Bucket Header]...
+// 	[8B Delete Header][13B ObjectID][16B API Key]
+// 	[13B TicketID][16B API Key]
+//
+// gRPC requests carry it in the x-api-key metadata.
+package dataputter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// APIKeyLength Characters in an API key, the size of the authenticity token
+	APIKeyLength = 16
+	// apiKeyMetadata gRPC metadata carrying the API key
+	apiKeyMetadata = "x-api-key"
+)
+
+var (
+	// ErrUnauthenticated A request without a known API key
+	ErrUnauthenticated = errors.New("Unknown API key")
+	// ErrForbidden A tenant asked for something it doesn't own
+	ErrForbidden = errors.New("Not owned by tenant")
+
+	// authRequired: Requests need an API key, ROUTER_AUTH
+	authRequired = false
+)
+
+func init() {
+	if len(os.Getenv("ROUTER_AUTH")) > 0 {
+		log.Printf("Requests need the API key of a tenant\n")
+		authRequired = true
+	}
+}
+
+// tenantKey Context key of the tenant of a gRPC request
+type tenantKey struct{}
+
+// apiKeyHash The SHA-256 an API key is kept by
+func apiKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey Creates a new API key of a tenant, it can't be retrieved again
+// * Has Datastore access
+func CreateAPIKey(tenant string) (string, error) {
+	if !bucketNamePattern.MatchString(tenant) {
+		return "", fmt.Errorf("Invalid tenant name '%s'\n", tenant)
+	}
+	b := make([]byte, APIKeyLength*3/4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(b)
+	return key, metadataStore.PutAPIKey(apiKeyHash(key), tenant)
+}
+
+// RevokeAPIKey Deletes an API key
+// * Has Datastore access
+func RevokeAPIKey(key string) error {
+	return metadataStore.DeleteAPIKey(apiKeyHash(key))
+}
+
+// Authenticate The tenant of an API key, ErrUnauthenticated when it's
+// unknown. Always an empty tenant when authentication is off.
+// * Has Datastore access
+func Authenticate(key string) (string, error) {
+	if !authRequired {
+		return "", nil
+	}
+	tenant, err := metadataStore.GetAPIKeyTenant(apiKeyHash(key))
+	if err != nil {
+		return "", err
+	}
+	if len(tenant) == 0 {
+		return "", ErrUnauthenticated
+	}
+	return tenant, nil
+}
+
+// AuthorizeObject ErrForbidden unless the tenant owns the object or the
+// policy of its bucket allows the access to its key
+// * Has Datastore access
+func AuthorizeObject(tenant, objectID, access string) error {
+	if !authRequired {
+		return nil
+	}
+	object, err := GetObjectMetadata(objectID)
+	if err != nil {
+		return err
+	}
+	if len(object.Owner) > 0 && object.Owner == tenant {
+		return nil
+	}
+	if len(object.Bucket) == 0 {
+		return ErrForbidden
+	}
+	bucket, err := GetBucket(object.Bucket)
+	if err == ErrBucketNotExist {
+		return ErrForbidden
+	}
+	if err != nil {
+		return err
+	}
+	return AuthorizeBucket(tenant, bucket, object.Key, access)
+}
+
+// readAPIKey Reads the API key of a TCP request and authenticates it
+// * Has Datastore access
+func readAPIKey(r io.Reader) (string, error) {
+	key := make([]byte, APIKeyLength)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return "", err
+	}
+	return Authenticate(string(key))
+}
+
+// authInterceptor Authenticates gRPC requests with their x-api-key, putting
+// the tenant in their context
+func authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !authRequired {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(apiKeyMetadata)
+	if len(keys) != 1 {
+		return nil, status.Error(codes.Unauthenticated, ErrUnauthenticated.Error())
+	}
+	tenant, err := Authenticate(keys[0])
+	if err == ErrUnauthenticated {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, tenantKey{}, tenant), req)
+}
+
+// tenantOf The tenant of a gRPC request, empty when authentication is off
+func tenantOf(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
@@ -0,0 +1,205 @@
+package dataputter
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	grpc "google.golang.org/grpc"
+)
+
+// useAuth Requires API keys until the returned func is called
+func useAuth() func() {
+	previous := authRequired
+	authRequired = true
+	return func() { authRequired = previous }
+}
+
+func TestAPIKeys(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+
+	key, err := CreateAPIKey("tenant-a")
+	if err != nil || len(key) != APIKeyLength {
+		t.Fatalf("Expected a %d character key, got %s %v\n", APIKeyLength, key, err)
+	}
+	if tenant, err := Authenticate(key); err != nil || tenant != "tenant-a" {
+		t.Errorf("Expected tenant-a, got %s %v\n", tenant, err)
+	}
+	if _, err := Authenticate("0123456789abcdef"); err != ErrUnauthenticated {
+		t.Errorf("Expected ErrUnauthenticated, got %v\n", err)
+	}
+	if _, err := CreateAPIKey("A"); err == nil {
+		t.Errorf("Expected an invalid tenant name to be refused\n")
+	}
+
+	RevokeAPIKey(key)
+	if _, err := Authenticate(key); err != ErrUnauthenticated {
+		t.Errorf("Expected a revoked key to be refused, got %v\n", err)
+	}
+}
+
+func TestAuthorizeObject(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	CreateObject("AUTH_OBJECT", "AUTH_T1")
+	SetObjectOwner("AUTH_OBJECT", "tenant-a")
+	CreateObject("AUTH_UNOWNED", "AUTH_T2")
+
+	if err := AuthorizeObject("", "AUTH_UNOWNED", AccessRead); err != nil {
+		t.Errorf("Expected every object to be allowed without authentication, got %v\n", err)
+	}
+
+	defer useAuth()()
+	if err := AuthorizeObject("tenant-a", "AUTH_OBJECT", AccessRead); err != nil {
+		t.Errorf("Expected the owner to be allowed, got %v\n", err)
+	}
+	if err := AuthorizeObject("tenant-b", "AUTH_OBJECT", AccessRead); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for another tenant, got %v\n", err)
+	}
+	if err := AuthorizeObject("tenant-a", "AUTH_UNOWNED", AccessRead); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for an object without an owner, got %v\n", err)
+	}
+}
+
+func TestRouterRPCTenants(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+
+	CreateObject("TENANT_A_OBJECT", "TENANT_T1")
+	SetObjectOwner("TENANT_A_OBJECT", "tenant-a")
+	CreateObject("TENANT_B_OBJECT", "TENANT_T2")
+	SetObjectOwner("TENANT_B_OBJECT", "tenant-b")
+
+	s := &routerServer{}
+	asA := context.WithValue(context.Background(), tenantKey{}, "tenant-a")
+
+	listed, err := s.ListObjects(asA, &ListObjectsRequest{})
+	if err != nil || len(listed.Objects) != 1 || listed.Objects[0].ObjectId != "TENANT_A_OBJECT" {
+		t.Errorf("Expected only the objects of tenant-a, got %v %v\n", listed, err)
+	}
+	if response, _ := s.StatObject(asA, &StatObjectRequest{ObjectId: "TENANT_B_OBJECT"}); response.Status != NodeNotAuthorized {
+		t.Errorf("Expected NotAuthorized stating another tenant's object, got %d\n", response.Status)
+	}
+	if response, _ := s.DeleteObject(asA, &DeleteObjectRequest{ObjectId: "TENANT_B_OBJECT"}); response.Status != NodeNotAuthorized {
+		t.Errorf("Expected NotAuthorized deleting another tenant's object, got %d\n", response.Status)
+	}
+
+	if response, _ := s.CreateBucket(asA, &CreateBucketRequest{Name: "tenant-a-photos"}); response.Status != NodeSuccess {
+		t.Fatalf("Expected to create a bucket, got %d\n", response.Status)
+	}
+	asB := context.WithValue(context.Background(), tenantKey{}, "tenant-b")
+	if response, _ := s.ListBucketKeys(asB, &ListBucketKeysRequest{Bucket: "tenant-a-photos"}); response.Status != NodeNotAuthorized {
+		t.Errorf("Expected NotAuthorized listing another tenant's bucket, got %d\n", response.Status)
+	}
+	if buckets, _ := s.ListBuckets(asB, &ListBucketsRequest{}); len(buckets.Buckets) != 0 {
+		t.Errorf("Expected no buckets of tenant-b, got %v\n", buckets.Buckets)
+	}
+
+	// Requests without a key never reach the service
+	_, err = authInterceptor(context.Background(), &ListBucketsRequest{}, nil,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Errorf("Expected the request to be refused\n")
+			return nil, nil
+		},
+	)
+	if err == nil {
+		t.Errorf("Expected an unauthenticated error\n")
+	}
+}
+
+func TestDeleteRequestAPIKey(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+
+	keyA, _ := CreateAPIKey("tenant-a")
+	keyB, _ := CreateAPIKey("tenant-b")
+	objectID := FormatID(42)
+	CreateObject(objectID, "")
+	SetObjectOwner(objectID, "tenant-a")
+
+	deleteRequest := func(key string) string {
+		client, server := net.Pipe()
+		defer client.Close()
+		done := make(chan error)
+		go func() { done <- doDeleteObject(server, false) }()
+		client.Write([]byte(objectID + key))
+		reply := make([]byte, 8)
+		io.ReadFull(client, reply)
+		client.Close()
+		<-done
+		return string(reply)
+	}
+	if reply := deleteRequest(keyB); reply != "_FAILED_" {
+		t.Errorf("Expected another tenant's delete to fail, got %s\n", reply)
+	}
+	if status, _ := GetObjectStatus(objectID); len(status) == 0 {
+		t.Errorf("Expected the object to be kept\n")
+	}
+	if reply := deleteRequest(keyA); reply != objectID[:8] {
+		t.Errorf("Expected the owner's delete to succeed, got %s\n", reply)
+	}
+
+	// IDs granted before IDLength are sent with their length
+	legacyID := "00000042"
+	CreateObject(legacyID, "")
+	SetObjectOwner(legacyID, "tenant-a")
+	client, server := net.Pipe()
+	done := make(chan error)
+	go func() { done <- doDeleteObject(server, false) }()
+	client.Write(append(WireID(legacyID), keyA...))
+	reply := make([]byte, len(legacyID)+1)
+	io.ReadFull(client, reply)
+	client.Close()
+	<-done
+	if string(reply) != string(WireID(legacyID)) {
+		t.Errorf("Expected the delete of %s to succeed, got %q\n", legacyID, reply)
+	}
+}
+
+// readNodeClient Answers every read with success
+type readNodeClient struct {
+	WriteNodeClient
+}
+
+func (readNodeClient) Read(ctx context.Context, request *NodeReadRequest, opts ...grpc.CallOption) (*NodeResponse, error) {
+	return &NodeResponse{Status: NodeSuccess, TicketId: request.TicketId, ObjectId: request.ObjectId}, nil
+}
+
+func TestReadRequestObject(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+
+	keyB, _ := CreateAPIKey("tenant-b")
+	objectA, objectB, objectC, ticketID := FormatID(1), FormatID(2), FormatID(3), FormatID(4)
+	// A chunk tenant-a stored and tenant-b's object references
+	CreateTicket(ticketID, objectA, "TEST_NODE_ID", 0, 4, 4)
+	AddTicketReference(objectB, ticketID, 0)
+	SetObjectOwner(objectA, "tenant-a")
+	SetObjectOwner(objectB, "tenant-b")
+	SetObjectOwner(objectC, "tenant-b")
+
+	readRequest := func(objectID string) error {
+		client, server := net.Pipe()
+		done := make(chan error)
+		go func() { done <- ServeTicketBytes(server, readNodeClient{}) }()
+		client.Write([]byte(ticketID + objectID + keyB))
+		client.Close()
+		return <-done
+	}
+	if err := readRequest(objectB); err != nil {
+		t.Errorf("Expected tenant-b to read the ticket through its object, got %v\n", err)
+	}
+	if err := readRequest(objectA); err != ErrForbidden {
+		t.Errorf("Expected tenant-b to be refused the object of tenant-a, got %v\n", err)
+	}
+	if err := readRequest(objectC); err != ErrTicketNotInObject {
+		t.Errorf("Expected a ticket its object doesn't reference to be refused, got %v\n", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Buckets
+//
+// A bucket is a named namespace of objects. Objects are stored under a key
+// chosen by the client and the key resolves to the ObjectID the Router
+// granted. Storing an object under a key which is taken replaces the object
+// it held.
+//
+// 	buckets                 : Set of bucket names
+// 	/buckets/name           : Settings of a bucket {compression, retention, createdAt}
+// 	bucketKeys/name         : ObjectID of each key {key: ObjectID}
+// 	bucketIndex/name        : Keys sorted for prefix listing
+//
+// Settings of a bucket
+//
+// 	compression : Codec WriteNodes compress the objects of the bucket with
+// 	retention   : Age after which objects are deleted by garbage collection, 0 keeps them
+package dataputter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrBucketExists   = errors.New("Bucket already exists")
+	ErrBucketNotExist = errors.New("Bucket does not exist")
+	ErrBucketNotEmpty = errors.New("Bucket is not empty")
+
+	// bucketNamePattern: Lowercase letters, digits, dots and dashes
+	bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{2,62}$`)
+)
+
+const (
+	// MaxBucketKeyLength Bytes in the longest key
+	MaxBucketKeyLength = 1024
+)
+
+// Bucket A namespace of objects and its settings
+type Bucket struct {
+	Name        string        `json:"name"`
+	Compression string        `json:"compression,omitempty"`
+	Retention   time.Duration `json:"retention,omitempty"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	// Owner: Tenant which created the bucket, empty without authentication
+	Owner string `json:"owner,omitempty"`
+}
+
+// BucketKey A key of a bucket and the object it holds
+type BucketKey struct {
+	Key      string `json:"key"`
+	ObjectID string `json:"object"`
+}
+
+// ValidateBucket Checks the name and settings of a bucket
+func ValidateBucket(bucket Bucket) error {
+	if !bucketNamePattern.MatchString(bucket.Name) {
+		return fmt.Errorf("Invalid bucket name '%s'\n", bucket.Name)
+	}
+	if len(bucket.Compression) > 0 && !IsCompressionCodec(bucket.Compression) {
+		return fmt.Errorf("Unknown compression %s of bucket %s\n", bucket.Compression, bucket.Name)
+	}
+	if bucket.Retention < 0 {
+		return fmt.Errorf("Invalid retention %s of bucket %s\n", bucket.Retention, bucket.Name)
+	}
+	return nil
+}
+
+// validateBucketKey Keys are between 1 and MaxBucketKeyLength bytes
+func validateBucketKey(key string) error {
+	if len(key) == 0 || len(key) > MaxBucketKeyLength {
+		return fmt.Errorf("Invalid key of %d bytes\n", len(key))
+	}
+	return nil
+}
+
+// CreateBucket Creates an empty bucket, ErrBucketExists when the name is taken
+// * Has Datastore access
+func CreateBucket(bucket Bucket) error {
+	if err := ValidateBucket(bucket); err != nil {
+		return err
+	}
+	bucket.CreatedAt = time.Now()
+	return metadataStore.CreateBucket(bucket)
+}
+
+// GetBucket The settings of a bucket, ErrBucketNotExist when there's none
+func GetBucket(name string) (Bucket, error) {
+	return metadataStore.GetBucket(name)
+}
+
+// GetBuckets Names of every bucket
+func GetBuckets() ([]string, error) {
+	return metadataStore.GetBuckets()
+}
+
+// DeleteBucket Deletes a bucket without keys, ErrBucketNotEmpty otherwise
+func DeleteBucket(name string) error {
+	return metadataStore.DeleteBucket(name)
+}
+
+// PutObjectKey Stores an object under a key of a bucket, returning the
+// ObjectID the key held before. The object records its key for policies.
+func PutObjectKey(bucketName, key, objectID string) (string, error) {
+	if err := validateBucketKey(key); err != nil {
+		return "", err
+	}
+	previous, err := metadataStore.PutBucketKey(bucketName, key, objectID)
+	if err != nil {
+		return previous, err
+	}
+	return previous, metadataStore.SetObjectKey(objectID, bucketName, key)
+}
+
+// GetObjectKey The ObjectID a key of a bucket holds, empty when none
+func GetObjectKey(bucketName, key string) (string, error) {
+	return metadataStore.GetBucketKey(bucketName, key)
+}
+
+// DeleteObjectKey Deletes a key of a bucket and the object it held
+// * Has Datastore access
+func DeleteObjectKey(bucketName, key string) (string, error) {
+	objectID, err := metadataStore.DeleteBucketKey(bucketName, key)
+	if err != nil || len(objectID) == 0 {
+		return objectID, err
+	}
+	if _, err := DeleteObject(objectID); err != nil {
+		log.Printf("Unable to delete %s of %s/%s: %v\n", objectID, bucketName, key, err)
+		return objectID, err
+	}
+	return objectID, nil
+}
+
+// ListBucketKeys A page of up to limit keys of a bucket starting with
+// prefix, in order. Returns the cursor of the next page, empty after the
+// last one.
+func ListBucketKeys(bucketName, prefix, cursor string, limit int) ([]BucketKey, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	if _, err := GetBucket(bucketName); err != nil {
+		return []BucketKey{}, "", err
+	}
+	return metadataStore.ScanBucketKeys(bucketName, prefix, cursor, limit)
+}
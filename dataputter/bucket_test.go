@@ -0,0 +1,137 @@
+package dataputter
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBuckets(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	if err := CreateBucket(Bucket{Name: "No_Caps"}); err == nil {
+		t.Errorf("Expected an invalid bucket name to be refused\n")
+	}
+	if err := CreateBucket(Bucket{Name: "photos", Compression: "lzma"}); err == nil {
+		t.Errorf("Expected an unknown compression to be refused\n")
+	}
+	if err := CreateBucket(Bucket{Name: "photos", Compression: CompressionZstd}); err != nil {
+		t.Fatalf("Expected to create bucket photos, got %v\n", err)
+	}
+	if err := CreateBucket(Bucket{Name: "photos"}); err != ErrBucketExists {
+		t.Errorf("Expected ErrBucketExists, got %v\n", err)
+	}
+	bucket, err := GetBucket("photos")
+	if err != nil || bucket.Compression != CompressionZstd {
+		t.Errorf("Expected photos compressed with zstd, got %+v %v\n", bucket, err)
+	}
+
+	for key, objectID := range map[string]string{
+		"2021/01/a.jpg": "OBJECT_A",
+		"2021/01/b.jpg": "OBJECT_B",
+		"2021/02/c.jpg": "OBJECT_C",
+		"2022/01/d.jpg": "OBJECT_D",
+	} {
+		CreateObject(objectID, "TICKET")
+		PutObjectKey("photos", key, objectID)
+	}
+	if _, err := PutObjectKey("missing", "key", "OBJECT_A"); err != ErrBucketNotExist {
+		t.Errorf("Expected ErrBucketNotExist, got %v\n", err)
+	}
+	if previous, _ := PutObjectKey("photos", "2021/01/a.jpg", "OBJECT_E"); previous != "OBJECT_A" {
+		t.Errorf("Expected to replace OBJECT_A, got %s\n", previous)
+	}
+	if objectID, _ := GetObjectKey("photos", "2021/01/a.jpg"); objectID != "OBJECT_E" {
+		t.Errorf("Expected OBJECT_E, got %s\n", objectID)
+	}
+
+	keys, cursor, err := ListBucketKeys("photos", "2021/", "", 2)
+	if err != nil || len(keys) != 2 || keys[0].Key != "2021/01/a.jpg" || len(cursor) == 0 {
+		t.Errorf("Expected the first 2 keys of 2021 and a cursor, got %v %s %v\n", keys, cursor, err)
+	}
+	keys, cursor, _ = ListBucketKeys("photos", "2021/", cursor, 2)
+	if len(keys) != 1 || keys[0].Key != "2021/02/c.jpg" || len(cursor) != 0 {
+		t.Errorf("Expected the last key of 2021, got %v %s\n", keys, cursor)
+	}
+
+	if err := DeleteBucket("photos"); err != ErrBucketNotEmpty {
+		t.Errorf("Expected ErrBucketNotEmpty, got %v\n", err)
+	}
+	for _, key := range []string{"2021/01/a.jpg", "2021/01/b.jpg", "2021/02/c.jpg", "2022/01/d.jpg"} {
+		if _, err := DeleteObjectKey("photos", key); err != nil {
+			t.Errorf("Expected to delete %s, got %v\n", key, err)
+		}
+	}
+	if err := DeleteBucket("photos"); err != nil {
+		t.Errorf("Expected to delete empty bucket photos, got %v\n", err)
+	}
+	if _, err := GetBucket("photos"); err != ErrBucketNotExist {
+		t.Errorf("Expected photos to be gone, got %v\n", err)
+	}
+}
+
+func TestDeleteBucketedObject(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	CreateBucket(Bucket{Name: "photos"})
+	for key, objectID := range map[string]string{"a.jpg": "OBJECT_A", "b.jpg": "OBJECT_B"} {
+		CreateObject(objectID, "")
+		PutObjectKey("photos", key, objectID)
+	}
+	// OBJECT_B no longer holds the key it records
+	CreateObject("OBJECT_C", "")
+	PutObjectKey("photos", "b.jpg", "OBJECT_C")
+
+	for _, objectID := range []string{"OBJECT_A", "OBJECT_B"} {
+		if _, err := DeleteObject(objectID); err != nil {
+			t.Fatalf("Expected to delete %s, got %v\n", objectID, err)
+		}
+	}
+	if objectID, _ := GetObjectKey("photos", "a.jpg"); len(objectID) > 0 {
+		t.Errorf("Expected a.jpg to be deleted with its object, got %s\n", objectID)
+	}
+	if objectID, _ := GetObjectKey("photos", "b.jpg"); objectID != "OBJECT_C" {
+		t.Errorf("Expected b.jpg to keep OBJECT_C, got %s\n", objectID)
+	}
+
+	DeleteObject("OBJECT_C")
+	if err := DeleteBucket("photos"); err != nil {
+		t.Errorf("Expected to delete photos once its objects are deleted, got %v\n", err)
+	}
+}
+
+func TestExpireBucketObjects(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	path, cleanup := useTempAuditLog(t)
+	defer cleanup()
+
+	CreateBucket(Bucket{Name: "logs", Retention: time.Nanosecond})
+	CreateBucket(Bucket{Name: "keep"})
+	CreateObject("OLD_LOG", "TICKET")
+	PutObjectKey("logs", "old.log", "OLD_LOG")
+	CreateObject("KEPT", "TICKET")
+	PutObjectKey("keep", "kept.log", "KEPT")
+	time.Sleep(time.Millisecond)
+
+	records, err := ExpireBucketObjects()
+	if err != nil || len(records) != 1 || records[0].Key != "old.log" {
+		t.Errorf("Expected old.log to expire, got %v %v\n", records, err)
+	}
+	if objectID, _ := GetObjectKey("logs", "old.log"); len(objectID) > 0 {
+		t.Errorf("Expected old.log to be deleted, got %s\n", objectID)
+	}
+	if objectID, _ := GetObjectKey("keep", "kept.log"); objectID != "KEPT" {
+		t.Errorf("Expected kept.log to be kept, got %s\n", objectID)
+	}
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	entries, _ := FilterAuditLog(f, AuditFilter{ObjectID: "OLD_LOG"})
+	if len(entries) != 1 || entries[0].Reason != AuditExpired {
+		t.Errorf("Expected the expiry of OLD_LOG to be audited, got %+v\n", entries)
+	}
+}
@@ -0,0 +1,186 @@
+// Chunking
+//
+// The Router splits the bytes of an object into tickets with a Chunker.
+//
+// By default each read of up to 1450 bytes from the client becomes a ticket.
+// With ROUTER_CHUNKING=cdc the boundaries are content defined using FastCDC,
+// a gear rolling hash over the bytes, so inserting bytes into an object only
+// changes the tickets around the insertion. Chunk sizes are bounded by
+// ROUTER_CDC_MIN and ROUTER_CDC_MAX and average ROUTER_CDC_AVG bytes.
+package dataputter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"os"
+	"strconv"
+)
+
+const (
+	// Chunks are each read of up to fixedChunkSize bytes
+	ChunkingFixed = "fixed"
+	// Chunks are cut where the rolling hash of the content says so
+	ChunkingCDC = "cdc"
+
+	fixedChunkSize = 1450
+)
+
+var (
+	// chunking: How the Router splits objects into tickets
+	chunking = ChunkingFixed
+
+	cdcMinSize = 1024
+	cdcAvgSize = 4096
+	cdcMaxSize = 16384
+
+	// gearTable: Random values mixed into the rolling hash for each byte.
+	// Must never change or identical content will be chunked differently.
+	gearTable = newGearTable(0x6461746150757474)
+)
+
+func init() {
+	mode := os.Getenv("ROUTER_CHUNKING")
+	if len(mode) > 0 {
+		chunking = mode
+	}
+
+	sizes := map[string]*int{
+		"ROUTER_CDC_MIN": &cdcMinSize,
+		"ROUTER_CDC_AVG": &cdcAvgSize,
+		"ROUTER_CDC_MAX": &cdcMaxSize,
+	}
+	for name, size := range sizes {
+		value := os.Getenv(name)
+		if len(value) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Ignoring %s=%s: %v\n", name, value, err)
+			continue
+		}
+		*size = n
+	}
+}
+
+// Chunker Splits a stream of object bytes into the data of tickets
+type Chunker interface {
+	// Next Bytes of the next ticket, io.EOF once there are none
+	Next() ([]byte, error)
+}
+
+// NewChunker Creates the configured Chunker for contentLength bytes of an object
+func NewChunker(r io.Reader, contentLength int64) (Chunker, error) {
+	switch chunking {
+	case ChunkingFixed:
+		return &fixedChunker{r: r, size: fixedChunkSize}, nil
+	case ChunkingCDC:
+		return NewCDCChunker(io.LimitReader(r, contentLength), cdcMinSize, cdcAvgSize, cdcMaxSize)
+	}
+	return nil, fmt.Errorf("Unknown chunking mode %s\n", chunking)
+}
+
+type fixedChunker struct {
+	r    io.Reader
+	size int
+}
+
+// Next Whatever a single read returns, up to the chunk size
+func (f *fixedChunker) Next() ([]byte, error) {
+	data := make([]byte, f.size)
+	n, err := f.r.Read(data)
+	return data[:n], err
+}
+
+// CDCChunker Content defined chunking with FastCDC
+type CDCChunker struct {
+	r                  *bufio.Reader
+	min, avg, max      int
+	smallMask, bigMask uint64
+}
+
+// NewCDCChunker Chunks r into chunks of min to max bytes averaging avg bytes
+func NewCDCChunker(r io.Reader, min, avg, max int) (*CDCChunker, error) {
+	if min <= 0 || min >= avg || avg >= max {
+		return nil, fmt.Errorf("Chunk sizes must be 0 < min %d < avg %d < max %d\n", min, avg, max)
+	}
+	// Normalized chunking: cuts are harder to find before the average size
+	// and easier after it, which narrows the spread of chunk sizes
+	avgBits := bits.Len(uint(avg)) - 1
+	return &CDCChunker{
+		r:         bufio.NewReaderSize(r, max),
+		min:       min,
+		avg:       avg,
+		max:       max,
+		smallMask: topBitsMask(avgBits + 2),
+		bigMask:   topBitsMask(avgBits - 2),
+	}, nil
+}
+
+// Next Bytes up to the next content defined boundary
+func (c *CDCChunker) Next() ([]byte, error) {
+	window, err := c.r.Peek(c.max)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if len(window) == 0 {
+		return nil, io.EOF
+	}
+
+	cut := c.cutPoint(window)
+	chunk := make([]byte, cut)
+	copy(chunk, window)
+	_, err = c.r.Discard(cut)
+	return chunk, err
+}
+
+func (c *CDCChunker) cutPoint(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	normal := c.avg
+	if n < normal {
+		normal = n
+	}
+
+	var hash uint64
+	i := c.min
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&c.smallMask == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&c.bigMask == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// topBitsMask The highest n bits of a hash, which mix in the most bytes
+func topBitsMask(n int) uint64 {
+	if n < 1 {
+		n = 1
+	}
+	return ^uint64(0) << uint(64-n)
+}
+
+// newGearTable Deterministic table from a splitmix64 sequence
+func newGearTable(seed uint64) [256]uint64 {
+	table := [256]uint64{}
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
@@ -0,0 +1,72 @@
+package dataputter
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func cdcChunks(t *testing.T, data []byte) [][]byte {
+	chunker, err := NewCDCChunker(bytes.NewReader(data), 256, 1024, 4096)
+	if err != nil {
+		t.Fatalf("Expected a chunker, got %v\n", err)
+	}
+	chunks := [][]byte{}
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatalf("Expected chunks, got %v\n", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestCDCChunkerBounds(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := cdcChunks(t, data)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Errorf("Expected chunks to reassemble the data\n")
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 4096 {
+			t.Errorf("Expected chunk %d to be at most 4096 bytes, got %d\n", i, len(chunk))
+		}
+		if len(chunk) < 256 && i != len(chunks)-1 {
+			t.Errorf("Expected chunk %d to be at least 256 bytes, got %d\n", i, len(chunk))
+		}
+	}
+}
+
+func TestCDCChunkerShiftedData(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	shifted := append([]byte{'!'}, data...)
+
+	original := map[string]bool{}
+	for _, chunk := range cdcChunks(t, data) {
+		original[HashTicketData(chunk)] = true
+	}
+
+	chunks := cdcChunks(t, shifted)
+	shared := 0
+	for _, chunk := range chunks {
+		if original[HashTicketData(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(chunks)-2 {
+		t.Errorf("Expected all but the first chunks to be shared, got %d of %d\n", shared, len(chunks))
+	}
+}
+
+func TestNewCDCChunkerSizes(t *testing.T) {
+	if _, err := NewCDCChunker(bytes.NewReader(nil), 4096, 1024, 8192); err == nil {
+		t.Errorf("Expected an error when min is larger than avg\n")
+	}
+}
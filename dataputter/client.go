@@ -8,9 +8,11 @@
 package dataputter
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
-	"net"
 )
 
 // PutterNode of a DataPutter which can write bytes to disk
@@ -32,7 +34,7 @@ type Node interface {
 // PutToTarget Submit a write ticket to a writer node
 func PutToTarget(wt WriteTicket, putter Node) (WriteTicket, error) {
 
-	c, err := net.Dial("tcp", putter.String())
+	c, err := dialTCP(putter.String())
 	if err != nil {
 		return wt, err
 	}
@@ -57,3 +59,71 @@ func PutToTarget(wt WriteTicket, putter Node) (WriteTicket, error) {
 	log.Printf("Sent %d bytes of data\n", n)
 	return wt, nil
 }
+
+// readNodeReply Reads the 8 byte size, _FAILED_ or _DECRYPT a node answers with
+func readNodeReply(r io.Reader) (uint64, error) {
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(r, reply); err != nil {
+		return 0, err
+	}
+	if bytes.Equal(reply, []byte(REPLY_DECRYPT_FAILED)) {
+		return 0, ErrDecryptFailed
+	}
+	if bytes.Equal(reply, []byte(REPLY_FAILED)) {
+		return 0, fmt.Errorf("Node replied %s\n", REPLY_FAILED)
+	}
+	return binary.BigEndian.Uint64(reply), nil
+}
+
+// StoreOnTarget Stores a write ticket on a writer node with its compression,
+// encrypted when it has a DataKey. Returns the number of bytes the node wrote to disk and the codec it used.
+func StoreOnTarget(wt WriteTicket, putter Node) (int64, string, error) {
+	c, err := dialTCP(putter.String())
+	if err != nil {
+		return 0, CompressionNone, err
+	}
+	defer c.Close()
+
+	request := append([]byte(NODE_PUT_HEADER), WireID(string(wt.TicketID))...)
+	request = append(request, wt.Checksum...)
+	request = append(append(request, byte(len(wt.Compression))), wt.Compression...)
+	request = append(append(request, byte(len(wt.DataKey))), wt.DataKey...)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(wt.Data)))
+	if _, err := c.Write(append(append(request, size...), wt.Data...)); err != nil {
+		return 0, CompressionNone, err
+	}
+
+	storedByteCount, err := readNodeReply(c)
+	if err != nil {
+		return 0, CompressionNone, err
+	}
+	codec, err := readShortField(c)
+	return int64(storedByteCount), string(codec), err
+}
+
+// ReadFromTarget Reads the data of a ticket back from a writer node as it
+// was stored. Encrypted tickets need the dataKey they were stored with,
+// ErrDecryptFailed otherwise.
+func ReadFromTarget(ticketID string, dataKey []byte, putter Node) ([]byte, error) {
+	c, err := dialTCP(putter.String())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	request := append([]byte(NODE_GET_HEADER), WireID(ticketID)...)
+	if _, err := c.Write(append(append(request, byte(len(dataKey))), dataKey...)); err != nil {
+		return nil, err
+	}
+	size, err := readNodeReply(c)
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxTicketBytes {
+		return nil, ErrTicketTooLarge
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(c, data)
+	return data, err
+}
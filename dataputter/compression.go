@@ -0,0 +1,130 @@
+// Compression
+//
+// WriteNodes can compress ticket data before it is written to disk. The
+// codec is chosen per node with NODE_COMPRESSION or per object through
+// NodeWriteRequest.Compression.
+//
+// Compressed tickets are framed so they can be told apart from raw tickets
+//
+//     [4B "DPZ1"][1B Codec][nB Compressed Data]
+//
+// Tickets which don't shrink are stored raw, without a frame, which is also
+// how every ticket written before compression existed looks on disk. Raw data
+// which happens to begin with a frame magic is framed with the codec 0.
+package dataputter
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Data is stored as it was received
+	CompressionNone = "none"
+	// Data is stored as a zstd frame
+	CompressionZstd = "zstd"
+	// Data is stored as a snappy block
+	CompressionSnappy = "snappy"
+)
+
+var (
+	// nodeCompression: Codec used when a write doesn't ask for one
+	nodeCompression = CompressionNone
+
+	compressionMagic = []byte("DPZ1")
+
+	// On-disk codec byte of each compression name
+	compressionCodes = map[string]byte{
+		CompressionZstd:   1,
+		CompressionSnappy: 2,
+	}
+
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func init() {
+	codec := os.Getenv("NODE_COMPRESSION")
+	if len(codec) == 0 {
+		return
+	}
+	if !IsCompressionCodec(codec) {
+		log.Printf("Ignoring unknown NODE_COMPRESSION %s\n", codec)
+		return
+	}
+	nodeCompression = codec
+}
+
+// IsCompressionCodec True when codec names a supported compression codec
+func IsCompressionCodec(codec string) bool {
+	if codec == CompressionNone {
+		return true
+	}
+	_, ok := compressionCodes[codec]
+	return ok
+}
+
+// compressTicketData Compresses data with codec, returning the bytes to store
+// and the codec which was actually used. Data which doesn't shrink is
+// returned as it was given with CompressionNone.
+func compressTicketData(codec string, data []byte) ([]byte, string) {
+	if len(codec) == 0 {
+		codec = nodeCompression
+	}
+	code, ok := compressionCodes[codec]
+	if !ok {
+		return storeUncompressed(data), CompressionNone
+	}
+
+	var compressed []byte
+	switch codec {
+	case CompressionZstd:
+		compressed = zstdEncoder.EncodeAll(data, nil)
+	case CompressionSnappy:
+		compressed = s2.EncodeSnappy(nil, data)
+	}
+
+	if len(compressionMagic)+1+len(compressed) >= len(data) {
+		return storeUncompressed(data), CompressionNone
+	}
+	return frameCompressed(code, compressed), codec
+}
+
+func frameCompressed(code byte, compressed []byte) []byte {
+	framed := make([]byte, 0, len(compressionMagic)+1+len(compressed))
+	framed = append(framed, compressionMagic...)
+	framed = append(framed, code)
+	return append(framed, compressed...)
+}
+
+// storeUncompressed Frames raw data only when it could be mistaken for a frame
+func storeUncompressed(data []byte) []byte {
+	if bytes.HasPrefix(data, compressionMagic) || bytes.HasPrefix(data, encryptionMagic) {
+		return frameCompressed(0, data)
+	}
+	return data
+}
+
+// decompressTicketData Reverses compressTicketData. Unframed data is raw.
+func decompressTicketData(stored []byte) ([]byte, error) {
+	if len(stored) <= len(compressionMagic) || !bytes.HasPrefix(stored, compressionMagic) {
+		return stored, nil
+	}
+	code := stored[len(compressionMagic)]
+	compressed := stored[len(compressionMagic)+1:]
+
+	switch code {
+	case 0:
+		return compressed, nil
+	case compressionCodes[CompressionZstd]:
+		return zstdDecoder.DecodeAll(compressed, nil)
+	case compressionCodes[CompressionSnappy]:
+		return s2.Decode(nil, compressed)
+	}
+	return nil, fmt.Errorf("Unknown compression codec %d\n", code)
+}
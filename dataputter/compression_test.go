@@ -0,0 +1,144 @@
+package dataputter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestCompressTicketData(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"level":"info","msg":"hello"}`), 48)
+
+	for _, codec := range []string{CompressionZstd, CompressionSnappy} {
+		stored, used := compressTicketData(codec, data)
+		if used != codec {
+			t.Errorf("Expected codec %s, got %s\n", codec, used)
+		}
+		if len(stored) >= len(data) {
+			t.Errorf("Expected %s to shrink %d bytes, got %d\n", codec, len(data), len(stored))
+		}
+
+		restored, err := decompressTicketData(stored)
+		if err != nil {
+			t.Errorf("Expected to decompress %s, got %v\n", codec, err)
+		}
+		if !bytes.Equal(restored, data) {
+			t.Errorf("Expected %s round trip to restore the data\n", codec)
+		}
+	}
+}
+
+func TestCompressTicketDataStoresRaw(t *testing.T) {
+	data := make([]byte, 1450)
+	rand.Read(data)
+
+	stored, used := compressTicketData(CompressionZstd, data)
+	if used != CompressionNone {
+		t.Errorf("Expected random data to be stored raw, got %s\n", used)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Errorf("Expected raw data to be stored unchanged\n")
+	}
+
+	framelike := append([]byte("DPZ1"), data...)
+	stored, _ = compressTicketData(CompressionNone, framelike)
+	restored, err := decompressTicketData(stored)
+	if err != nil || !bytes.Equal(restored, framelike) {
+		t.Errorf("Expected data starting with the frame magic to round trip, got %v\n", err)
+	}
+}
+
+func TestStoreAndReadTicket(t *testing.T) {
+	root, err := ioutil.TempDir("", "dataputter")
+	if err != nil {
+		t.Fatalf("Expected a temporary data root, got %v\n", err)
+	}
+	defer os.RemoveAll(root)
+	defer func(previous string) { dataRoot = previous }(dataRoot)
+	dataRoot = root
+
+	data := bytes.Repeat([]byte("log line\n"), 160)
+	wt := NewWriteTicket(FormatID(1), "CHECKSUM", data)
+
+	storedByteCount, codec, err := wt.Store(CompressionZstd, nil)
+	if err != nil {
+		t.Fatalf("Expected to store ticket, got %v\n", err)
+	}
+	if codec != CompressionZstd || storedByteCount >= int64(len(data)) {
+		t.Errorf("Expected a compressed ticket, got %d bytes as %s\n", storedByteCount, codec)
+	}
+
+	restored, err := ReadTicket(FormatID(1), nil)
+	if err != nil {
+		t.Errorf("Expected to read ticket, got %v\n", err)
+	}
+	if !bytes.Equal(restored, data) {
+		t.Errorf("Expected to read back the logical ticket data\n")
+	}
+}
+
+// serveTestTicketNode Serves the TCP ticket server on a free port, storing
+// tickets under a temporary data root
+func serveTestTicketNode(t *testing.T) PutterNode {
+	root, err := ioutil.TempDir("", "dataputter")
+	if err != nil {
+		t.Fatalf("Expected a temporary data root, got %v\n", err)
+	}
+	previous := dataRoot
+	dataRoot = root
+
+	s, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected to listen, got %v\n", err)
+	}
+	intake := make(chan WriteTicket)
+	go func() {
+		for {
+			conn, err := s.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(conn, intake)
+		}
+	}()
+	t.Cleanup(func() {
+		s.Close()
+		dataRoot = previous
+		os.RemoveAll(root)
+	})
+	return PutterNode{Host: "127.0.0.1", Port: s.Addr().(*net.TCPAddr).Port}
+}
+
+func TestStoreOnTarget(t *testing.T) {
+	node := serveTestTicketNode(t)
+
+	data := bytes.Repeat([]byte(`{"level":"info","msg":"hello"}`), 48)
+	wt := NewWriteTicket(FormatID(2), "CHECKSUM", data)
+	wt.Compression = CompressionSnappy
+	storedByteCount, codec, err := StoreOnTarget(wt, node)
+	if err != nil {
+		t.Fatalf("Expected to store the ticket, got %v\n", err)
+	}
+	if codec != CompressionSnappy || storedByteCount >= int64(len(data)) {
+		t.Errorf("Expected a snappy ticket, got %d bytes as %s\n", storedByteCount, codec)
+	}
+	stored, _ := readTicketFile(FormatID(2))
+	if !bytes.HasPrefix(stored, compressionMagic) || int64(len(stored)) != storedByteCount {
+		t.Errorf("Expected %d compressed bytes on disk, got %d\n", storedByteCount, len(stored))
+	}
+
+	restored, err := ReadFromTarget(FormatID(2), nil, node)
+	if err != nil || !bytes.Equal(restored, data) {
+		t.Errorf("Expected to read back the logical ticket data, got %v\n", err)
+	}
+	if _, err := ReadFromTarget(FormatID(3), nil, node); err == nil {
+		t.Errorf("Expected a missing ticket to fail\n")
+	}
+	wt.Compression = "lzma"
+	if _, _, err := StoreOnTarget(wt, node); err == nil {
+		t.Errorf("Expected an unknown compression to be refused\n")
+	}
+}
@@ -5,7 +5,9 @@
 package dataputter
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -14,6 +16,14 @@ import (
 	"time"
 )
 
+var (
+	// ErrNotEncrypted Only objects with a data key can be shredded
+	ErrNotEncrypted = errors.New("Object has no data key")
+	// ErrTicketNotInObject A ticket was read through an object which doesn't
+	// reference it
+	ErrTicketNotInObject = errors.New("Ticket is not part of the object")
+)
+
 // PutRequest Request with data to put somewhere
 type PutRequest struct {
 	ObjectID           string
@@ -24,7 +34,7 @@ type PutRequest struct {
 // putBytes: Always write bytes to filename, creating as needed
 // Does not create paths
 func putBytes(filename string, bytes []byte) error {
-	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	defer f.Close()
 	if err != nil {
 		return err
@@ -48,7 +58,7 @@ func deleteBytes(filename string) error {
 }
 
 func sendTCPSimple(hostPort string, data []byte) error {
-	c, err := net.Dial("tcp", hostPort)
+	c, err := dialTCP(hostPort)
 	if err != nil {
 		return err
 	}
@@ -88,33 +98,127 @@ func SendPutResponse(hostPort string, ticketID []byte, statusCode int) error {
 	)
 }
 
-func ServeTicketBytes(c net.Conn, client WriteNodeClient) error {
+// ServeTicketBytes Reads a ticket of an object from its node
+// [TicketID][ObjectID][16B API Key]
+//
+// IDs are sent as WireID. Reads are authorized against the object named,
+// which must reference the ticket, since deduplicated tickets belong to
+// every object referencing them. The API key is only read when ROUTER_AUTH
+// is set. Requests starting with LIST_HEADER list objects and those
+// starting with STAT_HEADER stat an object instead.
+func ServeTicketBytes(c net.Conn, client WriteNodeClient) (err error) {
 	defer c.Close()
-	ticketIDBytes := make([]byte, 8)
+	r := bufio.NewReader(c)
+	header, _ := r.Peek(8)
+	switch string(header) {
+	case LIST_HEADER:
+		r.Discard(8)
+		return serveListObjects(c, r)
+	case STAT_HEADER:
+		r.Discard(8)
+		return serveStatObject(c, r)
+	}
 
-	if l, err := c.Read(ticketIDBytes); err != nil || l != 8 {
-		log.Printf("Failed to get ticketID from %d bytes: %v\n", l, err)
+	ticketID, err := readWireID(r)
+	if err != nil {
+		log.Printf("Failed to get ticketID: %v\n", err)
+		return err
+	}
+	objectID, err := readWireID(r)
+	if err != nil {
+		log.Printf("Failed to get objectID of ticket %s: %v\n", ticketID, err)
 		return err
 	}
 
-	ticketID := string(ticketIDBytes)
+	audit := AuditEntry{Operation: AuditRead, ObjectID: objectID, TicketID: ticketID}
+	defer func() { Audit(audit, err) }()
+	// Tickets of encrypted objects can only be read with the object's data key
 	readRequest := &NodeReadRequest{
 		TicketId: ticketID,
+		ObjectId: objectID,
+	}
+	if authRequired {
+		var tenant string
+		tenant, err = readAPIKey(r)
+		audit.Tenant = tenant
+		if err == nil {
+			err = AuthorizeObject(tenant, objectID, AccessRead)
+		}
+		if err != nil {
+			log.Printf("Refused to read ticket %s: %v\n", ticketID, err)
+			return err
+		}
 	}
+	if err = AllowRequest(audit.Tenant); err != nil {
+		log.Printf("Refused to read ticket %s: %v\n", ticketID, err)
+		return err
+	}
+	referenced, err := IsObjectTicket(objectID, ticketID)
+	if err != nil {
+		return err
+	}
+	if !referenced {
+		log.Printf("Refused to read ticket %s, it isn't part of %s\n", ticketID, objectID)
+		err = ErrTicketNotInObject
+		return err
+	}
+	readRequest.DataKey, err = UnwrapObjectDataKey(objectID)
+	if err != nil {
+		log.Printf("Unable to unwrap data key of %s: %v\n", objectID, err)
+		return err
+	}
+
 	log.Printf("ServeTicketBytes for %s\n", ticketID)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 
 	response, err := client.Read(ctx, readRequest)
 	defer cancel()
+	if err != nil {
+		return err
+	}
 
-	if response.Status != 0 {
+	switch response.Status {
+	case NodeSuccess:
+	case NodeDecryptFailed:
+		log.Printf("Failed to decrypt ticket %s of %s\n", response.TicketId, objectID)
+		return ErrDecryptFailed
+	default:
 		log.Printf("Failed to read ticket %s\n", response.TicketId)
+		return fmt.Errorf("Node status %d reading ticket %s\n", response.Status, ticketID)
 	}
-	return err
+	return nil
+}
+
+// ReadTicketFromNode Reads the bytes of a ticket back from the node which
+// stored it, with the data key of its object
+// * Has Datastore access
+func ReadTicketFromNode(ticket Ticket) (*NodeResponse, error) {
+	dataKey, err := UnwrapObjectDataKey(ticket.ObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeClient, err := dialWriteNode(ticket.NodeID)
+	if err != nil {
+		log.Printf("Unable to create NodeClient: %v\n", err)
+		return nil, err
+	}
+	defer nodeClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return nodeClient.Read(ctx, &NodeReadRequest{
+		TicketId: ticket.TicketID,
+		ObjectId: ticket.ObjectID,
+		NodeId:   ticket.NodeID,
+		DataKey:  dataKey,
+	})
 }
 
 // Handles confirmations from a data putter node that it has deleted
-// the bytes associated with a ticket
+// the bytes associated with a ticket. Deduplicated tickets which other
+// objects still reference keep their metadata, only this object's
+// reference is removed.
 // * Has Datastore access
 func DeleteObjectReferences(objectID, ticketID string) (Ticket, error) {
 	ticket, err := GetTicketMetadata(ticketID)
@@ -122,7 +226,16 @@ func DeleteObjectReferences(objectID, ticketID string) (Ticket, error) {
 		return ticket, err
 	}
 
-	err = DeleteTicket(objectID, ticketID)
+	references, err := GetTicketReferenceCount(ticketID)
+	if err != nil {
+		return ticket, err
+	}
+	if references > 1 {
+		// Other objects still reference the ticket, only this object lets go of it
+		_, err = ReleaseTicketReference(objectID, ticketID)
+	} else {
+		err = DeleteTicket(objectID, ticketID)
+	}
 	if err != nil {
 		return ticket, err
 	}
@@ -154,6 +267,11 @@ func DeleteObject(objectID string) ([]Ticket, error) {
 		log.Printf("Delete object failed to get tickets for %s: %v\n", objectID, err)
 		return deletedTickets, err
 	}
+	// The owner gets the quota of the object back once all its tickets are gone
+	object, err := GetObjectMetadata(objectID)
+	if err != nil {
+		return deletedTickets, err
+	}
 	// Remove the bytes from disk
 	// Discover ticket information in a Datastore connected role
 	for ticketIndex, ticketID := range tickets {
@@ -171,23 +289,22 @@ func DeleteObject(objectID string) ([]Ticket, error) {
 			NodeId:   nodeID,
 		}
 
-		// TODO: Should use service lookup to find nodes
-		// during each segment
-		fmt.Println("Creating nodeClient")
-		nodeClient, err := NewClient("127.0.0.1:5002")
-		fmt.Println("Created nodeClient")
+		// Deduplicated tickets keep their bytes until the last reference is deleted
+		references, err := GetTicketReferenceCount(ticketID)
 		if err != nil {
-			log.Printf("Unable to create NodeClient: %v\n", err)
 			return deletedTickets, err
 		}
-		defer nodeClient.Close()
-
-		// Send Delete to NodeClient
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		res, err := nodeClient.Delete(ctx, deleteRequest)
-		defer cancel()
+		if references > 1 {
+			log.Printf("Keeping bytes of ticket %s for %d other references\n", ticketID, references-1)
+			ticket, err := DeleteObjectReferences(objectID, ticketID)
+			if err != nil {
+				return deletedTickets, err
+			}
+			deletedTickets = append(deletedTickets, ticket)
+			continue
+		}
 
-		if err != nil || res.Status != 0 {
+		if err := deleteTicketFromNode(deleteRequest); err != nil {
 			log.Printf("Error deleting ticket bytes for %s of %s: %v\n",
 				deleteRequest.TicketId,
 				deleteRequest.ObjectId,
@@ -204,5 +321,99 @@ func DeleteObject(objectID string) ([]Ticket, error) {
 		deletedTickets = append(deletedTickets, ticket)
 	}
 
+	// The object is gone with its last ticket, those without any, like empty
+	// uploads, are dropped here so their quota is only released once
+	if len(tickets) == 0 {
+		if err := DeleteObjectReference(objectID); err != nil {
+			return deletedTickets, err
+		}
+	}
+	ReleaseQuota(object.Owner, object.Size)
 	return deletedTickets, nil
 }
+
+// deleteTicketFromNode Deletes the bytes of a ticket from the node it was
+// written to. Bytes the node no longer has are already deleted.
+func deleteTicketFromNode(deleteRequest *NodeDeleteRequest) error {
+	nodeClient, err := dialWriteNode(deleteRequest.NodeId)
+	if err != nil {
+		log.Printf("Unable to create NodeClient: %v\n", err)
+		return err
+	}
+	defer nodeClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := nodeClient.Delete(ctx, deleteRequest)
+	if err != nil {
+		return err
+	}
+	if res.Status != NodeSuccess && res.Status != NodeNotExist {
+		return fmt.Errorf("WriteNode %s failed to delete ticket %s with status %d\n", deleteRequest.NodeId, deleteRequest.TicketId, res.Status)
+	}
+	return nil
+}
+
+// Shred an object by destroying its data key before deleting any bytes.
+// Once the key is gone the object can't be read, so deleting its tickets
+// from DataPutter Nodes is allowed to fail and is retried later by
+// CleanupShreddedObjects.
+// * Has Datastore access
+func ShredObject(objectID string) error {
+	wrapped, err := GetObjectDataKey(objectID)
+	if err != nil {
+		return err
+	}
+	if len(wrapped) == 0 {
+		return ErrNotEncrypted
+	}
+
+	if err := ShredObjectDataKey(objectID); err != nil {
+		log.Printf("Unable to shred data key of %s: %v\n", objectID, err)
+		return err
+	}
+	log.Printf("Shredded data key of %s\n", objectID)
+
+	if _, err := DeleteObject(objectID); err != nil {
+		log.Printf("Bytes of shredded %s remain on nodes until cleanup: %v\n", objectID, err)
+		return nil
+	}
+	return RemoveShreddedObject(objectID)
+}
+
+// Delete the remaining bytes of shredded objects from DataPutter Nodes.
+// Returns the number of objects which were completely cleaned up.
+// * Has Datastore access
+func CleanupShreddedObjects() (int, error) {
+	objectIDs, err := GetShreddedObjects()
+	if err != nil {
+		return 0, err
+	}
+
+	cleaned := 0
+	for _, objectID := range objectIDs {
+		if _, err := DeleteObject(objectID); err != nil {
+			log.Printf("Shredded %s still has bytes on nodes: %v\n", objectID, err)
+			continue
+		}
+		Audit(AuditEntry{Operation: AuditDelete, Reason: AuditShredded, ObjectID: objectID}, nil)
+		if err := RemoveShreddedObject(objectID); err != nil {
+			return cleaned, err
+		}
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// Periodically clean up shredded objects
+func RunShredCleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		cleaned, err := CleanupShreddedObjects()
+		if err != nil {
+			log.Printf("Shred cleanup failed: %v\n", err)
+		}
+		if cleaned > 0 {
+			log.Printf("Cleaned up %d shredded objects\n", cleaned)
+		}
+	}
+}
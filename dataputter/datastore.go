@@ -13,34 +13,44 @@
 //
 // Tickets have all information about their own allocation
 //
-// 	/tickets/ticketID/byteStart : 0
-// 	/tickets/ticketID/byteEnd   : 2
-// 	/tickets/ticketID/byteCount : 2
-// 	/tickets/ticketID/node      : NodeID
-// 	/tickets/ticketID/object    : ObjectID
-// 	/tickets/ticketID/status    : TicketStatus
+// 	/tickets/ticketID byteStart : 0
+// 	/tickets/ticketID byteEnd   : 2
+// 	/tickets/ticketID byteCount : 2
+// 	/tickets/ticketID node      : NodeID
+// 	/tickets/ticketID object    : ObjectID
+// 	/tickets/ticketID status    : TicketStatus
+// 	/tickets/ticketID storedByteCount : Bytes on disk after compression
+// 	/tickets/ticketID compression     : Codec the node stored the ticket with
 //
-// Objects can resolve tickets as a list of sub keys of themselves
+// Objects resolve tickets through objectTickets/objectID
 //
-// 	/objects/objectID/ticketID : TicketID
-// 	/objects/objectID/status   : ObjectStatus
+// 	/objects/objectID status     : ObjectStatus
+// 	/objects/objectID size       : Sum of the byteCount of its tickets
+// 	/objects/objectID storedSize : Sum of the storedByteCount of its tickets
+// 	/objects/objectID dataKey    : Wrapped data key when encrypted at rest
+// 	/objects/objectID createdAt  : Unix seconds of its first ticket
+// 	/objects/objectID contentType : Content type it was uploaded with
+// 	/objects/objectID checksum    : Hex SHA-256 of its bytes
+//
+// The fields above are how the RedisStore lays out metadata. Everything goes
+// through the MetadataStore in use so a MemoryStore can stand in for Redis.
 package dataputter
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"reflect"
-	"strconv"
 	"time"
-
-	redis "github.com/mediocregopher/radix/v3"
 )
 
 var (
-	client    *redis.Pool
 	endpoints = []string{"localhost:6379"}
 
+	// metadataStore: Where metadata is kept, Redis at REDIS_HOSTPORT unless
+	// another store is put in use
+	metadataStore MetadataStore
+
 	// String form of status code int
 	TicketStatus = map[int]string{
 		TicketNew:   "new",
@@ -50,11 +60,34 @@ var (
 
 	// String for of object status code int
 	ObjectStatus = map[int]string{
-		ObjectNew:     "new",
-		ObjectSaved:   "saved",
-		ObjectError:   "error",
-		ObjectWriting: "writing",
-	}
+		ObjectNew:      "new",
+		ObjectSaved:    "saved",
+		ObjectError:    "error",
+		ObjectWriting:  "writing",
+		ObjectShredded: "shredded",
+	}
+
+	// Statuses each ticket status may become. Tickets are created new and
+	// only saved tickets, or ones which failed, may be deleted.
+	TicketTransitions = map[string][]string{
+		"":      {"new"},
+		"new":   {"saved", "error"},
+		"saved": {"error"},
+		"error": {"saved"},
+	}
+
+	// Statuses each object status may become. Objects are created new and
+	// are saved once all of their tickets are.
+	ObjectTransitions = map[string][]string{
+		"":        {"new"},
+		"new":     {"writing", "error"},
+		"writing": {"saved", "error"},
+		"saved":   {"error", "shredded"},
+		"error":   {"shredded"},
+	}
+
+	// ErrIllegalTransition A status can't become the one it's set to
+	ErrIllegalTransition = errors.New("Illegal status transition")
 )
 
 const (
@@ -70,6 +103,8 @@ const (
 	ObjectSaved
 	ObjectError
 	ObjectWriting
+	// State when an Object's data key is destroyed and its bytes await deletion
+	ObjectShredded
 )
 
 const (
@@ -82,55 +117,165 @@ func init() {
 	if len(redisHostport) > 0 {
 		endpoints[0] = redisHostport
 	}
-	c, err := redis.NewPool("tcp", endpoints[0], 10)
-	if err != nil {
-		log.Printf("Unable to establish Redis connection: %v\n", err)
-		return
-	}
-	fmt.Println("Created Redis connection")
-	client = c
-}
-
-// Value is a string or string-ed []byte. Etcd assures us this is OK
-// http://localhost:4001/pkg/go.etcd.io/etcd/clientv3/#KV
-func writeString(keyPath, value string) error {
-	return client.Do(
-		redis.Cmd(nil, "SET", keyPath, value),
-	)
+	metadataStore = NewRedisStore(endpoints[0])
+}
+
+// MetadataStore Keeps the metadata of objects and tickets along with the
+// counters Routers coordinate writes with
+type MetadataStore interface {
+	ObjectStore
+	TicketStore
+	CounterStore
+	IndexStore
+	BucketStore
+	TenantStore
+}
+
+// ObjectStore Metadata of objects
+type ObjectStore interface {
+	CreateObject(objectID, ticketID string) error
+	GetObjects() ([]string, error)
+	// ScanObjects A page of about count ObjectIDs after cursor, an empty
+	// cursor starts at the beginning and is returned after the last page
+	ScanObjects(cursor string, count int) ([]string, string, error)
+	// GetObjectMetadata Everything known about an object without its tickets
+	GetObjectMetadata(objectID string) (ObjectMetadata, error)
+	SetObjectContentType(objectID, contentType string) error
+	SetObjectChecksum(objectID, checksum string) error
+	// SetObjectOwner Records the tenant which created an object
+	SetObjectOwner(objectID, tenant string) error
+	// SetObjectKey Records the bucket and key an object is stored under
+	SetObjectKey(objectID, bucket, key string) error
+	GetObjectStatus(objectID string) (string, error)
+	// SetObjectStatus Refuses transitions ObjectTransitions doesn't allow
+	SetObjectStatus(objectID, status string) error
+	SetObjectByteSize(objectID string, sizeInBytes int64) error
+	GetObjectSize(objectID string) (int64, error)
+	GetObjectStoredSize(objectID string) (int64, error)
+	// GetObjectCreatedAt Zero when it wasn't recorded
+	GetObjectCreatedAt(objectID string) (time.Time, error)
+	// StampObjectCreatedAt Records now as the createdAt of an existing
+	// object without one, objects which don't exist are left alone
+	StampObjectCreatedAt(objectID string) error
+	SetObjectDataKey(objectID, wrappedKey string) error
+	// ReplaceObjectDataKey Writes the data key only while it's still
+	// previous, false when it isn't
+	ReplaceObjectDataKey(objectID, previous, wrappedKey string) (bool, error)
+	GetObjectDataKey(objectID string) (string, error)
+	// ShredObjectDataKey Refuses objects which can't become shredded
+	ShredObjectDataKey(objectID string) error
+	GetShreddedObjects() ([]string, error)
+	RemoveShreddedObject(objectID string) error
+	GetObjectTickets(objectID string) ([]string, error)
+	GetObjectNodes(objectID string) ([]string, error)
+	// GetObjectTicketOffsets Start byte of each ticket within the object
+	GetObjectTicketOffsets(objectID string) (map[string]int64, error)
+	GetTicketsFromOffset(objectID string, offset int64) ([]string, error)
+	DeleteObjectReference(objectID string) error
+	// RestoreObject Writes an exported object as it is
+	RestoreObject(object ObjectRecord) error
+}
+
+// TicketStore Metadata of tickets
+type TicketStore interface {
+	CreateTicket(ticketID, objectID, nodeID string, byteStart, byteEnd, byteCount int64) error
+	SetTicketStoredByteCount(objectID, ticketID string, storedByteCount int64, compression string) error
+	// SetTicketStatus Refuses transitions TicketTransitions doesn't allow
+	SetTicketStatus(ticketID, status string) error
+	GetTicketStatus(ticketID string) (string, error)
+	GetTicketNode(ticketID string) (string, error)
+	GetTicketObject(ticketID string) (string, error)
+	GetTicketSize(ticketID string) (int64, error)
+	GetTicketMetadata(ticketID string) (Ticket, error)
+	// DeleteTicket Removes the ticket whatever its status
+	DeleteTicket(objectID, ticketID string) error
+	// RestoreTicket Writes an exported ticket as it is, without adding it to objects
+	RestoreTicket(ticket Ticket) error
+}
+
+// CounterStore Counters by key path
+type CounterStore interface {
+	// IncrementCounter Adds to a counter, returning its new value
+	IncrementCounter(keyPath string, by int64) (int64, error)
+	// GetCounter Value of a counter, 0 when it doesn't exist
+	GetCounter(keyPath string) (int64, error)
+	DeleteCounter(keyPath string) error
+}
+
+// IndexStore Content hash index of tickets and the objects referencing them
+type IndexStore interface {
+	IndexTicketHash(ticketID, contentHash string) error
+	GetTicketByHash(contentHash string) (string, error)
+	IsObjectTicket(objectID, ticketID string) (bool, error)
+	AddTicketReference(objectID, ticketID string, byteStart int64) error
+	// GetTicketReferenceCount References to a ticket, 0 when it was never indexed
+	GetTicketReferenceCount(ticketID string) (int64, error)
+	ReleaseTicketReference(objectID, ticketID string) (int64, error)
+}
+
+// BucketStore Buckets and the ObjectIDs of their keys
+type BucketStore interface {
+	// CreateBucket ErrBucketExists when the name is taken
+	CreateBucket(bucket Bucket) error
+	// GetBucket ErrBucketNotExist when there's no such bucket
+	GetBucket(name string) (Bucket, error)
+	GetBuckets() ([]string, error)
+	// DeleteBucket ErrBucketNotEmpty while the bucket has keys
+	DeleteBucket(name string) error
+	// PutBucketKey Returns the ObjectID the key held before
+	PutBucketKey(bucket, key, objectID string) (string, error)
+	GetBucketKey(bucket, key string) (string, error)
+	// DeleteBucketKey Returns the ObjectID the key held
+	DeleteBucketKey(bucket, key string) (string, error)
+	// PutBucketPolicy ErrBucketNotExist when there's no such bucket
+	PutBucketPolicy(bucket string, grants []Grant) error
+	// GetBucketPolicy Empty when the bucket has no policy
+	GetBucketPolicy(bucket string) ([]Grant, error)
+	// ScanBucketKeys Up to count keys starting with prefix after cursor, in order
+	ScanBucketKeys(bucket, prefix, cursor string, count int) ([]BucketKey, string, error)
+}
+
+// TenantStore API keys of tenants, kept by their SHA-256, and their quotas
+type TenantStore interface {
+	PutAPIKey(keyHash, tenant string) error
+	// GetAPIKeyTenant Empty when the key doesn't exist
+	GetAPIKeyTenant(keyHash string) (string, error)
+	DeleteAPIKey(keyHash string) error
+	// GetAPIKeys The tenant of each key
+	GetAPIKeys() (map[string]string, error)
+	// GetTenants Tenants with a quota or usage
+	GetTenants() ([]string, error)
+	SetTenantQuota(tenant string, quota Quota) error
+	// GetTenantQuota Zero limits when the tenant has no quota
+	GetTenantQuota(tenant string) (Quota, error)
+	GetTenantUsage(tenant string) (Quota, error)
+	// ReserveTenantUsage Adds to the usage of a tenant unless it would go
+	// over its quota, false when it would
+	ReserveTenantUsage(tenant string, bytes, objects int64) (bool, error)
+	AddTenantUsage(tenant string, bytes, objects int64) error
+}
+
+// UseMetadataStore Puts a MetadataStore in use by the Router, ObjectServer
+// and deletes. Call before serving.
+func UseMetadataStore(store MetadataStore) {
+	metadataStore = store
+}
+
+// GetMetadataStore The MetadataStore in use
+func GetMetadataStore() MetadataStore {
+	return metadataStore
 }
 
-func getKey(keyPath string) (string, error) {
-	log.Printf("GetKey: %s ::%#v::\n", keyPath, keyPath)
-	var value string
-	err := client.Do(
-		redis.Cmd(&value, "GET", keyPath),
-	)
-	return value, err
+func touchCounter(keyPath string) (int64, error) {
+	return metadataStore.IncrementCounter(keyPath, 1)
 }
 
 func getCounter(keyPath string) (int64, error) {
-	var value int64
-	err := client.Do(
-		redis.Cmd(&value, "GET", keyPath),
-	)
-	return value, err
-}
-
-func initCounter(keyPath string) error {
-	return client.Do(redis.Cmd(nil, "SET", keyPath, "0"))
-}
-
-func touchCounter(keyPath string) (int64, error) {
-	var value int64
-
-	err := client.Do(
-		redis.Cmd(&value, "INCR", keyPath),
-	)
-	return value, err
+	return metadataStore.GetCounter(keyPath)
 }
 
 func deleteKeyPath(keyPath string) error {
-	return client.Do(redis.Cmd(nil, "DEL", keyPath))
+	return metadataStore.DeleteCounter(keyPath)
 }
 
 // Touches a ticket counter. Each touch updates the Version of the key
@@ -153,11 +298,8 @@ func TouchWriteCounter(objectID string) (string, error) {
 
 // Reduces the ticket counter by 1
 func ReduceTicketCounter(objectID string) (int64, error) {
-	var value int64
 	keyPath := "/objects/" + objectID + "/ticketCounter"
-	return value, client.Do(
-		redis.Cmd(&value, "DECR", keyPath),
-	)
+	return metadataStore.IncrementCounter(keyPath, -1)
 }
 
 // Emitted to observers of a WatchCounter
@@ -202,7 +344,7 @@ func GetWriteCounterValue(objectID string) (int64, error) {
 }
 
 func GetTicketObject(ticketID string) (string, error) {
-	return getKey("/tickets/" + ticketID + "/object")
+	return metadataStore.GetTicketObject(ticketID)
 }
 
 // Get the ticket status code by name
@@ -215,177 +357,236 @@ func GetTicketStatusCode(statusName string) int {
 	return InvalidTicketStatus
 }
 
+// CanTransition True when a status from transitions may become to. Setting
+// the status an object or ticket already has is always allowed.
+func CanTransition(transitions map[string][]string, from, to string) bool {
+	if from == to {
+		return true
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedFrom Every status which may become to
+func allowedFrom(transitions map[string][]string, to string) []string {
+	statuses := []string{to}
+	for from := range transitions {
+		if from != to && CanTransition(transitions, from, to) {
+			statuses = append(statuses, from)
+		}
+	}
+	return statuses
+}
+
+// transitionError Why a status wasn't set
+func transitionError(kind, id, from, to string) error {
+	return fmt.Errorf("%w of %s %s from '%s' to '%s'\n", ErrIllegalTransition, kind, id, from, to)
+}
+
 // Create a new object reference and ticket reference
-// Creates: /objects/$objectID/tickets/$ticketID = ticketID
-// Adds to set: objects { objectID }
-// Sets object status: /objects/$objectID/status NEW
 func CreateObject(objectID, ticketID string) error {
-	var err error
-	basePath := "/objects/" + objectID + "/"
+	return metadataStore.CreateObject(objectID, ticketID)
+}
 
-	err = writeString(basePath+"tickets/"+ticketID, ticketID)
-	if err != nil {
-		return err
-	}
+// Every ObjectID in the set of objects
+func GetObjects() ([]string, error) {
+	return metadataStore.GetObjects()
+}
 
-	err = client.Do(redis.Cmd(nil, "SADD", "objects", objectID))
-	if err != nil {
-		log.Printf("Unable to add %s to set of objects: %v", objectID, err)
-	}
+// A page of ObjectIDs from the set of objects
+func ScanObjects(cursor string, count int) ([]string, string, error) {
+	return metadataStore.ScanObjects(cursor, count)
+}
+
+// GetObjectMetadata Everything known about an object without its tickets
+func GetObjectMetadata(objectID string) (ObjectMetadata, error) {
+	return metadataStore.GetObjectMetadata(objectID)
+}
+
+// Set the content type an object was uploaded with
+func SetObjectContentType(objectID, contentType string) error {
+	return metadataStore.SetObjectContentType(objectID, contentType)
+}
+
+// Set the SHA-256 of the bytes of an object
+func SetObjectChecksum(objectID, checksum string) error {
+	return metadataStore.SetObjectChecksum(objectID, checksum)
+}
+
+// Set the tenant which created an object
+func SetObjectOwner(objectID, tenant string) error {
+	return metadataStore.SetObjectOwner(objectID, tenant)
+}
+
+// Set the wrapped data key of an object
+func SetObjectDataKey(objectID, wrappedKey string) error {
+	return metadataStore.SetObjectDataKey(objectID, wrappedKey)
+}
+
+// Replace the wrapped data key of an object unless it changed from previous
+func ReplaceObjectDataKey(objectID, previous, wrappedKey string) (bool, error) {
+	return metadataStore.ReplaceObjectDataKey(objectID, previous, wrappedKey)
+}
+
+// Get the wrapped data key of an object, empty when it isn't encrypted
+func GetObjectDataKey(objectID string) (string, error) {
+	return metadataStore.GetObjectDataKey(objectID)
+}
+
+// Destroy the wrapped data key of an object and queue it for byte cleanup
+func ShredObjectDataKey(objectID string) error {
+	return metadataStore.ShredObjectDataKey(objectID)
+}
+
+// Objects whose data key has been shredded but whose bytes may remain on nodes
+func GetShreddedObjects() ([]string, error) {
+	return metadataStore.GetShreddedObjects()
+}
 
-	err = writeString(basePath+"status", TicketStatus[TicketNew])
-	return err
+// Remove an object from the shredded objects once its bytes are deleted
+func RemoveShreddedObject(objectID string) error {
+	return metadataStore.RemoveShreddedObject(objectID)
 }
 
 // Set the size of an object
 func SetObjectByteSize(objectID string, sizeInBytes int64) error {
-	return writeString(
-		"/objects/"+objectID+"/size",
-		fmt.Sprintf("%d", sizeInBytes),
-	)
+	return metadataStore.SetObjectByteSize(objectID, sizeInBytes)
 }
 
-// Sets a new Object status
+// Sets a new Object status, failing with ErrIllegalTransition when
+// ObjectTransitions doesn't allow it
 func SetObjectStatus(objectID, status string) error {
-	return writeString(
-		"/objects/"+objectID+"/status",
-		status,
-	)
+	return metadataStore.SetObjectStatus(objectID, status)
 }
 
-// Sets a new ticket status
-func SetTicketStatus(ticketID, status string) error {
-	log.Printf("SetTicketStatus of %s to %s\n", ticketID, status)
-	keyPath := "/tickets/" + ticketID + "/status"
+// Gets the status of an Object
+func GetObjectStatus(objectID string) (string, error) {
+	return metadataStore.GetObjectStatus(objectID)
+}
 
-	return writeString(
-		keyPath,
-		status,
-	)
+// Sets a new ticket status, failing with ErrIllegalTransition when
+// TicketTransitions doesn't allow it
+func SetTicketStatus(ticketID, status string) error {
+	return metadataStore.SetTicketStatus(ticketID, status)
 }
 
 // Get a list of tickets for in the inclusive range from offset to minByt + 512KB
-func GetTicketsFromOffset(objectID string, offset int64) (tickets []string, err error) {
-	log.Printf("GetTicketFromOffset %d %s\n", offset, objectID)
-	err = client.Do(
-		redis.Cmd(
-			&tickets,
-			"ZRANGEBYSCORE",
-			"objectBytes/"+objectID,
-			strconv.FormatInt(offset, 10),
-			strconv.FormatInt(offset+int64(1024*512), 10),
-		),
-	)
-
-	if err != nil {
-		return
-	}
-
-	return
+func GetTicketsFromOffset(objectID string, offset int64) ([]string, error) {
+	return metadataStore.GetTicketsFromOffset(objectID, offset)
 }
 
 // Create a new ticket in the datastore
-// Creates: /tickets/$ticketID/ticket = ticketID
-// Creates: /tickets/$TICKET_ID/object = OBJECT_ID
-// Creates: /tickets/$TICKET_ID/node = NODE_ID
-// Sets /tickets/$TICKET_ID/byteStart = byteStart
-// Sets /tickets/$TICKET_ID/byteEnd = byteEnd
-// Sets /tickets/$TICKET_ID/byteCount = byteCount
-// Adds byteStart position to set of objectBytes: objectBytes/$objectID { byteStart }
-// Adds ticket to set of Object tickets: objectTickets/$objectID { ticketID }
-// Adds node to set of nodes containing tickets: objectNodes/$objectID { nodeID }
 func CreateTicket(ticketID, objectID, nodeID string, byteStart, byteEnd, byteCount int64) error {
-	var err error
-	log.Printf("[%d:%d] CreateTicket %s for object %s\n", byteStart, byteEnd, ticketID, objectID)
-	basePath := "/tickets/" + ticketID + "/"
+	return metadataStore.CreateTicket(ticketID, objectID, nodeID, byteStart, byteEnd, byteCount)
+}
 
-	// /tickets/$TICKET_ID/ticket = TICKET_ID
-	err = writeString(basePath+"ticket", ticketID)
-	if err != nil {
-		return err
-	}
-	// /tickets/$TICKET_ID/object = OBJECT_ID
-	err = writeString(basePath+"object", objectID)
-	if err != nil {
-		return err
-	}
-	// /tickets/$TICKET_ID/node = NODE_ID
-	err = writeString(basePath+"node", nodeID)
-	if err != nil {
-		return err
-	}
-	// /tickets/$TICKET_ID/byteStart = 0
-	err = writeString(basePath+"byteStart", strconv.FormatInt(byteStart, 10))
-	if err != nil {
-		return err
-	}
-	// /tickets/$TICKET_ID/byteEnd = 1450
-	err = writeString(basePath+"byteEnd", strconv.FormatInt(byteEnd, 10))
-	if err != nil {
-		return err
-	}
-	// /tickets/$TICKET_ID/byteCount = 1450
-	err = writeString(basePath+"byteCount", strconv.FormatInt(byteCount, 10))
-	if err != nil {
-		return err
-	}
+// Record how many bytes a node stored for a ticket and the codec it used.
+// The logical byte count is left as it is so object sizes are unaffected
+// by compression.
+func SetTicketStoredByteCount(objectID, ticketID string, storedByteCount int64, compression string) error {
+	return metadataStore.SetTicketStoredByteCount(objectID, ticketID, storedByteCount, compression)
+}
 
-	// Keep tickets sorted for an object by storing the start byte as the score of a ticket
-	err = client.Do(
-		redis.Cmd(nil, "ZADD", "objectBytes/"+objectID, strconv.FormatInt(byteStart, 10), ticketID),
-	)
-	if err != nil {
-		return err
-	}
+// Index a ticket by the hash of its content so duplicates can reference it.
+// A ticket starts with the one reference of the object which wrote it.
+func IndexTicketHash(ticketID, contentHash string) error {
+	return metadataStore.IndexTicketHash(ticketID, contentHash)
+}
 
-	// Track which tickets an object has
-	err = client.Do(redis.Cmd(nil, "SADD", "objectTickets/"+objectID, ticketID))
-	if err != nil {
-		log.Printf("Unable to add %s to set of objects: %v", objectID, err)
-	}
+// Get the TicketID indexed by a content hash, empty when there is none
+func GetTicketByHash(contentHash string) (string, error) {
+	return metadataStore.GetTicketByHash(contentHash)
+}
 
-	// Track which nodes have tickets for an object
-	err = client.Do(redis.Cmd(nil, "SADD", "objectNodes/"+objectID, nodeID))
-	if err != nil {
-		log.Printf("Unable to add %s to set of objects: %v", objectID, err)
-	}
+// True when an object already has a ticket in its set of tickets
+func IsObjectTicket(objectID, ticketID string) (bool, error) {
+	return metadataStore.IsObjectTicket(objectID, ticketID)
+}
 
-	// SetTicketStatus(ticketID, TicketStatus[TicketNew])
+// Add a reference from an object to a ticket which is already stored
+func AddTicketReference(objectID, ticketID string, byteStart int64) error {
+	return metadataStore.AddTicketReference(objectID, ticketID, byteStart)
+}
+
+// Number of objects referencing a ticket. Tickets which were never
+// indexed have no reference count and are only referenced by their object.
+func GetTicketReferenceCount(ticketID string) (int64, error) {
+	references, err := metadataStore.GetTicketReferenceCount(ticketID)
+	if err == nil && references == 0 {
+		references = 1
+	}
+	return references, err
+}
 
-	return err
+// Remove the reference of an object to a ticket other objects still reference
+// Returns the number of references which remain
+func ReleaseTicketReference(objectID, ticketID string) (int64, error) {
+	return metadataStore.ReleaseTicketReference(objectID, ticketID)
 }
 
 func GetTicketStatus(ticketID string) (string, error) {
-	return getKey("/tickets/" + ticketID + "/status")
+	return metadataStore.GetTicketStatus(ticketID)
 }
 
 func GetTicketNode(ticketID string) (string, error) {
-	return getKey("/tickets/" + ticketID + "/node")
+	return metadataStore.GetTicketNode(ticketID)
 }
 
 func GetTicketSize(ticketID string) (int64, error) {
-	v, err := getKey("/tickets/" + ticketID + "/byteCount")
-
-	if err != nil {
-		return int64(0), err
-	}
-	return strconv.ParseInt(v, 10, 64)
+	return metadataStore.GetTicketSize(ticketID)
 }
 
 func GetObjectTickets(objectID string) ([]string, error) {
-	tickets := []string{}
+	return metadataStore.GetObjectTickets(objectID)
+}
 
-	err := client.Do(
-		redis.Cmd(&tickets, "smembers", "objectTickets/"+objectID),
-	)
+// Nodes holding tickets of an object
+func GetObjectNodes(objectID string) ([]string, error) {
+	return metadataStore.GetObjectNodes(objectID)
+}
 
-	return tickets, err
+// Start byte of each ticket of an object
+func GetObjectTicketOffsets(objectID string) (map[string]int64, error) {
+	return metadataStore.GetObjectTicketOffsets(objectID)
 }
 
 type Ticket struct {
-	ByteCount, ByteStart, ByteEnd int64
-	ObjectID, TicketID, NodeID    string
-	KeyPath                       string
+	ByteCount int64  `json:"byteCount"`
+	ByteStart int64  `json:"byteStart"`
+	ByteEnd   int64  `json:"byteEnd"`
+	ObjectID  string `json:"object"`
+	TicketID  string `json:"ticket"`
+	NodeID    string `json:"node"`
+	KeyPath   string `json:"-"`
+
+	Status          string `json:"status,omitempty"`
+	StoredByteCount int64  `json:"storedByteCount,omitempty"`
+	Compression     string `json:"compression,omitempty"`
+	// ContentHash and References are only set on deduplicated tickets
+	ContentHash string `json:"hash,omitempty"`
+	References  int64  `json:"refCount,omitempty"`
+}
+
+// ObjectMetadata Everything known about an object without its tickets
+type ObjectMetadata struct {
+	ObjectID string `json:"id"`
+	Status   string `json:"status"`
+	// Size: 0 until all the tickets of the object are written
+	Size        int64     `json:"size"`
+	StoredSize  int64     `json:"storedSize"`
+	ContentType string    `json:"contentType,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Checksum: Hex SHA-256 of the bytes of the object once it's saved
+	Checksum string `json:"checksum,omitempty"`
+	// Owner: Tenant which created the object, empty without authentication
+	Owner string `json:"owner,omitempty"`
+	// Bucket and Key: Where the object is stored, empty without a bucket
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
 }
 
 func (t Ticket) String() string {
@@ -398,52 +599,7 @@ func (t Ticket) String() string {
 
 // GetTicketMetadata An entire tickets metadata without data
 func GetTicketMetadata(ticketID string) (Ticket, error) {
-	ticket := Ticket{
-		KeyPath: "/tickets/" + ticketID,
-	}
-	intKeys := map[string]string{
-		"ByteCount": "/tickets/" + ticketID + "/byteCount",
-		"ByteStart": "/tickets/" + ticketID + "/byteStart",
-		"ByteEnd":   "/tickets/" + ticketID + "/byteEnd",
-	}
-	stringKeys := map[string]string{
-		"NodeID":   "/tickets/" + ticketID + "/node",
-		"TicketID": "/tickets/" + ticketID + "/ticket",
-		"ObjectID": "/tickets/" + ticketID + "/object",
-	}
-	// Force dereference to object pointer
-	ticketValue := reflect.ValueOf(&ticket).Elem()
-
-	for field, path := range intKeys {
-		var v int64
-		if err := client.Do(redis.Cmd(&v, "get", path)); err != nil {
-			log.Printf("Error getting %s: %v\n", path, err)
-			return ticket, err
-		}
-		log.Printf("TicketMetadata: %s = %d\n", path, v)
-		if ticketValue.FieldByName(field).CanSet() {
-			ticketValue.FieldByName(field).SetInt(v)
-		} else {
-			log.Printf("Unable to set field %s\n", field)
-		}
-	}
-
-	for field, path := range stringKeys {
-		var v string
-		if err := client.Do(redis.Cmd(&v, "get", path)); err != nil {
-			log.Printf("Error getting %s: %v\n", path, err)
-			return ticket, err
-		}
-		log.Printf("TicketMetadata: %s = %v\n", path, v)
-
-		if ticketValue.FieldByName(field).CanSet() {
-			ticketValue.FieldByName(field).SetString(v)
-		} else {
-			log.Printf("Unable to set field %s\n", field)
-		}
-	}
-
-	return ticket, nil
+	return metadataStore.GetTicketMetadata(ticketID)
 }
 
 func DeleteTicket(objectID, ticketID string) error {
@@ -451,73 +607,32 @@ func DeleteTicket(objectID, ticketID string) error {
 	if err != nil {
 		return err
 	}
-	if status != "saved" && status != "" {
+	if status != TicketStatus[TicketSaved] && status != TicketStatus[TicketError] {
 		return fmt.Errorf("Denying access to ticket %s in state %s\n", ticketID, status)
 	}
 	log.Printf("Deleting %s/%s with status %s\n", objectID, ticketID, status)
-	// Remove ticket from set of object tickets
-	keyPath := "objectTickets/" + objectID
-
-	if err := client.Do(
-		redis.Cmd(nil, "srem", keyPath, ticketID),
-	); err != nil {
-		return err
-	}
-
-	keyPaths := []string{
-		"/tickets/" + ticketID + "/byteCount",
-		"/tickets/" + ticketID + "/node",
-		"/tickets/" + ticketID + "/status",
-		"/tickets/" + ticketID + "/ticket",
-		"/tickets/" + ticketID + "/object",
-		"/tickets/" + ticketID + "/byteStart",
-		"/tickets/" + ticketID + "/byteEnd",
-		"/objects/" + objectID + "/tickets/" + ticketID,
-	}
-	for _, path := range keyPaths {
-		if err := client.Do(redis.Cmd(nil, "del", path)); err != nil {
-			log.Printf("Failed to delete %s: %v\n", path, err)
-			return err
-		}
-		log.Printf("Deleted %s\n", path)
-	}
-	return err
+	return metadataStore.DeleteTicket(objectID, ticketID)
 }
 
 func DeleteObjectReference(objectID string) error {
-	log.Printf("DeleteObjectReference %s\n", objectID)
-	keyPaths := []string{
-		// Delete set of tickets associated with the object
-		"objectTickets/" + objectID,
-		// Delete set of nodes the object was written to
-		"objectNodes/" + objectID,
-		// Delete min heap of ticket ids
-		"objectBytes/" + objectID,
-		"/objects/" + objectID + "/size",
-		"/objects/" + objectID + "/status",
-		"/objects/" + objectID + "/writeCounter",
-		"/objects/" + objectID + "/ticketCounter",
-	}
+	return metadataStore.DeleteObjectReference(objectID)
+}
 
-	for _, keyPath := range keyPaths {
-		if err := client.Do(redis.Cmd(nil, "del", keyPath)); err != nil {
-			log.Printf("Failed to delete %s: %v\n", keyPath, err)
-			return err
-		}
-		log.Printf("Deleted %s\n", keyPath)
-	}
+// GetObjectCreatedAt When the object was created
+func GetObjectCreatedAt(objectID string) (time.Time, error) {
+	return metadataStore.GetObjectCreatedAt(objectID)
+}
 
-	// Finally, delete the object from the set of objects
-	return client.Do(
-		redis.Cmd(nil, "SREM", "objects", objectID),
-	)
+// StampObjectCreatedAt Records now as the createdAt of an object without one
+func StampObjectCreatedAt(objectID string) error {
+	return metadataStore.StampObjectCreatedAt(objectID)
 }
 
 func GetObjectSize(objectID string) (int64, error) {
-	v, err := getKey("/objects/" + objectID + "/size")
+	return metadataStore.GetObjectSize(objectID)
+}
 
-	if err != nil {
-		return int64(0), err
-	}
-	return strconv.ParseInt(v, 10, 64)
+// GetObjectStoredSize Bytes an object occupies on disk after compression
+func GetObjectStoredSize(objectID string) (int64, error) {
+	return metadataStore.GetObjectStoredSize(objectID)
 }
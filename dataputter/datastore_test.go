@@ -1,8 +1,21 @@
 package dataputter
 
-import "testing"
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestMain Runs against Redis when REDIS_HOSTPORT is set, otherwise in memory
+func TestMain(m *testing.M) {
+	if len(os.Getenv("REDIS_HOSTPORT")) == 0 {
+		UseMetadataStore(NewMemoryStore())
+	}
+	os.Exit(m.Run())
+}
 
 func TestSetTicketStatus(t *testing.T) {
+	defer metadataStore.DeleteTicket("", "ticketID")
 	var err error
 	err = SetTicketStatus("ticketID", TicketStatus[TicketNew])
 	if err != nil {
@@ -19,15 +32,16 @@ func TestSetTicketStatus(t *testing.T) {
 }
 
 func TestSetTicketStatusInSequence(t *testing.T) {
+	defer metadataStore.DeleteTicket("", "sequenceTicketID")
 
 	tests := []string{"new", "saved", "error", "saved"}
 	for _, status := range tests {
-		err := SetTicketStatus("ticketID", status)
+		err := SetTicketStatus("sequenceTicketID", status)
 		if err != nil {
 			t.Errorf("Expected to set initial status of %s but failed\n", status)
 		}
 
-		storedStatus, err := GetTicketStatus("ticketID")
+		storedStatus, err := GetTicketStatus("sequenceTicketID")
 		if err != nil {
 			t.Errorf("Expected to get status for %s but failed\n", status)
 		}
@@ -37,12 +51,48 @@ func TestSetTicketStatusInSequence(t *testing.T) {
 	}
 }
 
+func TestIllegalStatusTransitions(t *testing.T) {
+	defer DeleteObjectReference("TEST_TRANSITION_OBJECT")
+	defer metadataStore.DeleteTicket("TEST_TRANSITION_OBJECT", "TEST_TRANSITION_TICKET")
+
+	CreateObject("TEST_TRANSITION_OBJECT", "TEST_TRANSITION_TICKET")
+	if status, _ := GetObjectStatus("TEST_TRANSITION_OBJECT"); status != ObjectStatus[ObjectNew] {
+		t.Errorf("Expected a created object to be new, got %s\n", status)
+	}
+	if err := SetObjectStatus("TEST_TRANSITION_OBJECT", ObjectStatus[ObjectSaved]); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Expected new to saved to be refused, got %v\n", err)
+	}
+	for _, status := range []string{"writing", "writing", "saved"} {
+		if err := SetObjectStatus("TEST_TRANSITION_OBJECT", status); err != nil {
+			t.Errorf("Expected object to become %s, got %v\n", status, err)
+		}
+	}
+	// Writing another chunk doesn't make a saved object new again
+	CreateObject("TEST_TRANSITION_OBJECT", "TEST_TRANSITION_TICKET")
+	if status, _ := GetObjectStatus("TEST_TRANSITION_OBJECT"); status != ObjectStatus[ObjectSaved] {
+		t.Errorf("Expected object to stay saved, got %s\n", status)
+	}
+
+	CreateTicket("TEST_TRANSITION_TICKET", "TEST_TRANSITION_OBJECT", "TEST_NODE_ID", 0, 10, 10)
+	if err := DeleteTicket("TEST_TRANSITION_OBJECT", "TEST_TRANSITION_TICKET"); err == nil {
+		t.Errorf("Expected a new ticket to not be deleted\n")
+	}
+	SetTicketStatus("TEST_TRANSITION_TICKET", TicketStatus[TicketSaved])
+	if err := SetTicketStatus("TEST_TRANSITION_TICKET", TicketStatus[TicketNew]); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Expected saved to new to be refused, got %v\n", err)
+	}
+	if status, _ := GetTicketStatus("TEST_TRANSITION_TICKET"); status != TicketStatus[TicketSaved] {
+		t.Errorf("Expected ticket to stay saved, got %s\n", status)
+	}
+}
+
 func TestGetObjectTickets(t *testing.T) {
 	defer DeleteTicket("TEST_OBJECT_ID", "TEST_TICKET_ID")
 	err := CreateTicket("TEST_TICKET_ID", "TEST_OBJECT_ID", "TEST_NODE_ID", 0, 10, 10)
 	if err != nil {
 		t.Errorf("Expected to write one ticket, got %v\n", err)
 	}
+	SetTicketStatus("TEST_TICKET_ID", TicketStatus[TicketSaved])
 
 	tickets, err := GetObjectTickets("TEST_OBJECT_ID")
 	if err != nil {
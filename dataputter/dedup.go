@@ -0,0 +1,75 @@
+// Deduplication
+//
+// When ROUTER_DEDUP is set the Router hashes each chunk and looks the hash up
+// before writing it. A chunk which is already stored by another object is
+// referenced by the new object instead of being written again.
+//
+// 	ticketHashes                 : {ContentHash: TicketID}
+// 	/tickets/ticketID hash       : ContentHash
+// 	/tickets/ticketID refCount   : Number of objects referencing the ticket
+//
+// With ROUTER_AUTH set chunks are only shared between objects of the same
+// tenant, their hash is indexed as tenant/ContentHash, so no tenant can
+// learn whether another already stores some content.
+//
+// Encrypted objects have their own data keys so they are never deduplicated.
+// A ticket's bytes are only deleted from its node when its last reference is.
+package dataputter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+)
+
+var (
+	// dedupEnabled: Reference duplicate chunks instead of writing them
+	dedupEnabled = false
+)
+
+func init() {
+	if len(os.Getenv("ROUTER_DEDUP")) > 0 {
+		log.Printf("Ticket deduplication enabled\n")
+		dedupEnabled = true
+	}
+}
+
+// HashTicketData Content hash of the bytes of a ticket
+func HashTicketData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// DedupHash The hash a chunk uploaded by the tenant is indexed by
+func DedupHash(tenant string, data []byte) string {
+	contentHash := HashTicketData(data)
+	if authRequired {
+		return tenant + "/" + contentHash
+	}
+	return contentHash
+}
+
+// FindDuplicateTicket Finds a stored ticket with the content hash which can be
+// referenced by the object. Returns an empty TicketID when there is none.
+// A ticket which the object already references is not a duplicate, the
+// bytes of an object are indexed by TicketID so each may only appear once.
+// * Has Datastore access
+func FindDuplicateTicket(objectID, contentHash string) (string, error) {
+	ticketID, err := GetTicketByHash(contentHash)
+	if err != nil || len(ticketID) == 0 {
+		return "", err
+	}
+
+	referenced, err := IsObjectTicket(objectID, ticketID)
+	if err != nil || referenced {
+		return "", err
+	}
+
+	// The index can outlive a ticket which failed to delete completely
+	nodeID, err := GetTicketNode(ticketID)
+	if err != nil || len(nodeID) == 0 {
+		return "", err
+	}
+	return ticketID, nil
+}
@@ -0,0 +1,62 @@
+package dataputter
+
+import "testing"
+
+func TestTicketReferences(t *testing.T) {
+	defer DeleteObjectReference("TEST_DEDUP_OBJECT_A")
+	defer DeleteObjectReference("TEST_DEDUP_OBJECT_B")
+	contentHash := HashTicketData([]byte("the same chunk"))
+
+	CreateTicket("TEST_DEDUP_TICKET", "TEST_DEDUP_OBJECT_A", "TEST_NODE_ID", 0, 14, 14)
+	SetTicketStatus("TEST_DEDUP_TICKET", TicketStatus[TicketSaved])
+	if err := IndexTicketHash("TEST_DEDUP_TICKET", contentHash); err != nil {
+		t.Fatalf("Expected to index ticket hash, got %v\n", err)
+	}
+
+	if ticketID, _ := FindDuplicateTicket("TEST_DEDUP_OBJECT_A", contentHash); ticketID != "" {
+		t.Errorf("Expected no duplicate within the object that stored it, got %s\n", ticketID)
+	}
+	ticketID, err := FindDuplicateTicket("TEST_DEDUP_OBJECT_B", contentHash)
+	if err != nil || ticketID != "TEST_DEDUP_TICKET" {
+		t.Fatalf("Expected TEST_DEDUP_TICKET as a duplicate, got %s %v\n", ticketID, err)
+	}
+	AddTicketReference("TEST_DEDUP_OBJECT_B", ticketID, 0)
+
+	if references, _ := GetTicketReferenceCount(ticketID); references != 2 {
+		t.Errorf("Expected 2 references, got %d\n", references)
+	}
+
+	// Deleting one reference keeps the ticket for the other object
+	if _, err := DeleteObjectReferences("TEST_DEDUP_OBJECT_B", ticketID); err != nil {
+		t.Errorf("Expected to delete reference of object B, got %v\n", err)
+	}
+	if nodeID, _ := GetTicketNode(ticketID); nodeID != "TEST_NODE_ID" {
+		t.Errorf("Expected ticket metadata to remain, got node %s\n", nodeID)
+	}
+	if referenced, _ := IsObjectTicket("TEST_DEDUP_OBJECT_B", ticketID); referenced {
+		t.Errorf("Expected object B to no longer reference the ticket\n")
+	}
+
+	// Deleting the last reference deletes the ticket and its index entry
+	if _, err := DeleteObjectReferences("TEST_DEDUP_OBJECT_A", ticketID); err != nil {
+		t.Errorf("Expected to delete reference of object A, got %v\n", err)
+	}
+	if indexed, _ := GetTicketByHash(contentHash); indexed != "" {
+		t.Errorf("Expected hash index to be removed, got %s\n", indexed)
+	}
+}
+
+func TestDedupHash(t *testing.T) {
+	chunk := []byte("the same chunk")
+	if DedupHash("tenant-a", chunk) != DedupHash("tenant-b", chunk) {
+		t.Errorf("Expected chunks to be shared without authentication\n")
+	}
+
+	defer useAuth()()
+	if DedupHash("tenant-a", chunk) == DedupHash("tenant-b", chunk) {
+		t.Errorf("Expected tenants not to share chunks\n")
+	}
+	if DedupHash("tenant-a", chunk) != DedupHash("tenant-a", chunk) {
+		t.Errorf("Expected a tenant to share chunks with itself\n")
+	}
+}
@@ -0,0 +1,292 @@
+// Encryption
+//
+// Ticket data can be encrypted at rest with AES-GCM. Each object gets its own
+// data key which the Router sends to WriteNodes along with the ticket data.
+// WriteNodes never store data keys, they encrypt (after compression) and
+// decrypt with the key they were given.
+//
+// Encrypted tickets are framed on disk as
+//
+//     [4B "DPE1"][12B Nonce][nB Sealed Data]
+//
+// Data keys are wrapped by a master key read from the keyfile named by
+// MASTER_KEYFILE and kept with the object
+//
+// 	/objects/objectID dataKey : MasterKeyID:base64(Nonce|Sealed Data Key)
+//
+// The keyfile is YAML holding every master key which may still wrap a data
+// key and the ID of the one new data keys are wrapped with
+//
+//     active: k2
+//     keys:
+//       k1: base64(32 bytes)
+//       k2: base64(32 bytes)
+//
+// Rotating master keys only re-wraps data keys, ticket data is not rewritten.
+package dataputter
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// Bytes in a data or master key, selecting AES-256
+	encryptionKeySize = 32
+)
+
+var (
+	// ErrDecryptFailed Ticket data or a data key could not be decrypted
+	ErrDecryptFailed = errors.New("Unable to decrypt")
+	// ErrNoMasterKey No master keyfile has been loaded
+	ErrNoMasterKey = errors.New("No master key configured")
+
+	encryptionMagic = []byte("DPE1")
+
+	// masterKeys: Keyring of the Router, nil when encryption is disabled
+	masterKeys *MasterKeyring
+)
+
+func init() {
+	keyfile := os.Getenv("MASTER_KEYFILE")
+	if len(keyfile) == 0 {
+		return
+	}
+	keyring, err := LoadMasterKeyring(keyfile)
+	if err != nil {
+		log.Printf("Unable to load master keyfile %s: %v\n", keyfile, err)
+		return
+	}
+	log.Printf("Loaded %d master keys, encryption at rest enabled\n", len(keyring.keys))
+	masterKeys = keyring
+}
+
+// MasterKeyring Master keys which wrap the data keys of objects
+type MasterKeyring struct {
+	// Active: ID of the key new data keys are wrapped with
+	Active string
+	keys   map[string][]byte
+}
+
+type masterKeyfile struct {
+	Active string            `yaml:"active"`
+	Keys   map[string]string `yaml:"keys"`
+}
+
+// LoadMasterKeyring Reads master keys from a YAML keyfile
+func LoadMasterKeyring(filename string) (*MasterKeyring, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	keyfile := masterKeyfile{}
+	if err := yaml.Unmarshal(b, &keyfile); err != nil {
+		return nil, err
+	}
+
+	keyring := &MasterKeyring{
+		Active: keyfile.Active,
+		keys:   map[string][]byte{},
+	}
+	for keyID, encoded := range keyfile.Keys {
+		if strings.Contains(keyID, ":") {
+			return nil, fmt.Errorf("Master key ID %s may not contain ':'\n", keyID)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Master key %s is not base64: %v\n", keyID, err)
+		}
+		if len(key) != encryptionKeySize {
+			return nil, fmt.Errorf("Master key %s is %d bytes, expected %d\n", keyID, len(key), encryptionKeySize)
+		}
+		keyring.keys[keyID] = key
+	}
+	if _, ok := keyring.keys[keyring.Active]; !ok {
+		return nil, fmt.Errorf("Active master key %s is not in the keyfile\n", keyring.Active)
+	}
+	return keyring, nil
+}
+
+// EncryptionEnabled True when the Router encrypts new objects
+func EncryptionEnabled() bool {
+	return masterKeys != nil
+}
+
+// NewDataKey Creates a random key for the data of one object
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, encryptionKeySize)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// WrapDataKey Encrypts the data key of an object with the active master key
+func (k *MasterKeyring) WrapDataKey(objectID string, dataKey []byte) (string, error) {
+	sealed, err := sealWithKey(k.keys[k.Active], dataKey, []byte(objectID))
+	if err != nil {
+		return "", err
+	}
+	return k.Active + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// UnwrapDataKey Decrypts the data key of an object with whichever master key wrapped it
+func (k *MasterKeyring) UnwrapDataKey(objectID, wrapped string) ([]byte, error) {
+	parts := strings.SplitN(wrapped, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Malformed data key for %s\n", objectID)
+	}
+	masterKey, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("Data key of %s is wrapped by unknown master key %s\n", objectID, parts[0])
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed data key for %s: %v\n", objectID, err)
+	}
+	return openWithKey(masterKey, sealed, []byte(objectID))
+}
+
+// IsWrappedByActive True when the wrapped data key uses the active master key
+func (k *MasterKeyring) IsWrappedByActive(wrapped string) bool {
+	return strings.HasPrefix(wrapped, k.Active+":")
+}
+
+func sealWithKey(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openWithKey(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecryptFailed
+	}
+	nonce := sealed[:aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptTicketData Seals stored ticket bytes with the data key of their object.
+// The TicketID is authenticated so tickets can't be swapped on disk.
+func encryptTicketData(dataKey []byte, ticketID string, stored []byte) ([]byte, error) {
+	sealed, err := sealWithKey(dataKey, stored, []byte(ticketID))
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, encryptionMagic...), sealed...), nil
+}
+
+// decryptTicketData Reverses encryptTicketData. Unframed data was never encrypted.
+func decryptTicketData(dataKey []byte, ticketID string, stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, encryptionMagic) {
+		return stored, nil
+	}
+	if len(dataKey) == 0 {
+		return nil, ErrDecryptFailed
+	}
+	return openWithKey(dataKey, stored[len(encryptionMagic):], []byte(ticketID))
+}
+
+// CreateObjectDataKey Creates and stores the wrapped data key of a new object.
+// Returns a nil key when encryption is disabled.
+// * Has Datastore access
+func CreateObjectDataKey(objectID string) ([]byte, error) {
+	if !EncryptionEnabled() {
+		return nil, nil
+	}
+	dataKey, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := masterKeys.WrapDataKey(objectID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return dataKey, SetObjectDataKey(objectID, wrapped)
+}
+
+// UnwrapObjectDataKey Unwraps the data key of an object, nil when it has none
+// * Has Datastore access
+func UnwrapObjectDataKey(objectID string) ([]byte, error) {
+	wrapped, err := GetObjectDataKey(objectID)
+	if err != nil || len(wrapped) == 0 {
+		return nil, err
+	}
+	if !EncryptionEnabled() {
+		return nil, ErrNoMasterKey
+	}
+	return masterKeys.UnwrapDataKey(objectID, wrapped)
+}
+
+// RotateMasterKey Re-wraps every object data key with the active master key.
+// Keys which changed while they were re-wrapped, like shredded keys, are
+// left alone. Returns the number of data keys which were re-wrapped.
+// * Has Datastore access
+func RotateMasterKey(keyring *MasterKeyring) (int, error) {
+	objectIDs, err := GetObjects()
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, objectID := range objectIDs {
+		wrapped, err := GetObjectDataKey(objectID)
+		if err != nil {
+			return rotated, err
+		}
+		if len(wrapped) == 0 || keyring.IsWrappedByActive(wrapped) {
+			continue
+		}
+
+		dataKey, err := keyring.UnwrapDataKey(objectID, wrapped)
+		if err != nil {
+			return rotated, fmt.Errorf("Unable to unwrap data key of %s: %v\n", objectID, err)
+		}
+		rewrapped, err := keyring.WrapDataKey(objectID, dataKey)
+		if err != nil {
+			return rotated, err
+		}
+		replaced, err := ReplaceObjectDataKey(objectID, wrapped, rewrapped)
+		if err != nil {
+			return rotated, err
+		}
+		if !replaced {
+			log.Printf("Data key of %s changed while it was rotated, leaving it\n", objectID)
+			continue
+		}
+		log.Printf("Rotated data key of %s to master key %s\n", objectID, keyring.Active)
+		rotated++
+	}
+	return rotated, nil
+}
@@ -0,0 +1,243 @@
+package dataputter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func writeTestKeyfile(t *testing.T, active string, keyIDs ...string) string {
+	f, err := ioutil.TempFile("", "keyfile")
+	if err != nil {
+		t.Fatalf("Expected a keyfile, got %v\n", err)
+	}
+	defer f.Close()
+
+	f.WriteString("active: " + active + "\nkeys:\n")
+	for _, keyID := range keyIDs {
+		key := bytes.Repeat([]byte(keyID[0:1]), encryptionKeySize)
+		f.WriteString("  " + keyID + ": " + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	return f.Name()
+}
+
+func TestStoreAndReadEncryptedTicket(t *testing.T) {
+	root, err := ioutil.TempDir("", "dataputter")
+	if err != nil {
+		t.Fatalf("Expected a temporary data root, got %v\n", err)
+	}
+	defer os.RemoveAll(root)
+	defer func(previous string) { dataRoot = previous }(dataRoot)
+	dataRoot = root
+
+	dataKey, _ := NewDataKey()
+	data := bytes.Repeat([]byte("secret\n"), 200)
+	wt := NewWriteTicket("00000002", "CHECKSUM", data)
+
+	if _, _, err := wt.Store(CompressionSnappy, dataKey); err != nil {
+		t.Fatalf("Expected to store ticket, got %v\n", err)
+	}
+	onDisk, _ := ioutil.ReadFile(ObjectPathString("00000002") + "/obj")
+	if bytes.Contains(onDisk, []byte("secret")) {
+		t.Errorf("Expected ticket to be encrypted on disk\n")
+	}
+
+	restored, err := ReadTicket("00000002", dataKey)
+	if err != nil || !bytes.Equal(restored, data) {
+		t.Errorf("Expected to read back the ticket data, got %v\n", err)
+	}
+
+	otherKey, _ := NewDataKey()
+	if _, err := ReadTicket("00000002", otherKey); err != ErrDecryptFailed {
+		t.Errorf("Expected ErrDecryptFailed with the wrong key, got %v\n", err)
+	}
+	if _, err := ReadTicket("00000002", nil); err != ErrDecryptFailed {
+		t.Errorf("Expected ErrDecryptFailed without a key, got %v\n", err)
+	}
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	before := writeTestKeyfile(t, "a1", "a1")
+	after := writeTestKeyfile(t, "b2", "a1", "b2")
+	defer os.Remove(before)
+	defer os.Remove(after)
+
+	oldKeyring, err := LoadMasterKeyring(before)
+	if err != nil {
+		t.Fatalf("Expected to load keyfile, got %v\n", err)
+	}
+	newKeyring, err := LoadMasterKeyring(after)
+	if err != nil {
+		t.Fatalf("Expected to load keyfile, got %v\n", err)
+	}
+
+	defer DeleteObjectReference("TEST_ROTATE_OBJECT")
+	CreateObject("TEST_ROTATE_OBJECT", "TEST_ROTATE_TICKET")
+	dataKey, _ := NewDataKey()
+	wrapped, _ := oldKeyring.WrapDataKey("TEST_ROTATE_OBJECT", dataKey)
+	SetObjectDataKey("TEST_ROTATE_OBJECT", wrapped)
+
+	if _, err := RotateMasterKey(newKeyring); err != nil {
+		t.Errorf("Expected rotation to succeed, got %v\n", err)
+	}
+
+	rewrapped, _ := GetObjectDataKey("TEST_ROTATE_OBJECT")
+	if !newKeyring.IsWrappedByActive(rewrapped) {
+		t.Errorf("Expected data key to be wrapped by b2, got %s\n", rewrapped)
+	}
+	unwrapped, err := newKeyring.UnwrapDataKey("TEST_ROTATE_OBJECT", rewrapped)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Errorf("Expected the same data key after rotation, got %v\n", err)
+	}
+}
+
+func TestStoreOnTargetEncrypted(t *testing.T) {
+	node := serveTestTicketNode(t)
+
+	data := bytes.Repeat([]byte("secret log line\n"), 64)
+	wt := NewWriteTicket(FormatID(4), "CHECKSUM", data)
+	wt.Compression = CompressionZstd
+	wt.DataKey, _ = NewDataKey()
+	if _, _, err := StoreOnTarget(wt, node); err != nil {
+		t.Fatalf("Expected to store the ticket, got %v\n", err)
+	}
+	stored, _ := readTicketFile(FormatID(4))
+	if !bytes.HasPrefix(stored, []byte("DPE1")) || bytes.Contains(stored, []byte("secret")) {
+		t.Errorf("Expected the ticket to be encrypted on disk, got %q\n", stored[:8])
+	}
+
+	restored, err := ReadFromTarget(FormatID(4), wt.DataKey, node)
+	if err != nil || !bytes.Equal(restored, data) {
+		t.Errorf("Expected to read back the ticket data, got %v\n", err)
+	}
+	otherKey, _ := NewDataKey()
+	for _, dataKey := range [][]byte{nil, otherKey} {
+		if _, err := ReadFromTarget(FormatID(4), dataKey, node); err != ErrDecryptFailed {
+			t.Errorf("Expected ErrDecryptFailed, got %v\n", err)
+		}
+	}
+}
+
+// shredOnReadStore Shreds an object right after its data key is read
+type shredOnReadStore struct {
+	*MemoryStore
+}
+
+func (s shredOnReadStore) GetObjectDataKey(objectID string) (string, error) {
+	wrapped, err := s.MemoryStore.GetObjectDataKey(objectID)
+	s.MemoryStore.ShredObjectDataKey(objectID)
+	return wrapped, err
+}
+
+func TestRotateShreddedMasterKey(t *testing.T) {
+	before := writeTestKeyfile(t, "a1", "a1")
+	after := writeTestKeyfile(t, "b2", "a1", "b2")
+	defer os.Remove(before)
+	defer os.Remove(after)
+	oldKeyring, _ := LoadMasterKeyring(before)
+	newKeyring, _ := LoadMasterKeyring(after)
+
+	defer UseMetadataStore(GetMetadataStore())
+	store := NewMemoryStore()
+	UseMetadataStore(store)
+	CreateObject("TEST_ROTATE_SHREDDED", "")
+	dataKey, _ := NewDataKey()
+	wrapped, _ := oldKeyring.WrapDataKey("TEST_ROTATE_SHREDDED", dataKey)
+	SetObjectDataKey("TEST_ROTATE_SHREDDED", wrapped)
+	SetObjectStatus("TEST_ROTATE_SHREDDED", ObjectStatus[ObjectWriting])
+	SetObjectStatus("TEST_ROTATE_SHREDDED", ObjectStatus[ObjectSaved])
+
+	UseMetadataStore(shredOnReadStore{store})
+	if rotated, err := RotateMasterKey(newKeyring); rotated != 0 || err != nil {
+		t.Errorf("Expected no data key rotated, got %d %v\n", rotated, err)
+	}
+	if wrapped, _ := store.GetObjectDataKey("TEST_ROTATE_SHREDDED"); len(wrapped) != 0 {
+		t.Errorf("Expected the shredded data key to stay destroyed, got %s\n", wrapped)
+	}
+}
+
+func TestShredObject(t *testing.T) {
+	defer DeleteObjectReference("TEST_SHRED_OBJECT")
+	defer DeleteTicket("TEST_SHRED_OBJECT", "TEST_SHRED_TICKET")
+	defer RemoveShreddedObject("TEST_SHRED_OBJECT")
+
+	if err := ShredObject("TEST_SHRED_OBJECT"); err != ErrNotEncrypted {
+		t.Errorf("Expected ErrNotEncrypted for an object without a data key, got %v\n", err)
+	}
+
+	SetObjectDataKey("TEST_SHRED_OBJECT", "k1:c2VhbGVk")
+	CreateObject("TEST_SHRED_OBJECT", "TEST_SHRED_TICKET")
+	CreateTicket("TEST_SHRED_TICKET", "TEST_SHRED_OBJECT", "TEST_NODE_ID", 0, 10, 10)
+	SetTicketStatus("TEST_SHRED_TICKET", TicketStatus[TicketSaved])
+
+	// Uploads in progress can't be shredded
+	if err := ShredObject("TEST_SHRED_OBJECT"); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Expected ErrIllegalTransition for a new object, got %v\n", err)
+	}
+	SetObjectStatus("TEST_SHRED_OBJECT", ObjectStatus[ObjectWriting])
+	SetObjectStatus("TEST_SHRED_OBJECT", ObjectStatus[ObjectSaved])
+
+	// No node is listening so the ticket bytes can't be deleted yet
+	if err := ShredObject("TEST_SHRED_OBJECT"); err != nil {
+		t.Errorf("Expected shred to succeed while nodes are unreachable, got %v\n", err)
+	}
+
+	wrapped, _ := GetObjectDataKey("TEST_SHRED_OBJECT")
+	if len(wrapped) != 0 {
+		t.Errorf("Expected data key to be destroyed, got %s\n", wrapped)
+	}
+	shredded, _ := GetShreddedObjects()
+	found := false
+	for _, objectID := range shredded {
+		found = found || objectID == "TEST_SHRED_OBJECT"
+	}
+	if !found {
+		t.Errorf("Expected TEST_SHRED_OBJECT to await cleanup, got %v\n", shredded)
+	}
+}
+
+func TestShredObjectRetriesFailedDeletes(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	deleting := &fakeWriteNode{}
+	failing := &fakeWriteNode{deleteStatus: NodeFailed}
+	nodes := map[string]string{
+		"SHRED_TICKET_1": serveFakeWriteNode(t, deleting),
+		"SHRED_TICKET_2": serveFakeWriteNode(t, failing),
+	}
+	CreateObject("SHRED_NODES_OBJECT", "SHRED_TICKET_1")
+	for ticketID, address := range nodes {
+		CreateTicket(ticketID, "SHRED_NODES_OBJECT", address, 0, 10, 10)
+		SetTicketStatus(ticketID, TicketStatus[TicketSaved])
+		TouchTicketCounter("SHRED_NODES_OBJECT")
+	}
+	SetObjectDataKey("SHRED_NODES_OBJECT", "k1:c2VhbGVk")
+	SetObjectStatus("SHRED_NODES_OBJECT", ObjectStatus[ObjectWriting])
+	SetObjectStatus("SHRED_NODES_OBJECT", ObjectStatus[ObjectSaved])
+
+	if err := ShredObject("SHRED_NODES_OBJECT"); err != nil {
+		t.Fatalf("Expected shred to succeed while a node fails, got %v\n", err)
+	}
+	if deleting.deletes != 1 || failing.deletes != 1 {
+		t.Errorf("Expected each ticket deleted from its own node, got %d and %d deletes\n", deleting.deletes, failing.deletes)
+	}
+	if tickets, _ := GetObjectTickets("SHRED_NODES_OBJECT"); len(tickets) != 1 || tickets[0] != "SHRED_TICKET_2" {
+		t.Errorf("Expected SHRED_TICKET_2 to remain, got %v\n", tickets)
+	}
+	if shredded, _ := GetShreddedObjects(); len(shredded) != 1 {
+		t.Errorf("Expected the object to await cleanup, got %v\n", shredded)
+	}
+
+	atomic.StoreInt32(&failing.deleteStatus, NodeSuccess)
+	if cleaned, err := CleanupShreddedObjects(); cleaned != 1 || err != nil {
+		t.Errorf("Expected the object to be cleaned up, got %d %v\n", cleaned, err)
+	}
+	if shredded, _ := GetShreddedObjects(); len(shredded) != 0 {
+		t.Errorf("Expected nothing left to clean up, got %v\n", shredded)
+	}
+}
@@ -0,0 +1,261 @@
+// Filesystem Check
+//
+// Fsck reconciles the metadata of objects with the bytes on WriteNodes
+//
+// 	missingTicket   : A ticket of an object has no metadata or its node can't serve it
+// 	orphanedFile    : An obj file under a node's dataRoot belongs to no ticket
+// 	byteGap         : The tickets of an object leave bytes between them uncovered
+// 	byteOverlap     : The tickets of an object cover the same bytes twice
+// 	counterMismatch : ticketCounter or writeCounter disagree with the tickets of an object
+//
+// Only objects with a recorded size are checked, the others may be in flight.
+// With Repair the safe cases are fixed. Counters are reset to the number of
+// tickets, objects with missing tickets are marked as errors, and orphaned
+// files older than OrphanAge are deleted. Byte ranges are only reported.
+package dataputter
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	FsckMissingTicket   = "missingTicket"
+	FsckOrphanedFile    = "orphanedFile"
+	FsckByteGap         = "byteGap"
+	FsckByteOverlap     = "byteOverlap"
+	FsckCounterMismatch = "counterMismatch"
+)
+
+// FsckOptions What Fsck checks and whether it repairs
+type FsckOptions struct {
+	// Repair: Fix the safe cases
+	Repair bool
+	// SkipNodes: Don't read tickets back from their nodes
+	SkipNodes bool
+	// DataRoot: dataRoot of a WriteNode on this machine to check for orphaned files
+	DataRoot string
+	// OrphanAge: Orphaned files younger than this may be writes in flight
+	// and are never deleted
+	OrphanAge time.Duration
+}
+
+// FsckProblem Something Fsck found
+type FsckProblem struct {
+	Kind     string
+	ObjectID string
+	TicketID string
+	Path     string
+	Detail   string
+	Repaired bool
+}
+
+func (p FsckProblem) String() string {
+	s := fmt.Sprintf("%s %s/%s", p.Kind, p.ObjectID, p.TicketID)
+	if len(p.Path) > 0 {
+		s += " " + p.Path
+	}
+	s += ": " + p.Detail
+	if p.Repaired {
+		s += " [repaired]"
+	}
+	return s
+}
+
+// Fsck Checks every object, and the files under DataRoot when it's set
+// * Has Datastore access
+func Fsck(options FsckOptions) ([]FsckProblem, error) {
+	problems := []FsckProblem{}
+
+	objectIDs, err := GetObjects()
+	if err != nil {
+		return problems, err
+	}
+	for _, objectID := range objectIDs {
+		objectProblems, err := FsckObject(objectID, options)
+		problems = append(problems, objectProblems...)
+		if err != nil {
+			return problems, err
+		}
+	}
+
+	if len(options.DataRoot) > 0 {
+		fileProblems, err := fsckDataRoot(options)
+		problems = append(problems, fileProblems...)
+		if err != nil {
+			return problems, err
+		}
+	}
+	return problems, nil
+}
+
+// FsckObject Checks the tickets, byte ranges and counters of an object
+// * Has Datastore access
+func FsckObject(objectID string, options FsckOptions) ([]FsckProblem, error) {
+	problems := []FsckProblem{}
+
+	offsets, err := GetObjectTicketOffsets(objectID)
+	if err != nil {
+		return problems, err
+	}
+	status, err := GetObjectStatus(objectID)
+	if err != nil {
+		return problems, err
+	}
+	// Shredded objects are deleted by the shred cleanup
+	size, err := GetObjectSize(objectID)
+	if err != nil || status == ObjectStatus[ObjectShredded] {
+		log.Printf("Skipping incomplete object %s with status %s\n", objectID, status)
+		return problems, nil
+	}
+
+	missing := 0
+	position := int64(0)
+	for _, ticketID := range sortedByOffset(offsets) {
+		ticket, err := GetTicketMetadata(ticketID)
+		if err != nil {
+			return problems, err
+		}
+		problem := FsckProblem{Kind: FsckMissingTicket, ObjectID: objectID, TicketID: ticketID}
+		switch {
+		case len(ticket.TicketID) == 0:
+			problem.Detail = "no ticket metadata"
+		case !options.SkipNodes && !VerifyTicket(ticket):
+			problem.Detail = "not served by node " + ticket.NodeID
+		}
+		if len(problem.Detail) > 0 {
+			problems = append(problems, problem)
+			missing++
+			continue
+		}
+
+		start := offsets[ticketID]
+		switch {
+		case start > position:
+			problems = append(problems, FsckProblem{
+				Kind: FsckByteGap, ObjectID: objectID, TicketID: ticketID,
+				Detail: fmt.Sprintf("bytes %d to %d are in no ticket", position, start-1),
+			})
+		case start < position:
+			problems = append(problems, FsckProblem{
+				Kind: FsckByteOverlap, ObjectID: objectID, TicketID: ticketID,
+				Detail: fmt.Sprintf("bytes %d to %d are in more than one ticket", start, position-1),
+			})
+		}
+		if end := start + ticket.ByteCount; end > position {
+			position = end
+		}
+	}
+	if missing == 0 && position < size {
+		problems = append(problems, FsckProblem{
+			Kind: FsckByteGap, ObjectID: objectID,
+			Detail: fmt.Sprintf("bytes %d to %d are in no ticket", position, size-1),
+		})
+	}
+
+	if missing > 0 && options.Repair {
+		err := SetObjectStatus(objectID, ObjectStatus[ObjectError])
+		for i := range problems {
+			problems[i].Repaired = err == nil && problems[i].Kind == FsckMissingTicket
+		}
+		if err != nil {
+			return problems, err
+		}
+	}
+
+	counterProblems, err := fsckCounters(objectID, int64(len(offsets)), options)
+	return append(problems, counterProblems...), err
+}
+
+// fsckCounters The ticketCounter is what deletes count down so it must be
+// the number of tickets. A complete object has written all of them.
+func fsckCounters(objectID string, tickets int64, options FsckOptions) ([]FsckProblem, error) {
+	problems := []FsckProblem{}
+
+	ticketCounter, err := GetTicketCounterValue(objectID)
+	if err != nil {
+		return problems, err
+	}
+	writeCounter, err := GetWriteCounterValue(objectID)
+	if err != nil {
+		return problems, err
+	}
+
+	expected := map[string]int64{}
+	if ticketCounter != tickets {
+		expected["/objects/"+objectID+"/ticketCounter"] = tickets
+	}
+	if writeCounter != tickets {
+		expected["/objects/"+objectID+"/writeCounter"] = tickets
+	}
+	if len(expected) == 0 {
+		return problems, nil
+	}
+
+	problem := FsckProblem{
+		Kind:     FsckCounterMismatch,
+		ObjectID: objectID,
+		Detail: fmt.Sprintf("ticketCounter %d and writeCounter %d for %d tickets",
+			ticketCounter, writeCounter, tickets),
+	}
+	if options.Repair {
+		for keyPath, value := range expected {
+			if err := setCounter(keyPath, value); err != nil {
+				return append(problems, problem), err
+			}
+		}
+		problem.Repaired = true
+	}
+	return append(problems, problem), nil
+}
+
+// setCounter Resets a counter to a value
+func setCounter(keyPath string, value int64) error {
+	if err := deleteKeyPath(keyPath); err != nil {
+		return err
+	}
+	_, err := metadataStore.IncrementCounter(keyPath, value)
+	return err
+}
+
+// fsckDataRoot Finds obj files which belong to no ticket
+func fsckDataRoot(options FsckOptions) ([]FsckProblem, error) {
+	problems := []FsckProblem{}
+
+	err := filepath.Walk(options.DataRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		ticketID, ok := TicketIDFromPath(options.DataRoot, path)
+		if !ok {
+			return nil
+		}
+		objectID, err := GetTicketObject(ticketID)
+		if err != nil || len(objectID) > 0 {
+			return err
+		}
+
+		problem := FsckProblem{
+			Kind:     FsckOrphanedFile,
+			TicketID: ticketID,
+			Path:     path,
+			Detail:   fmt.Sprintf("%d bytes modified %s", info.Size(), info.ModTime().Format(time.RFC3339)),
+		}
+		if options.Repair && time.Since(info.ModTime()) > options.OrphanAge {
+			if err := deleteBytes(path); err != nil {
+				log.Printf("Unable to delete orphaned %s: %v\n", path, err)
+			} else {
+				problem.Repaired = true
+			}
+		}
+		problems = append(problems, problem)
+		return nil
+	})
+	return problems, err
+}
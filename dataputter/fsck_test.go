@@ -0,0 +1,95 @@
+package dataputter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func countProblems(problems []FsckProblem) map[string]int {
+	kinds := map[string]int{}
+	for _, problem := range problems {
+		kinds[problem.Kind]++
+	}
+	return kinds
+}
+
+func TestFsckObject(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	CreateObject("FSCK_OBJECT", "FSCK_T1")
+	CreateTicket("FSCK_T1", "FSCK_OBJECT", "TEST_NODE_ID", 0, 9, 10)
+	CreateTicket("FSCK_T2", "FSCK_OBJECT", "TEST_NODE_ID", 15, 24, 10)
+	CreateTicket("FSCK_T3", "FSCK_OBJECT", "TEST_NODE_ID", 20, 29, 10)
+	SetObjectByteSize("FSCK_OBJECT", 30)
+	TouchTicketCounter("FSCK_OBJECT")
+	TouchWriteCounter("FSCK_OBJECT")
+
+	options := FsckOptions{SkipNodes: true, Repair: true}
+	problems, err := FsckObject("FSCK_OBJECT", options)
+	if err != nil {
+		t.Fatalf("Expected fsck to succeed, got %v\n", err)
+	}
+	kinds := countProblems(problems)
+	if kinds[FsckByteGap] != 1 || kinds[FsckByteOverlap] != 1 || kinds[FsckCounterMismatch] != 1 {
+		t.Errorf("Expected a gap, an overlap and a counter mismatch, got %v\n", problems)
+	}
+
+	if counter, _ := GetTicketCounterValue("FSCK_OBJECT"); counter != 3 {
+		t.Errorf("Expected ticketCounter to be repaired to 3, got %d\n", counter)
+	}
+	problems, _ = FsckObject("FSCK_OBJECT", options)
+	if kinds := countProblems(problems); kinds[FsckCounterMismatch] != 0 {
+		t.Errorf("Expected repaired counters to match, got %v\n", problems)
+	}
+
+	// A ticket without metadata marks the object as an error
+	AddTicketReference("FSCK_OBJECT", "FSCK_GONE", 30)
+	SetObjectByteSize("FSCK_OBJECT", 40)
+	problems, _ = FsckObject("FSCK_OBJECT", options)
+	if kinds := countProblems(problems); kinds[FsckMissingTicket] != 1 {
+		t.Errorf("Expected a missing ticket, got %v\n", problems)
+	}
+	if status, _ := GetObjectStatus("FSCK_OBJECT"); status != ObjectStatus[ObjectError] {
+		t.Errorf("Expected object status error, got %s\n", status)
+	}
+}
+
+func TestFsckOrphanedFiles(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	root, err := ioutil.TempDir("", "dataputter")
+	if err != nil {
+		t.Fatalf("Expected a temporary data root, got %v\n", err)
+	}
+	defer os.RemoveAll(root)
+
+	CreateTicket("LIVE", "FSCK_OBJECT", "TEST_NODE_ID", 0, 9, 10)
+	paths := map[string]string{}
+	for _, ticketID := range []string{"LIVE", "GONE", "NEW"} {
+		dir := filepath.Join(append([]string{root}, ObjectPathComponents(ticketID)...)...)
+		os.MkdirAll(dir, 0755)
+		paths[ticketID] = filepath.Join(dir, "obj")
+		ioutil.WriteFile(paths[ticketID], []byte("bytes"), 0644)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(paths["GONE"], old, old)
+
+	problems, err := Fsck(FsckOptions{DataRoot: root, Repair: true, OrphanAge: time.Hour})
+	if err != nil {
+		t.Fatalf("Expected fsck to succeed, got %v\n", err)
+	}
+	if kinds := countProblems(problems); kinds[FsckOrphanedFile] != 2 {
+		t.Errorf("Expected 2 orphaned files, got %v\n", problems)
+	}
+	if _, err := os.Stat(paths["GONE"]); !os.IsNotExist(err) {
+		t.Errorf("Expected the old orphan to be deleted, got %v\n", err)
+	}
+	if _, err := os.Stat(paths["NEW"]); err != nil {
+		t.Errorf("Expected the recent orphan to be kept, got %v\n", err)
+	}
+}
@@ -0,0 +1,277 @@
+// Garbage Collection
+//
+// A client which disconnects during an upload leaves its object behind
+// without a size, with tickets in the datastore and their bytes on nodes.
+// The size of an object is only recorded once all of its tickets are
+// written, so an object without one which is older than the TTL was
+// abandoned. Uploads still writing are only abandoned once their write
+// counter hasn't moved for the TTL either. Their tickets are deleted with
+// DeleteObject and every object collected is appended to the audit log with
+// the reason it was deleted.
+//
+// 	ROUTER_GC_TTL : Age of an upload before it's abandoned, default 1h, 0 turns GC off
+//
+// Objects created before createdAt was recorded have no age, it's set when
+// they're first found without a size and they're collected a TTL later.
+//
+// Objects stored in a bucket with a retention are deleted along with their
+// key once they're older than it.
+package dataputter
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	gcTTL = time.Hour
+
+	// gcProgress: Write counter of each upload found without a size and
+	// when it last moved
+	gcProgressLock sync.Mutex
+	gcProgress     = map[string]uploadProgress{}
+)
+
+// uploadProgress Writes of an upload and when their count last changed
+type uploadProgress struct {
+	writes int64
+	since  time.Time
+}
+
+func init() {
+	if ttl := os.Getenv("ROUTER_GC_TTL"); len(ttl) > 0 {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Printf("Ignoring ROUTER_GC_TTL=%s: %v\n", ttl, err)
+		} else {
+			gcTTL = d
+		}
+	}
+}
+
+// GCRecord An abandoned object which was collected
+type GCRecord struct {
+	Time      time.Time `json:"time"`
+	ObjectID  string    `json:"object"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Tickets: Tickets deleted with the object
+	Tickets []string `json:"tickets"`
+	// Bucket and Key: Where an expired object was stored
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
+	// Error: Why the object was only partly collected, it's tried again next time
+	Error string `json:"error,omitempty"`
+}
+
+// IsAbandonedObject True when an object has no size and is older than ttl,
+// and when it's writing, its writes haven't moved for ttl
+// * Has Datastore access
+func IsAbandonedObject(objectID string, ttl time.Duration) (bool, error) {
+	status, err := GetObjectStatus(objectID)
+	if err != nil {
+		return false, err
+	}
+	// Saved objects aren't uploads, shredded objects are deleted by the
+	// shred cleanup
+	if status == ObjectStatus[ObjectSaved] || status == ObjectStatus[ObjectShredded] {
+		return false, nil
+	}
+	if _, err := GetObjectSize(objectID); err == nil {
+		forgetProgress(objectID)
+		return false, nil
+	}
+	stalled, err := stalledFor(objectID)
+	if err != nil {
+		return false, err
+	}
+
+	createdAt, err := GetObjectCreatedAt(objectID)
+	if err != nil {
+		return false, err
+	}
+	// Objects from before createdAt was recorded get one now and a full TTL
+	if createdAt.IsZero() {
+		log.Printf("Object %s has no createdAt, collecting it after %s\n", objectID, ttl)
+		return false, StampObjectCreatedAt(objectID)
+	}
+	if time.Since(createdAt) <= ttl {
+		return false, nil
+	}
+	if status == ObjectStatus[ObjectWriting] {
+		return stalled > ttl, nil
+	}
+	return true, nil
+}
+
+// stalledFor How long the write counter of an upload hasn't moved, as far
+// as this process has seen
+// * Has Datastore access
+func stalledFor(objectID string) (time.Duration, error) {
+	writes, err := GetWriteCounterValue(objectID)
+	if err != nil {
+		return 0, err
+	}
+	gcProgressLock.Lock()
+	defer gcProgressLock.Unlock()
+
+	progress, ok := gcProgress[objectID]
+	if !ok || progress.writes != writes {
+		progress = uploadProgress{writes: writes, since: time.Now()}
+		gcProgress[objectID] = progress
+	}
+	return time.Since(progress.since), nil
+}
+
+func forgetProgress(objectID string) {
+	gcProgressLock.Lock()
+	defer gcProgressLock.Unlock()
+	delete(gcProgress, objectID)
+}
+
+// CollectAbandonedObjects Deletes objects abandoned for longer than ttl
+// and their tickets, appending each to the audit log
+// * Has Datastore access
+func CollectAbandonedObjects(ttl time.Duration) ([]GCRecord, error) {
+	records := []GCRecord{}
+
+	objectIDs, err := GetObjects()
+	if err != nil {
+		return records, err
+	}
+	for _, objectID := range objectIDs {
+		abandoned, err := IsAbandonedObject(objectID, ttl)
+		if err != nil {
+			return records, err
+		}
+		if !abandoned {
+			continue
+		}
+
+		record := collectObject(objectID)
+		auditGC(record, AuditAbandoned)
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ExpireBucketObjects Deletes the keys of buckets with a retention whose
+// objects are older than it, and their objects, appending each to the audit log
+// * Has Datastore access
+func ExpireBucketObjects() ([]GCRecord, error) {
+	records := []GCRecord{}
+
+	names, err := GetBuckets()
+	if err != nil {
+		return records, err
+	}
+	for _, name := range names {
+		bucket, err := GetBucket(name)
+		if err != nil {
+			return records, err
+		}
+		if bucket.Retention == 0 {
+			continue
+		}
+
+		cursor := ""
+		for {
+			keys, next, err := ListBucketKeys(name, "", cursor, MaxListLimit)
+			if err != nil {
+				return records, err
+			}
+			for _, key := range keys {
+				createdAt, err := GetObjectCreatedAt(key.ObjectID)
+				if err != nil {
+					return records, err
+				}
+				if createdAt.IsZero() || time.Since(createdAt) <= bucket.Retention {
+					continue
+				}
+				if _, err := metadataStore.DeleteBucketKey(name, key.Key); err != nil {
+					return records, err
+				}
+				record := collectObject(key.ObjectID)
+				record.Bucket, record.Key = name, key.Key
+				auditGC(record, AuditExpired)
+				records = append(records, record)
+			}
+			if cursor = next; len(cursor) == 0 {
+				break
+			}
+		}
+	}
+	return records, nil
+}
+
+// collectObject Deletes the tickets of an object and then the object. The
+// ticketCounter of an abandoned upload can count tickets which were never
+// written, so the object reference may outlive its last ticket.
+func collectObject(objectID string) GCRecord {
+	record := GCRecord{ObjectID: objectID, Tickets: []string{}}
+	forgetProgress(objectID)
+	record.Status, _ = GetObjectStatus(objectID)
+	record.CreatedAt, _ = GetObjectCreatedAt(objectID)
+
+	// Tickets an upload stopped before saving are failed so they can be deleted
+	ticketIDs, _ := GetObjectTickets(objectID)
+	for _, ticketID := range ticketIDs {
+		if status, _ := GetTicketStatus(ticketID); status == TicketStatus[TicketNew] {
+			SetTicketStatus(ticketID, TicketStatus[TicketError])
+		}
+	}
+
+	tickets, err := DeleteObject(objectID)
+	for _, ticket := range tickets {
+		record.Tickets = append(record.Tickets, ticket.TicketID)
+	}
+	if err == nil {
+		err = DeleteObjectReference(objectID)
+	}
+	if err != nil {
+		log.Printf("Unable to collect abandoned %s: %v\n", objectID, err)
+		record.Error = err.Error()
+	} else {
+		log.Printf("Collected abandoned %s with %d tickets\n", objectID, len(tickets))
+	}
+	record.Time = time.Now().UTC()
+	return record
+}
+
+// auditGC Appends the deletion of a collected object to the audit log
+func auditGC(record GCRecord, reason string) {
+	var err error
+	if len(record.Error) > 0 {
+		err = errors.New(record.Error)
+	}
+	Audit(AuditEntry{Operation: AuditDelete, Reason: reason, ObjectID: record.ObjectID}, err)
+}
+
+// Periodically collect abandoned objects and expire the objects of buckets
+func RunGC(interval time.Duration) {
+	if gcTTL == 0 {
+		log.Printf("Garbage collection of abandoned uploads is off\n")
+	}
+	for range time.Tick(interval) {
+		if gcTTL > 0 {
+			records, err := CollectAbandonedObjects(gcTTL)
+			if err != nil {
+				log.Printf("Garbage collection failed: %v\n", err)
+			}
+			if len(records) > 0 {
+				log.Printf("Collected %d abandoned objects\n", len(records))
+			}
+		}
+
+		records, err := ExpireBucketObjects()
+		if err != nil {
+			log.Printf("Bucket expiry failed: %v\n", err)
+		}
+		if len(records) > 0 {
+			log.Printf("Expired %d objects of buckets\n", len(records))
+		}
+	}
+}
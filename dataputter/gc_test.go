@@ -0,0 +1,92 @@
+package dataputter
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCollectAbandonedObjects(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	path, cleanup := useTempAuditLog(t)
+	defer cleanup()
+
+	// An upload which never finished
+	CreateObject("GC_ABANDONED", "GC_T1")
+	SetObjectStatus("GC_ABANDONED", ObjectStatus[ObjectWriting])
+	TouchTicketCounter("GC_ABANDONED")
+	// A finished upload
+	CreateObject("GC_SAVED", "GC_T2")
+	SetObjectByteSize("GC_SAVED", 10)
+
+	records, err := CollectAbandonedObjects(time.Hour)
+	if err != nil || len(records) != 0 {
+		t.Errorf("Expected young uploads to be kept, got %v %v\n", records, err)
+	}
+
+	records, err = CollectAbandonedObjects(0)
+	if err != nil {
+		t.Fatalf("Expected collection to succeed, got %v\n", err)
+	}
+	if len(records) != 1 || records[0].ObjectID != "GC_ABANDONED" {
+		t.Errorf("Expected GC_ABANDONED to be collected, got %v\n", records)
+	}
+	objects, _ := GetObjects()
+	if len(objects) != 1 || objects[0] != "GC_SAVED" {
+		t.Errorf("Expected only GC_SAVED to remain, got %v\n", objects)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected an audit log, got %v\n", err)
+	}
+	defer f.Close()
+	entries, err := FilterAuditLog(f, AuditFilter{})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 audit entry, got %v %v\n", entries, err)
+	}
+	if entry := entries[0]; entry.ObjectID != "GC_ABANDONED" || entry.Operation != AuditDelete || entry.Reason != AuditAbandoned || !entry.Success {
+		t.Errorf("Expected the deletion of GC_ABANDONED, got %+v\n", entry)
+	}
+}
+
+func TestIsAbandonedObject(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	// Objects from before createdAt was recorded wait a full TTL
+	metadataStore.RestoreObject(ObjectRecord{ObjectID: "GC_LEGACY", Status: ObjectStatus[ObjectNew]})
+	if abandoned, err := IsAbandonedObject("GC_LEGACY", 0); err != nil || abandoned {
+		t.Errorf("Expected an object without createdAt to be kept, got %v %v\n", abandoned, err)
+	}
+	if createdAt, _ := GetObjectCreatedAt("GC_LEGACY"); createdAt.IsZero() {
+		t.Errorf("Expected createdAt to be set\n")
+	}
+	if abandoned, _ := IsAbandonedObject("GC_LEGACY", time.Hour); abandoned {
+		t.Errorf("Expected GC_LEGACY to be kept for the TTL\n")
+	}
+
+	// Objects deleted since they were listed stay deleted
+	if abandoned, err := IsAbandonedObject("GC_DELETED", 0); err != nil || abandoned {
+		t.Errorf("Expected a deleted object to be kept out of GC, got %v %v\n", abandoned, err)
+	}
+	if objectIDs, _ := GetObjects(); len(objectIDs) != 1 {
+		t.Errorf("Expected only GC_LEGACY, got %v\n", objectIDs)
+	}
+
+	// Uploads which are still writing are kept
+	CreateObject("GC_WRITING", "GC_T1")
+	SetObjectStatus("GC_WRITING", ObjectStatus[ObjectWriting])
+	IsAbandonedObject("GC_WRITING", 0)
+	time.Sleep(20 * time.Millisecond)
+	TouchWriteCounter("GC_WRITING")
+	if abandoned, err := IsAbandonedObject("GC_WRITING", 10*time.Millisecond); err != nil || abandoned {
+		t.Errorf("Expected an upload whose writes moved to be kept, got %v %v\n", abandoned, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if abandoned, _ := IsAbandonedObject("GC_WRITING", 10*time.Millisecond); !abandoned {
+		t.Errorf("Expected a stalled upload to be abandoned\n")
+	}
+}
@@ -0,0 +1,206 @@
+// Layout
+//
+// A WriteNode stores the bytes of a ticket in an obj file in a directory
+// named after its TicketID, under NODE_FANOUT_DEPTH levels of directories
+// named after NODE_FANOUT_WIDTH characters of the TicketID. The levels are
+// taken from the end of the TicketID, which changes with every ticket, so
+// tickets spread evenly over the directories.
+//
+// 	NODE_FANOUT_WIDTH : Characters of the TicketID naming a level, default 2
+// 	NODE_FANOUT_DEPTH : Levels of directories above a ticket, default 2
+//
+// 	TicketID 0000000002s0h : data/0h/2s/0000000002s0h/obj
+//
+// Nodes used to store tickets under a directory for every character of the
+// TicketID, data/0/0/0/0/0/1/2/3/obj. Reads fall back to that layout and to
+// files moved by MigrateLayout as they're read, so a node can migrate while
+// it's serving.
+package dataputter
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// fanoutWidth: Characters of the TicketID naming a level
+	fanoutWidth = 2
+	// fanoutDepth: Levels of directories above a ticket
+	fanoutDepth = 2
+)
+
+func init() {
+	settings := map[string]*int{
+		"NODE_FANOUT_WIDTH": &fanoutWidth,
+		"NODE_FANOUT_DEPTH": &fanoutDepth,
+	}
+	for name, setting := range settings {
+		value := os.Getenv(name)
+		if len(value) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Ignoring %s=%s: %v\n", name, value, err)
+			continue
+		}
+		if n < 0 || (n == 0 && name == "NODE_FANOUT_WIDTH") {
+			log.Printf("Ignoring %s=%s: Out of range\n", name, value)
+			continue
+		}
+		*setting = n
+	}
+}
+
+// ObjectPathComponents Provides a list of path components for a ticket, the
+// fan-out levels and then the TicketID. TicketIDs too short for every level
+// get fewer.
+func ObjectPathComponents(ticketID string) []string {
+	components := []string{}
+	end := len(ticketID)
+	for level := 0; level < fanoutDepth && end-fanoutWidth >= 0; level++ {
+		components = append(components, ticketID[end-fanoutWidth:end])
+		end -= fanoutWidth
+	}
+	return append(components, ticketID)
+}
+
+// legacyObjectPathComponents Path components of a ticket stored before the
+// fan-out was configurable, a directory for each character
+func legacyObjectPathComponents(ticketID string) []string {
+	return strings.Split(ticketID, "")
+}
+
+// ObjectPathString Provide the object path string
+func ObjectPathString(ticketID string) string {
+	return objectPath(dataRoot, ObjectPathComponents(ticketID))
+}
+
+func objectPath(root string, components []string) string {
+	return strings.Join(
+		append([]string{root}, components...),
+		string(os.PathSeparator),
+	)
+}
+
+// ticketFilenames Files a ticket's bytes may be in, where it's written first.
+// It's tried again after the legacy layout in case MigrateLayout moved it
+// in between.
+func ticketFilenames(ticketID string) []string {
+	filename := ObjectPathString(ticketID) + "/obj"
+	return []string{
+		filename,
+		objectPath(dataRoot, legacyObjectPathComponents(ticketID)) + "/obj",
+		filename,
+	}
+}
+
+// readTicketFile The bytes of a ticket's file in either layout
+func readTicketFile(ticketID string) ([]byte, error) {
+	var err error
+	for _, filename := range ticketFilenames(ticketID) {
+		var stored []byte
+		if stored, err = ioutil.ReadFile(filename); !os.IsNotExist(err) {
+			return stored, err
+		}
+	}
+	return nil, err
+}
+
+// DeleteTicketBytes Deletes a ticket's file in either layout
+func DeleteTicketBytes(ticketID string) error {
+	var err error
+	for _, filename := range ticketFilenames(ticketID) {
+		if err = os.Remove(filename); !os.IsNotExist(err) {
+			return err
+		}
+	}
+	log.Printf("Bytes of %s do not exist: %v\n", ticketID, err)
+	return err
+}
+
+// TicketIDFromPath The TicketID whose bytes are stored at path under root in
+// either layout, false when the path isn't where ticket bytes are stored
+func TicketIDFromPath(root, path string) (string, bool) {
+	relative, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", false
+	}
+	components := strings.Split(relative, string(os.PathSeparator))
+	if len(components) < 2 || components[len(components)-1] != "obj" {
+		return "", false
+	}
+	components = components[:len(components)-1]
+	// Only the legacy layout names a ticket's directory with one character
+	if ticketID := components[len(components)-1]; len(ticketID) > 1 {
+		return ticketID, true
+	}
+	return strings.Join(components, ""), true
+}
+
+// LayoutMigration What MigrateLayout did
+type LayoutMigration struct {
+	// Moved: Tickets moved to the configured layout
+	Moved int
+	// Skipped: Tickets which are also in the configured layout, left where they are
+	Skipped int
+}
+
+// MigrateLayout Moves every ticket under root which isn't where the
+// configured layout stores it. Files are renamed, so a ticket is always in
+// one of the places reads look for it.
+func MigrateLayout(root string) (LayoutMigration, error) {
+	migration := LayoutMigration{}
+	moves := map[string]string{}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		ticketID, ok := TicketIDFromPath(root, path)
+		if !ok {
+			return nil
+		}
+		if target := objectPath(root, ObjectPathComponents(ticketID)) + "/obj"; target != path {
+			moves[path] = target
+		}
+		return nil
+	})
+	if err != nil {
+		return migration, err
+	}
+
+	for path, target := range moves {
+		if _, err := os.Stat(target); err == nil {
+			log.Printf("Leaving %s, %s already exists\n", path, target)
+			migration.Skipped++
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return migration, err
+		}
+		if err := os.Rename(path, target); err != nil {
+			return migration, err
+		}
+		removeEmptyDirs(root, filepath.Dir(path))
+		migration.Moved++
+	}
+	return migration, nil
+}
+
+// removeEmptyDirs Removes dir and its parents up to root while they're empty
+func removeEmptyDirs(root, dir string) {
+	for dir != filepath.Clean(root) && strings.HasPrefix(dir, filepath.Clean(root)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
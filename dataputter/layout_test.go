@@ -0,0 +1,76 @@
+package dataputter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestObjectPathComponents(t *testing.T) {
+	expected := []string{"0h", "2s", "0000000002s0h"}
+	if components := ObjectPathComponents("0000000002s0h"); !reflect.DeepEqual(components, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, components)
+	}
+	if components := ObjectPathComponents("abc"); !reflect.DeepEqual(components, []string{"bc", "abc"}) {
+		t.Errorf("Expected a short TicketID to get fewer levels, got %v\n", components)
+	}
+}
+
+func TestTicketIDFromPath(t *testing.T) {
+	paths := map[string]string{
+		"/data/0h/2s/0000000002s0h/obj": "0000000002s0h",
+		"/data/0/0/0/1/2/3/4/5/obj":     "00012345",
+	}
+	for path, expected := range paths {
+		if ticketID, ok := TicketIDFromPath("/data", path); !ok || ticketID != expected {
+			t.Errorf("Expected %s from %s, got %s\n", expected, path, ticketID)
+		}
+	}
+	if _, ok := TicketIDFromPath("/data", "/data/0h/notes"); ok {
+		t.Errorf("Expected only obj files to be tickets\n")
+	}
+}
+
+func TestMigrateLayout(t *testing.T) {
+	root, err := ioutil.TempDir("", "dataputter")
+	if err != nil {
+		t.Fatalf("Expected a temporary data root, got %v\n", err)
+	}
+	defer os.RemoveAll(root)
+	defer func(previous string) { dataRoot = previous }(dataRoot)
+	dataRoot = root
+
+	data := []byte("legacy bytes")
+	legacyDir := objectPath(root, legacyObjectPathComponents("00012345"))
+	os.MkdirAll(legacyDir, 0755)
+	ioutil.WriteFile(filepath.Join(legacyDir, "obj"), data, 0644)
+
+	// Reads fall back to the legacy layout
+	if restored, err := ReadTicket("00012345", nil); err != nil || !bytes.Equal(restored, data) {
+		t.Errorf("Expected to read the legacy ticket, got %v\n", err)
+	}
+
+	migration, err := MigrateLayout(root)
+	if err != nil || migration.Moved != 1 {
+		t.Fatalf("Expected 1 ticket to be moved, got %v %v\n", migration, err)
+	}
+	if _, err := os.Stat(ObjectPathString("00012345") + "/obj"); err != nil {
+		t.Errorf("Expected the ticket in the configured layout, got %v\n", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "0")); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty legacy directories to be removed, got %v\n", err)
+	}
+	if restored, err := ReadTicket("00012345", nil); err != nil || !bytes.Equal(restored, data) {
+		t.Errorf("Expected to read the moved ticket, got %v\n", err)
+	}
+
+	if migration, _ := MigrateLayout(root); migration.Moved != 0 {
+		t.Errorf("Expected nothing left to move, got %v\n", migration)
+	}
+	if err := DeleteTicketBytes("00012345"); err != nil {
+		t.Errorf("Expected to delete the ticket, got %v\n", err)
+	}
+}
@@ -0,0 +1,344 @@
+// Limits and Quotas
+//
+// The Router limits requests per second, upload bytes per second and
+// concurrent uploads, across all clients and for each tenant. Limits of 0
+// are off
+//
+// 	ROUTER_MAX_UPLOADS, ROUTER_TENANT_MAX_UPLOADS : Concurrent uploads
+// 	ROUTER_MAX_REQUESTS_PER_SECOND, ROUTER_TENANT_MAX_REQUESTS_PER_SECOND : Requests of any kind
+// 	ROUTER_MAX_BYTES_PER_SECOND, ROUTER_TENANT_MAX_BYTES_PER_SECOND : Bytes read from uploads
+//
+// Requests over a rate or upload limit are refused, uploads over a byte
+// rate are slowed down. Tenants may also have a quota of stored bytes and
+// objects. The size of an upload is reserved against the quota before any
+// tickets are issued and given back when the upload fails or the object is
+// deleted
+//
+// 	tenantQuotas/tenant : {bytes, objects}
+// 	tenantUsage/tenant  : {bytes, objects}
+//
+// TCP requests which are refused are answered with _LIMITED or _NOQUOTA
+// instead of an ObjectID, gRPC requests with ResourceExhausted and HTTP
+// requests with 429 or 507.
+package dataputter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// REPLY_LIMITED Reply to a TCP request over a rate or upload limit
+	REPLY_LIMITED = "_LIMITED"
+	// REPLY_NOQUOTA Reply to an upload over the quota of its tenant
+	REPLY_NOQUOTA = "_NOQUOTA"
+)
+
+var (
+	// ErrRateLimited Too many requests or uploads at once
+	ErrRateLimited = errors.New("Rate limit exceeded")
+	// ErrOverQuota The upload would take a tenant over its quota
+	ErrOverQuota = errors.New("Storage quota exceeded")
+
+	limitsMu sync.Mutex
+	// globalLimiter: Limits across every client
+	globalLimiter = newLimiter(Limits{})
+	// tenantLimits: Limits of each tenant, tenantLimiters: their state
+	tenantLimits   = Limits{}
+	tenantLimiters = map[string]*limiter{}
+)
+
+func init() {
+	global, tenant := Limits{}, Limits{}
+	envLimits := map[string]*float64{
+		"ROUTER_MAX_REQUESTS_PER_SECOND":        &global.RequestsPerSecond,
+		"ROUTER_MAX_BYTES_PER_SECOND":           &global.BytesPerSecond,
+		"ROUTER_TENANT_MAX_REQUESTS_PER_SECOND": &tenant.RequestsPerSecond,
+		"ROUTER_TENANT_MAX_BYTES_PER_SECOND":    &tenant.BytesPerSecond,
+	}
+	for name, limit := range envLimits {
+		if v := os.Getenv(name); len(v) > 0 {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n < 0 {
+				log.Printf("Ignoring %s=%s: %v\n", name, v, err)
+				continue
+			}
+			*limit = n
+		}
+	}
+	envUploads := map[string]*int{
+		"ROUTER_MAX_UPLOADS":        &global.Uploads,
+		"ROUTER_TENANT_MAX_UPLOADS": &tenant.Uploads,
+	}
+	for name, limit := range envUploads {
+		if v := os.Getenv(name); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Printf("Ignoring %s=%s: %v\n", name, v, err)
+				continue
+			}
+			*limit = n
+		}
+	}
+	UseLimits(global, tenant)
+}
+
+// Limits Rates and concurrent uploads allowed, 0 is unlimited
+type Limits struct {
+	Uploads           int
+	RequestsPerSecond float64
+	BytesPerSecond    float64
+}
+
+// Quota Stored bytes and objects of a tenant, as a limit or its usage.
+// Limits of 0 are unlimited.
+type Quota struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// Exceeded True when usage is over the quota
+func (q Quota) Exceeded(usage Quota) bool {
+	return (q.Bytes > 0 && usage.Bytes > q.Bytes) || (q.Objects > 0 && usage.Objects > q.Objects)
+}
+
+// UseLimits Replaces the global and per tenant limits, resetting their state
+func UseLimits(global, tenant Limits) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+
+	globalLimiter = newLimiter(global)
+	tenantLimits = tenant
+	tenantLimiters = map[string]*limiter{}
+}
+
+// tokenBucket Allows rate tokens a second, up to a second's worth at once
+type tokenBucket struct {
+	rate, tokens float64
+	last         time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// available True when n tokens can be taken
+func (b *tokenBucket) available(n float64) bool {
+	if b == nil {
+		return true
+	}
+	b.refill()
+	return b.tokens >= n
+}
+
+// take Takes n tokens, going into debt when there aren't enough. Returns
+// how long until the debt is paid.
+func (b *tokenBucket) take(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill()
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// limiter The state of a set of limits
+type limiter struct {
+	limits   Limits
+	uploads  int
+	requests *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		limits:   limits,
+		requests: newTokenBucket(limits.RequestsPerSecond),
+		bytes:    newTokenBucket(limits.BytesPerSecond),
+	}
+}
+
+// limitersOf The global limiter and that of the tenant, when there is one.
+// Hold limitsMu.
+func limitersOf(tenant string) []*limiter {
+	if len(tenant) == 0 {
+		return []*limiter{globalLimiter}
+	}
+	l, ok := tenantLimiters[tenant]
+	if !ok {
+		l = newLimiter(tenantLimits)
+		tenantLimiters[tenant] = l
+	}
+	return []*limiter{globalLimiter, l}
+}
+
+// AllowRequest ErrRateLimited when the tenant, or every client, is over its
+// requests per second
+func AllowRequest(tenant string) error {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+
+	limiters := limitersOf(tenant)
+	for _, l := range limiters {
+		if !l.requests.available(1) {
+			return ErrRateLimited
+		}
+	}
+	for _, l := range limiters {
+		l.requests.take(1)
+	}
+	return nil
+}
+
+// AcquireUpload Counts an upload of the tenant until release is called,
+// ErrRateLimited when there are too many already
+func AcquireUpload(tenant string) (release func(), err error) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+
+	limiters := limitersOf(tenant)
+	for _, l := range limiters {
+		if l.limits.Uploads > 0 && l.uploads >= l.limits.Uploads {
+			return nil, ErrRateLimited
+		}
+	}
+	for _, l := range limiters {
+		l.uploads++
+	}
+	return func() {
+		limitsMu.Lock()
+		defer limitsMu.Unlock()
+		for _, l := range limiters {
+			l.uploads--
+		}
+	}, nil
+}
+
+// throttledReader Slows reads of an upload to the bytes per second of its
+// tenant and of every client
+type throttledReader struct {
+	r      io.Reader
+	tenant string
+}
+
+// limitReader Throttles reads of an upload from r
+func limitReader(tenant string, r io.Reader) io.Reader {
+	return &throttledReader{r: r, tenant: tenant}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		limitsMu.Lock()
+		wait := time.Duration(0)
+		for _, l := range limitersOf(t.tenant) {
+			if d := l.bytes.take(float64(n)); d > wait {
+				wait = d
+			}
+		}
+		limitsMu.Unlock()
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// limitInterceptor Refuses gRPC requests over the requests per second of
+// their tenant with ResourceExhausted
+func limitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := AllowRequest(tenantOf(ctx)); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return handler(ctx, req)
+}
+
+// limitReply The TCP reply to a request refused with err
+func limitReply(err error) []byte {
+	switch err {
+	case ErrRateLimited:
+		return []byte(REPLY_LIMITED)
+	case ErrOverQuota:
+		return []byte(REPLY_NOQUOTA)
+	}
+	return []byte("_FAILED_")
+}
+
+// SetTenantQuota Limits the stored bytes and objects of a tenant
+// * Has Datastore access
+func SetTenantQuota(tenant string, quota Quota) error {
+	return metadataStore.SetTenantQuota(tenant, quota)
+}
+
+// GetTenantQuota The quota of a tenant and how much of it is used
+// * Has Datastore access
+func GetTenantQuota(tenant string) (Quota, Quota, error) {
+	quota, err := metadataStore.GetTenantQuota(tenant)
+	if err != nil {
+		return quota, Quota{}, err
+	}
+	usage, err := metadataStore.GetTenantUsage(tenant)
+	return quota, usage, err
+}
+
+// ReserveQuota Adds an object of size bytes to the usage of the tenant,
+// ErrOverQuota when it would go over its quota. Objects without a tenant
+// aren't counted.
+// * Has Datastore access
+func ReserveQuota(tenant string, size int64) error {
+	if len(tenant) == 0 {
+		return nil
+	}
+	reserved, err := metadataStore.ReserveTenantUsage(tenant, size, 1)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return ErrOverQuota
+	}
+	return nil
+}
+
+// ReleaseQuota Takes an object of size bytes off the usage of the tenant
+// * Has Datastore access
+func ReleaseQuota(tenant string, size int64) {
+	if len(tenant) == 0 {
+		return
+	}
+	if err := metadataStore.AddTenantUsage(tenant, -size, -1); err != nil {
+		log.Printf("Unable to release %d bytes of quota of %s: %v\n", size, tenant, err)
+	}
+}
+
+// AdjustQuota Corrects the bytes reserved for an upload by the difference
+// from the bytes it stored
+// * Has Datastore access
+func AdjustQuota(tenant string, difference int64) {
+	if len(tenant) == 0 || difference == 0 {
+		return
+	}
+	if err := metadataStore.AddTenantUsage(tenant, difference, 0); err != nil {
+		log.Printf("Unable to adjust quota of %s by %d bytes: %v\n", tenant, difference, err)
+	}
+}
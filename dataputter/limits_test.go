@@ -0,0 +1,131 @@
+package dataputter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAllowRequest(t *testing.T) {
+	defer UseLimits(Limits{}, Limits{})
+	UseLimits(Limits{RequestsPerSecond: 3}, Limits{RequestsPerSecond: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := AllowRequest("tenant-a"); err != nil {
+			t.Fatalf("Expected request %d of tenant-a to be allowed, got %v\n", i, err)
+		}
+	}
+	if err := AllowRequest("tenant-a"); err != ErrRateLimited {
+		t.Errorf("Expected tenant-a to be over its limit, got %v\n", err)
+	}
+	if err := AllowRequest("tenant-b"); err != nil {
+		t.Errorf("Expected tenant-b to have its own limit, got %v\n", err)
+	}
+	if err := AllowRequest("tenant-c"); err != ErrRateLimited {
+		t.Errorf("Expected every client to be over the global limit, got %v\n", err)
+	}
+}
+
+func TestAcquireUpload(t *testing.T) {
+	defer UseLimits(Limits{}, Limits{})
+	UseLimits(Limits{Uploads: 2}, Limits{Uploads: 1})
+
+	release, err := AcquireUpload("tenant-a")
+	if err != nil {
+		t.Fatalf("Expected an upload, got %v\n", err)
+	}
+	if _, err := AcquireUpload("tenant-a"); err != ErrRateLimited {
+		t.Errorf("Expected a second upload of tenant-a to be refused, got %v\n", err)
+	}
+	if _, err := AcquireUpload("tenant-b"); err != nil {
+		t.Errorf("Expected an upload of tenant-b, got %v\n", err)
+	}
+	if _, err := AcquireUpload("tenant-c"); err != ErrRateLimited {
+		t.Errorf("Expected a third upload to be over the global limit, got %v\n", err)
+	}
+	release()
+	if _, err := AcquireUpload("tenant-a"); err != nil {
+		t.Errorf("Expected an upload after one was released, got %v\n", err)
+	}
+}
+
+func TestLimitReader(t *testing.T) {
+	defer UseLimits(Limits{}, Limits{})
+	UseLimits(Limits{BytesPerSecond: 1000}, Limits{})
+
+	start := time.Now()
+	n, _ := io.Copy(ioutil.Discard, limitReader("", bytes.NewReader(make([]byte, 1500))))
+	if elapsed := time.Since(start); n != 1500 || elapsed < 400*time.Millisecond {
+		t.Errorf("Expected 1500 bytes at 1000 per second to take about half a second, got %d in %s\n", n, elapsed)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	SetTenantQuota("tenant-a", Quota{Bytes: 100, Objects: 2})
+
+	if err := ReserveQuota("tenant-a", 60); err != nil {
+		t.Fatalf("Expected 60 bytes to fit, got %v\n", err)
+	}
+	if err := ReserveQuota("tenant-a", 50); err != ErrOverQuota {
+		t.Errorf("Expected 110 bytes to be over quota, got %v\n", err)
+	}
+	ReserveQuota("tenant-a", 40)
+	if err := ReserveQuota("tenant-a", 0); err != ErrOverQuota {
+		t.Errorf("Expected a third object to be over quota, got %v\n", err)
+	}
+	ReleaseQuota("tenant-a", 60)
+	if _, usage, _ := GetTenantQuota("tenant-a"); usage.Bytes != 40 || usage.Objects != 1 {
+		t.Errorf("Expected 40 bytes in 1 object, got %+v\n", usage)
+	}
+	if err := ReserveQuota("tenant-b", 1<<40); err != nil {
+		t.Errorf("Expected a tenant without a quota to be unlimited, got %v\n", err)
+	}
+}
+
+func TestDeleteEmptyObjectReleasesQuota(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	ReserveQuota("tenant-a", 0)
+	SetObjectByteSize("TEST_EMPTY_OBJECT", 0)
+	SetObjectOwner("TEST_EMPTY_OBJECT", "tenant-a")
+
+	for i := 0; i < 2; i++ {
+		if _, err := DeleteObject("TEST_EMPTY_OBJECT"); err != nil {
+			t.Fatalf("Expected to delete the empty object, got %v\n", err)
+		}
+		if _, usage, _ := GetTenantQuota("tenant-a"); usage.Objects != 0 {
+			t.Errorf("Expected delete %d to leave no objects, got %+v\n", i, usage)
+		}
+	}
+}
+
+func TestCreateObjectOverQuota(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+	key, _ := CreateAPIKey("tenant-a")
+	SetTenantQuota("tenant-a", Quota{Bytes: 10})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go DoCreateObject(server, RouterConfig{})
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, 11)
+	go client.Write(append(append([]byte(AUTH_HEADER+key), size...), make([]byte, 11)...))
+
+	reply := make([]byte, 8)
+	io.ReadFull(client, reply)
+	if string(reply) != REPLY_NOQUOTA {
+		t.Errorf("Expected %s, got %s\n", REPLY_NOQUOTA, reply)
+	}
+	if _, usage, _ := GetTenantQuota("tenant-a"); usage != (Quota{}) {
+		t.Errorf("Expected nothing to be reserved, got %+v\n", usage)
+	}
+}
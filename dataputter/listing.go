@@ -0,0 +1,126 @@
+// Listing
+//
+// Objects are listed a page at a time from the set of objects. The cursor
+// of a page is handed back to get the next one and is empty after the
+// last page, so listing millions of objects never holds the datastore up.
+//
+// The read server lists a page with a request instead of a TicketID
+//
+// 	[8B "0LST0LST"][1B length][Cursor][2B Limit][1B length][Status][16B API Key]
+// 		-> [8B Size][JSON {"objects": [...], "cursor": ""}]
+//
+// The API key is only read when ROUTER_AUTH is set, only the objects of its
+// tenant are listed then. Requests which fail are answered with _FAILED_.
+package dataputter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+const (
+	// DefaultListLimit Objects in a page when no limit is asked for
+	DefaultListLimit = 100
+	// MaxListLimit Most objects in one page
+	MaxListLimit = 1000
+)
+
+// ListObjects A page of up to limit objects after cursor, only those with
+// status and owner when they're set. Returns the cursor of the next page.
+// * Has Datastore access
+func ListObjects(cursor string, limit int, status, owner string) ([]ObjectMetadata, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	objects := []ObjectMetadata{}
+	for {
+		objectIDs, next, err := ScanObjects(cursor, limit-len(objects))
+		if err != nil {
+			return objects, cursor, err
+		}
+		for _, objectID := range objectIDs {
+			object, err := GetObjectMetadata(objectID)
+			if err != nil {
+				return objects, cursor, err
+			}
+			if len(status) > 0 && object.Status != status {
+				continue
+			}
+			if len(owner) > 0 && object.Owner != owner {
+				continue
+			}
+			objects = append(objects, object)
+		}
+		cursor = next
+		// A filtered page keeps scanning until it's full
+		if len(cursor) == 0 || len(objects) >= limit {
+			return objects, cursor, nil
+		}
+	}
+}
+
+// ObjectPage A page of objects and the cursor of the next one
+type ObjectPage struct {
+	Objects []ObjectMetadata `json:"objects"`
+	Cursor  string           `json:"cursor"`
+}
+
+// serveListObjects Answers a list request of the read server
+// * Has Datastore access
+func serveListObjects(c io.Writer, r io.Reader) error {
+	cursor, err := readShortField(r)
+	limit := make([]byte, 2)
+	if err == nil {
+		_, err = io.ReadFull(r, limit)
+	}
+	var status []byte
+	if err == nil {
+		status, err = readShortField(r)
+	}
+	if err != nil {
+		log.Printf("Unable to read list request: %v\n", err)
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+	var tenant string
+	if authRequired {
+		if tenant, err = readAPIKey(r); err != nil {
+			log.Printf("Refused to list objects: %v\n", err)
+			c.Write([]byte(REPLY_FAILED))
+			return err
+		}
+	}
+	if err := AllowRequest(tenant); err != nil {
+		log.Printf("Refused to list objects of %s: %v\n", tenant, err)
+		c.Write(limitReply(err))
+		return err
+	}
+
+	page := ObjectPage{}
+	page.Objects, page.Cursor, err = ListObjects(string(cursor), int(binary.BigEndian.Uint16(limit)), string(status), tenant)
+	if err != nil {
+		log.Printf("Failed to list objects after %s: %v\n", cursor, err)
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+	return writeJSONReply(c, page)
+}
+
+// writeJSONReply Writes v as JSON after its size
+func writeJSONReply(c io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+	reply := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(reply, uint64(len(data)))
+	_, err = c.Write(append(reply, data...))
+	return err
+}
@@ -0,0 +1,133 @@
+package dataputter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestListObjects(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	for i := 0; i < 5; i++ {
+		objectID := fmt.Sprintf("LIST_OBJECT_%d", i)
+		CreateObject(objectID, "LIST_TICKET")
+		SetObjectStatus(objectID, ObjectStatus[ObjectWriting])
+		if i%2 == 0 {
+			SetObjectByteSize(objectID, int64(i))
+			SetObjectStatus(objectID, ObjectStatus[ObjectSaved])
+		}
+	}
+	SetObjectContentType("LIST_OBJECT_0", "text/plain")
+
+	seen := map[string]ObjectMetadata{}
+	cursor, pages := "", 0
+	for {
+		objects, next, err := ListObjects(cursor, 2, "", "")
+		if err != nil {
+			t.Fatalf("Expected a page of objects, got %v\n", err)
+		}
+		if len(objects) > 2 {
+			t.Errorf("Expected at most 2 objects in a page, got %d\n", len(objects))
+		}
+		for _, object := range objects {
+			seen[object.ObjectID] = object
+		}
+		pages++
+		if cursor = next; len(cursor) == 0 {
+			break
+		}
+	}
+	if len(seen) != 5 || pages != 3 {
+		t.Errorf("Expected 5 objects in 3 pages, got %d in %d\n", len(seen), pages)
+	}
+	if object := seen["LIST_OBJECT_0"]; object.ContentType != "text/plain" || object.Status != "saved" {
+		t.Errorf("Expected LIST_OBJECT_0 saved as text/plain, got %+v\n", object)
+	}
+	if object := seen["LIST_OBJECT_4"]; object.Size != 4 {
+		t.Errorf("Expected LIST_OBJECT_4 to have 4 bytes, got %d\n", object.Size)
+	}
+
+	writing, cursor, err := ListObjects("", 10, ObjectStatus[ObjectWriting], "")
+	if err != nil || len(writing) != 2 || len(cursor) != 0 {
+		t.Errorf("Expected 2 objects still writing in one page, got %v %s %v\n", writing, cursor, err)
+	}
+}
+
+func TestScanObjectsWithoutCount(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	for i := 0; i < 3; i++ {
+		CreateObject(fmt.Sprintf("SCAN_OBJECT_%d", i), "SCAN_TICKET")
+	}
+	for _, count := range []int{0, -1} {
+		objectIDs, next, err := ScanObjects("", count)
+		if err != nil || len(objectIDs) != 1 || next != "SCAN_OBJECT_0" {
+			t.Errorf("Expected a page of SCAN_OBJECT_0 for count %d, got %v %s %v\n", count, objectIDs, next, err)
+		}
+	}
+}
+
+// readServerRequest Sends a request to the read server and reads its JSON reply into v
+func readServerRequest(request []byte, v interface{}) error {
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- ServeTicketBytes(server, readNodeClient{}) }()
+	defer client.Close()
+	go client.Write(request)
+
+	size, err := readNodeReply(client)
+	if err != nil {
+		<-done
+		return err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(client, data); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	return <-done
+}
+
+func TestServeListObjects(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+
+	keyA, _ := CreateAPIKey("tenant-a")
+	for i := 0; i < 4; i++ {
+		objectID := FormatID(int64(i + 1))
+		CreateObject(objectID, "LIST_TICKET")
+		SetObjectStatus(objectID, ObjectStatus[ObjectSaved])
+		SetObjectOwner(objectID, []string{"tenant-a", "tenant-b"}[i%2])
+	}
+	listRequest := func(cursor string, limit byte, apiKey string) []byte {
+		request := append([]byte(LIST_HEADER), byte(len(cursor)))
+		request = append(append(request, cursor...), 0, limit, 0)
+		return append(request, apiKey...)
+	}
+
+	page := ObjectPage{}
+	if err := readServerRequest(listRequest("", 1, keyA), &page); err != nil {
+		t.Fatalf("Expected a page of objects, got %v\n", err)
+	}
+	if len(page.Objects) != 1 || page.Objects[0].ObjectID != FormatID(1) || len(page.Cursor) == 0 {
+		t.Errorf("Expected the first object of tenant-a and a cursor, got %+v\n", page)
+	}
+	next := ObjectPage{}
+	if err := readServerRequest(listRequest(page.Cursor, 10, keyA), &next); err != nil {
+		t.Fatalf("Expected the next page of objects, got %v\n", err)
+	}
+	if len(next.Objects) != 1 || next.Objects[0].ObjectID != FormatID(3) || next.Objects[0].Owner != "tenant-a" {
+		t.Errorf("Expected only the other object of tenant-a, got %+v\n", next)
+	}
+	if err := readServerRequest(listRequest("", 10, "NotARealAPIKey!!"), &page); err == nil {
+		t.Errorf("Expected a list request with an unknown API key to fail\n")
+	}
+}
@@ -0,0 +1,881 @@
+// Memory Store
+//
+// A MetadataStore kept in the memory of one process. Nothing is shared with
+// other processes or survives a restart, which suits tests and a standAlone
+// Router serving its own reads.
+package dataputter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore MetadataStore in process memory
+type MemoryStore struct {
+	mu sync.Mutex
+
+	objectIDs    map[string]bool
+	objects      map[string]*memoryObject
+	tickets      map[string]*Ticket
+	counters     map[string]int64
+	ticketHashes map[string]string
+	shredded     map[string]bool
+	buckets      map[string]*memoryBucket
+	apiKeys      map[string]string
+	quotas       map[string]Quota
+	usage        map[string]Quota
+}
+
+type memoryBucket struct {
+	Bucket
+	// keys: ObjectID of each key
+	keys   map[string]string
+	policy []Grant
+}
+
+type memoryObject struct {
+	status, dataKey string
+	size            string
+	storedSize      int64
+	contentType     string
+	checksum        string
+	owner           string
+	bucket, key     string
+	createdAt       time.Time
+	// tickets: TicketIDs of the object
+	tickets map[string]bool
+	// nodes: NodeIDs with tickets of the object
+	nodes map[string]bool
+	// bytes: Start byte of each TicketID
+	bytes map[string]int64
+}
+
+// NewMemoryStore An empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		objectIDs:    map[string]bool{},
+		objects:      map[string]*memoryObject{},
+		tickets:      map[string]*Ticket{},
+		counters:     map[string]int64{},
+		ticketHashes: map[string]string{},
+		shredded:     map[string]bool{},
+		buckets:      map[string]*memoryBucket{},
+		apiKeys:      map[string]string{},
+		quotas:       map[string]Quota{},
+		usage:        map[string]Quota{},
+	}
+}
+
+// object Record of an object, created on first use the way Redis keys are
+func (m *MemoryStore) object(objectID string) *memoryObject {
+	o, ok := m.objects[objectID]
+	if !ok {
+		o = &memoryObject{
+			tickets: map[string]bool{},
+			nodes:   map[string]bool{},
+			bytes:   map[string]int64{},
+		}
+		m.objects[objectID] = o
+	}
+	return o
+}
+
+func (m *MemoryStore) ticket(ticketID string) *Ticket {
+	t, ok := m.tickets[ticketID]
+	if !ok {
+		t = &Ticket{KeyPath: "/tickets/" + ticketID}
+		m.tickets[ticketID] = t
+	}
+	return t
+}
+
+func setMembers(set map[string]bool) []string {
+	members := []string{}
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (m *MemoryStore) IncrementCounter(keyPath string, by int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters[keyPath] += by
+	return m.counters[keyPath], nil
+}
+
+func (m *MemoryStore) GetCounter(keyPath string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counters[keyPath], nil
+}
+
+func (m *MemoryStore) DeleteCounter(keyPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.counters, keyPath)
+	return nil
+}
+
+func (m *MemoryStore) CreateObject(objectID, ticketID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objectIDs[objectID] = true
+	o := m.object(objectID)
+	if len(o.status) == 0 {
+		o.status = ObjectStatus[ObjectNew]
+	}
+	if o.createdAt.IsZero() {
+		o.createdAt = time.Now()
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetObjects() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return setMembers(m.objectIDs), nil
+}
+
+// ScanObjects Pages through ObjectIDs in order, the cursor is the last
+// ObjectID of the previous page. Pages have at least one ObjectID.
+func (m *MemoryStore) ScanObjects(cursor string, count int) ([]string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if count < 1 {
+		count = 1
+	}
+	objectIDs := setMembers(m.objectIDs)
+	start := sort.SearchStrings(objectIDs, cursor)
+	if start < len(objectIDs) && objectIDs[start] == cursor {
+		start++
+	}
+	end := start + count
+	if end >= len(objectIDs) {
+		return objectIDs[start:], "", nil
+	}
+	return objectIDs[start:end], objectIDs[end-1], nil
+}
+
+func (m *MemoryStore) GetObjectMetadata(objectID string) (ObjectMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	object := ObjectMetadata{ObjectID: objectID}
+	o, ok := m.objects[objectID]
+	if !ok {
+		return object, nil
+	}
+	object.Status = o.status
+	object.StoredSize = o.storedSize
+	object.ContentType = o.contentType
+	object.Checksum = o.checksum
+	object.Owner = o.owner
+	object.Bucket = o.bucket
+	object.Key = o.key
+	object.CreatedAt = o.createdAt
+	if len(o.size) > 0 {
+		fmt.Sscanf(o.size, "%d", &object.Size)
+	}
+	return object, nil
+}
+
+func (m *MemoryStore) SetObjectChecksum(objectID, checksum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.object(objectID).checksum = checksum
+	return nil
+}
+
+func (m *MemoryStore) SetObjectOwner(objectID, tenant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.object(objectID).owner = tenant
+	return nil
+}
+
+func (m *MemoryStore) SetObjectKey(objectID, bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.object(objectID)
+	o.bucket, o.key = bucket, key
+	return nil
+}
+
+func (m *MemoryStore) SetObjectContentType(objectID, contentType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.object(objectID).contentType = contentType
+	return nil
+}
+
+func (m *MemoryStore) GetObjectStatus(objectID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.objects[objectID]; ok {
+		return o.status, nil
+	}
+	return "", nil
+}
+
+func (m *MemoryStore) StampObjectCreatedAt(objectID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.objects[objectID]; ok && o.createdAt.IsZero() {
+		o.createdAt = time.Now()
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetObjectCreatedAt(objectID string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.objects[objectID]; ok {
+		return o.createdAt, nil
+	}
+	return time.Time{}, nil
+}
+
+func (m *MemoryStore) SetObjectStatus(objectID, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.object(objectID)
+	if !CanTransition(ObjectTransitions, o.status, status) {
+		return transitionError("object", objectID, o.status, status)
+	}
+	o.status = status
+	return nil
+}
+
+func (m *MemoryStore) SetObjectByteSize(objectID string, sizeInBytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.object(objectID).size = fmt.Sprintf("%d", sizeInBytes)
+	return nil
+}
+
+func (m *MemoryStore) GetObjectSize(objectID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.objects[objectID]
+	if !ok || len(o.size) == 0 {
+		return 0, fmt.Errorf("No size for object %s\n", objectID)
+	}
+	var size int64
+	_, err := fmt.Sscanf(o.size, "%d", &size)
+	return size, err
+}
+
+func (m *MemoryStore) GetObjectStoredSize(objectID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.objects[objectID]; ok {
+		return o.storedSize, nil
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) SetObjectDataKey(objectID, wrappedKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.object(objectID).dataKey = wrappedKey
+	return nil
+}
+
+func (m *MemoryStore) ReplaceObjectDataKey(objectID, previous, wrappedKey string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.objects[objectID]
+	if !ok || o.dataKey != previous {
+		return false, nil
+	}
+	o.dataKey = wrappedKey
+	return true, nil
+}
+
+func (m *MemoryStore) GetObjectDataKey(objectID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.objects[objectID]; ok {
+		return o.dataKey, nil
+	}
+	return "", nil
+}
+
+func (m *MemoryStore) ShredObjectDataKey(objectID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.object(objectID)
+	shredded := ObjectStatus[ObjectShredded]
+	if !CanTransition(ObjectTransitions, o.status, shredded) {
+		return transitionError("object", objectID, o.status, shredded)
+	}
+	o.dataKey = ""
+	o.status = shredded
+	m.shredded[objectID] = true
+	return nil
+}
+
+func (m *MemoryStore) GetShreddedObjects() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return setMembers(m.shredded), nil
+}
+
+func (m *MemoryStore) RemoveShreddedObject(objectID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.shredded, objectID)
+	return nil
+}
+
+func (m *MemoryStore) GetObjectTickets(objectID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.objects[objectID]; ok {
+		return setMembers(o.tickets), nil
+	}
+	return []string{}, nil
+}
+
+func (m *MemoryStore) GetObjectNodes(objectID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.objects[objectID]; ok {
+		return setMembers(o.nodes), nil
+	}
+	return []string{}, nil
+}
+
+func (m *MemoryStore) GetObjectTicketOffsets(objectID string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	offsets := map[string]int64{}
+	if o, ok := m.objects[objectID]; ok {
+		for ticketID, byteStart := range o.bytes {
+			offsets[ticketID] = byteStart
+		}
+	}
+	return offsets, nil
+}
+
+func (m *MemoryStore) RestoreObject(object ObjectRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	objectID := object.ObjectID
+	m.objectIDs[objectID] = true
+	o := m.object(objectID)
+	o.status = object.Status
+	o.dataKey = object.DataKey
+	o.storedSize = object.StoredSize
+	o.contentType = object.ContentType
+	o.checksum = object.Checksum
+	o.owner = object.Owner
+	if object.CreatedAt > 0 {
+		o.createdAt = time.Unix(object.CreatedAt, 0)
+	}
+	if object.Size != nil {
+		o.size = fmt.Sprintf("%d", *object.Size)
+	}
+	for ticketID, byteStart := range object.Tickets {
+		o.bytes[ticketID] = byteStart
+		o.tickets[ticketID] = true
+	}
+	for _, nodeID := range object.Nodes {
+		o.nodes[nodeID] = true
+	}
+	if object.PendingCleanup {
+		m.shredded[objectID] = true
+	}
+	m.counters["/objects/"+objectID+"/ticketCounter"] = object.TicketCounter
+	m.counters["/objects/"+objectID+"/writeCounter"] = object.WriteCounter
+	return nil
+}
+
+func (m *MemoryStore) RestoreTicket(ticket Ticket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ticket.KeyPath = "/tickets/" + ticket.TicketID
+	m.tickets[ticket.TicketID] = &ticket
+	if _, ok := m.ticketHashes[ticket.ContentHash]; len(ticket.ContentHash) > 0 && !ok {
+		m.ticketHashes[ticket.ContentHash] = ticket.TicketID
+	}
+	return nil
+}
+
+// GetTicketsFromOffset Ordered by start byte then TicketID like a sorted set
+func (m *MemoryStore) GetTicketsFromOffset(objectID string, offset int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tickets := []string{}
+	o, ok := m.objects[objectID]
+	if !ok {
+		return tickets, nil
+	}
+	for ticketID, byteStart := range o.bytes {
+		if byteStart >= offset && byteStart <= offset+int64(1024*512) {
+			tickets = append(tickets, ticketID)
+		}
+	}
+	sort.Slice(tickets, func(i, j int) bool {
+		a, b := o.bytes[tickets[i]], o.bytes[tickets[j]]
+		if a == b {
+			return tickets[i] < tickets[j]
+		}
+		return a < b
+	})
+	return tickets, nil
+}
+
+func (m *MemoryStore) DeleteObjectReference(objectID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The bucket key is kept when it was pointed at another object since
+	if o, ok := m.objects[objectID]; ok {
+		if b, ok := m.buckets[o.bucket]; ok && b.keys[o.key] == objectID {
+			delete(b.keys, o.key)
+		}
+	}
+	delete(m.objects, objectID)
+	delete(m.counters, "/objects/"+objectID+"/writeCounter")
+	delete(m.counters, "/objects/"+objectID+"/ticketCounter")
+	delete(m.objectIDs, objectID)
+	return nil
+}
+
+func (m *MemoryStore) CreateTicket(ticketID, objectID, nodeID string, byteStart, byteEnd, byteCount int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.ticket(ticketID)
+	t.ByteCount = byteCount
+	t.ByteStart = byteStart
+	t.ByteEnd = byteEnd
+	t.ObjectID = objectID
+	t.TicketID = ticketID
+	t.NodeID = nodeID
+	if len(t.Status) == 0 {
+		t.Status = TicketStatus[TicketNew]
+	}
+
+	o := m.object(objectID)
+	o.bytes[ticketID] = byteStart
+	o.tickets[ticketID] = true
+	o.nodes[nodeID] = true
+	return nil
+}
+
+func (m *MemoryStore) SetTicketStoredByteCount(objectID, ticketID string, storedByteCount int64, compression string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.ticket(ticketID)
+	t.StoredByteCount = storedByteCount
+	t.Compression = compression
+	m.object(objectID).storedSize += storedByteCount
+	return nil
+}
+
+func (m *MemoryStore) SetTicketStatus(ticketID, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.ticket(ticketID)
+	if !CanTransition(TicketTransitions, t.Status, status) {
+		return transitionError("ticket", ticketID, t.Status, status)
+	}
+	t.Status = status
+	return nil
+}
+
+func (m *MemoryStore) GetTicketStatus(ticketID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tickets[ticketID]; ok {
+		return t.Status, nil
+	}
+	return "", nil
+}
+
+func (m *MemoryStore) GetTicketNode(ticketID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tickets[ticketID]; ok {
+		return t.NodeID, nil
+	}
+	return "", nil
+}
+
+func (m *MemoryStore) GetTicketObject(ticketID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tickets[ticketID]; ok {
+		return t.ObjectID, nil
+	}
+	return "", nil
+}
+
+func (m *MemoryStore) GetTicketSize(ticketID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tickets[ticketID]
+	if !ok || len(t.TicketID) == 0 {
+		return 0, fmt.Errorf("No size for ticket %s\n", ticketID)
+	}
+	return t.ByteCount, nil
+}
+
+func (m *MemoryStore) GetTicketMetadata(ticketID string) (Ticket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tickets[ticketID]; ok {
+		return *t, nil
+	}
+	return Ticket{KeyPath: "/tickets/" + ticketID}, nil
+}
+
+func (m *MemoryStore) DeleteTicket(objectID, ticketID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tickets[ticketID]; ok && m.ticketHashes[t.ContentHash] == ticketID {
+		delete(m.ticketHashes, t.ContentHash)
+	}
+	if o, ok := m.objects[objectID]; ok {
+		delete(o.tickets, ticketID)
+	}
+	delete(m.tickets, ticketID)
+	return nil
+}
+
+func (m *MemoryStore) IndexTicketHash(ticketID, contentHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.ticket(ticketID)
+	t.ContentHash = contentHash
+	t.References = 1
+	if _, ok := m.ticketHashes[contentHash]; !ok {
+		m.ticketHashes[contentHash] = ticketID
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetTicketByHash(contentHash string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ticketHashes[contentHash], nil
+}
+
+func (m *MemoryStore) IsObjectTicket(objectID, ticketID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if o, ok := m.objects[objectID]; ok {
+		return o.tickets[ticketID], nil
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) AddTicketReference(objectID, ticketID string, byteStart int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.ticket(ticketID)
+	t.References++
+
+	o := m.object(objectID)
+	o.bytes[ticketID] = byteStart
+	o.tickets[ticketID] = true
+	o.nodes[t.NodeID] = true
+	return nil
+}
+
+func (m *MemoryStore) GetTicketReferenceCount(ticketID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tickets[ticketID]; ok {
+		return t.References, nil
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) ReleaseTicketReference(objectID, ticketID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.ticket(ticketID)
+	t.References--
+	if o, ok := m.objects[objectID]; ok {
+		delete(o.tickets, ticketID)
+		delete(o.bytes, ticketID)
+	}
+	return t.References, nil
+}
+
+func (m *MemoryStore) CreateBucket(bucket Bucket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.buckets[bucket.Name]; ok {
+		return ErrBucketExists
+	}
+	m.buckets[bucket.Name] = &memoryBucket{Bucket: bucket, keys: map[string]string{}}
+	return nil
+}
+
+func (m *MemoryStore) GetBucket(name string) (Bucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[name]
+	if !ok {
+		return Bucket{Name: name}, ErrBucketNotExist
+	}
+	return b.Bucket, nil
+}
+
+func (m *MemoryStore) GetBuckets() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := []string{}
+	for name := range m.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *MemoryStore) DeleteBucket(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[name]
+	if !ok {
+		return ErrBucketNotExist
+	}
+	if len(b.keys) > 0 {
+		return ErrBucketNotEmpty
+	}
+	delete(m.buckets, name)
+	return nil
+}
+
+func (m *MemoryStore) PutBucketKey(bucket, key, objectID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return "", ErrBucketNotExist
+	}
+	previous := b.keys[key]
+	b.keys[key] = objectID
+	return previous, nil
+}
+
+func (m *MemoryStore) GetBucketKey(bucket, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if b, ok := m.buckets[bucket]; ok {
+		return b.keys[key], nil
+	}
+	return "", nil
+}
+
+func (m *MemoryStore) DeleteBucketKey(bucket, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return "", nil
+	}
+	objectID := b.keys[key]
+	delete(b.keys, key)
+	return objectID, nil
+}
+
+func (m *MemoryStore) ScanBucketKeys(bucket, prefix, cursor string, count int) ([]BucketKey, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	page := []BucketKey{}
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return page, "", nil
+	}
+	keys := []string{}
+	for key := range b.keys {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	next := ""
+	if len(keys) > count {
+		keys = keys[:count]
+		next = keys[count-1]
+	}
+	for _, key := range keys {
+		page = append(page, BucketKey{Key: key, ObjectID: b.keys[key]})
+	}
+	return page, next, nil
+}
+
+func (m *MemoryStore) PutBucketPolicy(bucket string, grants []Grant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return ErrBucketNotExist
+	}
+	b.policy = append([]Grant{}, grants...)
+	return nil
+}
+
+func (m *MemoryStore) GetBucketPolicy(bucket string) ([]Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	grants := []Grant{}
+	if b, ok := m.buckets[bucket]; ok {
+		grants = append(grants, b.policy...)
+	}
+	return grants, nil
+}
+
+func (m *MemoryStore) PutAPIKey(keyHash, tenant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.apiKeys[keyHash] = tenant
+	return nil
+}
+
+func (m *MemoryStore) GetAPIKeyTenant(keyHash string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.apiKeys[keyHash], nil
+}
+
+func (m *MemoryStore) DeleteAPIKey(keyHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.apiKeys, keyHash)
+	return nil
+}
+
+func (m *MemoryStore) GetAPIKeys() (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := map[string]string{}
+	for keyHash, tenant := range m.apiKeys {
+		keys[keyHash] = tenant
+	}
+	return keys, nil
+}
+
+func (m *MemoryStore) GetTenants() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenants := map[string]bool{}
+	for tenant := range m.quotas {
+		tenants[tenant] = true
+	}
+	for tenant := range m.usage {
+		tenants[tenant] = true
+	}
+	return setMembers(tenants), nil
+}
+
+func (m *MemoryStore) SetTenantQuota(tenant string, quota Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.quotas[tenant] = quota
+	return nil
+}
+
+func (m *MemoryStore) GetTenantQuota(tenant string) (Quota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.quotas[tenant], nil
+}
+
+func (m *MemoryStore) GetTenantUsage(tenant string) (Quota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.usage[tenant], nil
+}
+
+func (m *MemoryStore) ReserveTenantUsage(tenant string, bytes, objects int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := Quota{Bytes: m.usage[tenant].Bytes + bytes, Objects: m.usage[tenant].Objects + objects}
+	if m.quotas[tenant].Exceeded(usage) {
+		return false, nil
+	}
+	m.usage[tenant] = usage
+	return true, nil
+}
+
+func (m *MemoryStore) AddTenantUsage(tenant string, bytes, objects int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage[tenant] = Quota{Bytes: m.usage[tenant].Bytes + bytes, Objects: m.usage[tenant].Objects + objects}
+	return nil
+}
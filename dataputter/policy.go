@@ -0,0 +1,136 @@
+// Policies
+//
+// The policy of a bucket grants tenants access to all of its keys or to
+// keys starting with a prefix
+//
+// 	read  : Read objects, get and list keys
+// 	write : Store objects under keys and delete keys
+// 	admin : Everything, deleting the bucket and managing its policy
+//
+// A grant with deny takes the access away instead. Denies win over allows
+// and anything which isn't allowed is denied. The owner of a bucket is
+// always an admin so it can't lock itself out. Objects stored under a key
+// can be used by their owner and by tenants the policy allows on the key.
+//
+// 	bucketPolicy/name : JSON grants of a bucket
+//
+// Policies are only evaluated when ROUTER_AUTH is set.
+package dataputter
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+	AccessAdmin = "admin"
+	// AnyTenant Tenant of grants to every tenant
+	AnyTenant = "*"
+)
+
+// Grant Access of a tenant to the keys of a bucket starting with Prefix
+type Grant struct {
+	Tenant string `json:"tenant"`
+	Prefix string `json:"prefix,omitempty"`
+	Access string `json:"access"`
+	Deny   bool   `json:"deny,omitempty"`
+}
+
+// ValidateGrants Checks the tenant, prefix and access of each grant
+func ValidateGrants(grants []Grant) error {
+	for _, grant := range grants {
+		if grant.Tenant != AnyTenant && !bucketNamePattern.MatchString(grant.Tenant) {
+			return fmt.Errorf("Invalid tenant '%s' of grant\n", grant.Tenant)
+		}
+		if len(grant.Prefix) > MaxBucketKeyLength {
+			return fmt.Errorf("Invalid prefix of %d bytes\n", len(grant.Prefix))
+		}
+		switch grant.Access {
+		case AccessRead, AccessWrite, AccessAdmin:
+		default:
+			return fmt.Errorf("Unknown access '%s' of grant to %s\n", grant.Access, grant.Tenant)
+		}
+	}
+	return nil
+}
+
+// matches True when the grant covers the access of tenant to key. Admin
+// grants cover every access.
+func (g Grant) matches(tenant, key, access string) bool {
+	if g.Tenant != AnyTenant && g.Tenant != tenant {
+		return false
+	}
+	if !strings.HasPrefix(key, g.Prefix) {
+		return false
+	}
+	return g.Access == access || g.Access == AccessAdmin
+}
+
+// Evaluate True when grants allow the access of tenant to key, a matching
+// deny wins over every allow
+func Evaluate(grants []Grant, tenant, key, access string) bool {
+	allowed := false
+	for _, grant := range grants {
+		if !grant.matches(tenant, key, access) {
+			continue
+		}
+		if grant.Deny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// AuthorizeBucket ErrForbidden unless the tenant owns the bucket or its
+// policy allows the access to key
+// * Has Datastore access
+func AuthorizeBucket(tenant string, bucket Bucket, key, access string) error {
+	if !authRequired || (len(bucket.Owner) > 0 && bucket.Owner == tenant) {
+		return nil
+	}
+	grants, err := GetBucketPolicy(bucket.Name)
+	if err != nil {
+		return err
+	}
+	if !Evaluate(grants, tenant, key, access) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// IsBucketVisible True when the tenant owns the bucket or is allowed
+// anything by it
+// * Has Datastore access
+func IsBucketVisible(tenant string, bucket Bucket) (bool, error) {
+	if !authRequired || (len(bucket.Owner) > 0 && bucket.Owner == tenant) {
+		return true, nil
+	}
+	grants, err := GetBucketPolicy(bucket.Name)
+	if err != nil {
+		return false, err
+	}
+	for _, grant := range grants {
+		if !grant.Deny && (grant.Tenant == AnyTenant || grant.Tenant == tenant) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PutBucketPolicy Replaces the grants of a bucket
+// * Has Datastore access
+func PutBucketPolicy(bucketName string, grants []Grant) error {
+	if err := ValidateGrants(grants); err != nil {
+		return err
+	}
+	return metadataStore.PutBucketPolicy(bucketName, grants)
+}
+
+// GetBucketPolicy The grants of a bucket, empty when it has none
+// * Has Datastore access
+func GetBucketPolicy(bucketName string) ([]Grant, error) {
+	return metadataStore.GetBucketPolicy(bucketName)
+}
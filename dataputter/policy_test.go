@@ -0,0 +1,108 @@
+package dataputter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	grants := []Grant{
+		{Tenant: "tenant-b", Prefix: "shared/", Access: AccessRead},
+		{Tenant: "tenant-b", Prefix: "shared/private/", Access: AccessRead, Deny: true},
+		{Tenant: "tenant-c", Access: AccessAdmin},
+		{Tenant: "tenant-c", Prefix: "locked/", Access: AccessWrite, Deny: true},
+		{Tenant: AnyTenant, Prefix: "public/", Access: AccessRead},
+		{Tenant: "tenant-d", Prefix: "public/", Access: AccessAdmin, Deny: true},
+	}
+	cases := []struct {
+		tenant, key, access string
+		allowed             bool
+	}{
+		{"tenant-b", "shared/a", AccessRead, true},
+		{"tenant-b", "shared/a", AccessWrite, false},
+		{"tenant-b", "shared/private/a", AccessRead, false},
+		{"tenant-b", "other/a", AccessRead, false},
+		// Admin allows every access, a deny of one access keeps the others
+		{"tenant-c", "any/a", AccessWrite, true},
+		{"tenant-c", "locked/a", AccessWrite, false},
+		{"tenant-c", "locked/a", AccessRead, true},
+		// Grants to every tenant, a deny of admin takes away every access
+		{"tenant-e", "public/a", AccessRead, true},
+		{"tenant-d", "public/a", AccessRead, false},
+		{"tenant-e", "", AccessAdmin, false},
+	}
+	for _, c := range cases {
+		if allowed := Evaluate(grants, c.tenant, c.key, c.access); allowed != c.allowed {
+			t.Errorf("Expected %s %s of %s to be allowed=%v, got %v\n", c.tenant, c.access, c.key, c.allowed, allowed)
+		}
+	}
+	if Evaluate([]Grant{}, "tenant-b", "a", AccessRead) {
+		t.Errorf("Expected an empty policy to deny\n")
+	}
+}
+
+func TestValidateGrants(t *testing.T) {
+	if err := ValidateGrants([]Grant{{Tenant: AnyTenant, Access: AccessRead}}); err != nil {
+		t.Errorf("Expected a valid grant, got %v\n", err)
+	}
+	if err := ValidateGrants([]Grant{{Tenant: "tenant-b", Access: "delete"}}); err == nil {
+		t.Errorf("Expected an unknown access to be refused\n")
+	}
+	if err := ValidateGrants([]Grant{{Tenant: "Tenant B", Access: AccessRead}}); err == nil {
+		t.Errorf("Expected an invalid tenant to be refused\n")
+	}
+}
+
+func TestBucketPolicies(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+
+	s := &routerServer{}
+	asA := context.WithValue(context.Background(), tenantKey{}, "tenant-a")
+	asB := context.WithValue(context.Background(), tenantKey{}, "tenant-b")
+	s.CreateBucket(asA, &CreateBucketRequest{Name: "policy-photos"})
+	for _, key := range []string{"shared/1", "private/1"} {
+		objectID := "POLICY_" + key
+		CreateObject(objectID, "")
+		SetObjectOwner(objectID, "tenant-a")
+		PutObjectKey("policy-photos", key, objectID)
+	}
+
+	if err := AuthorizeObject("tenant-b", "POLICY_shared/1", AccessRead); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden without a policy, got %v\n", err)
+	}
+	policy := &BucketPolicyRequest{
+		Bucket: "policy-photos",
+		Grants: []*PolicyGrant{{Tenant: "tenant-b", Prefix: "shared/", Access: AccessRead}},
+	}
+	if response, _ := s.PutBucketPolicy(asB, policy); response.Status != NodeNotAuthorized {
+		t.Errorf("Expected NotAuthorized putting the policy of another tenant's bucket, got %d\n", response.Status)
+	}
+	if response, _ := s.PutBucketPolicy(asA, policy); response.Status != NodeSuccess {
+		t.Fatalf("Expected the owner to put the policy, got %d\n", response.Status)
+	}
+
+	if err := AuthorizeObject("tenant-b", "POLICY_shared/1", AccessRead); err != nil {
+		t.Errorf("Expected a granted read, got %v\n", err)
+	}
+	if err := AuthorizeObject("tenant-b", "POLICY_shared/1", AccessWrite); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden deleting a read-only object, got %v\n", err)
+	}
+	if err := AuthorizeObject("tenant-b", "POLICY_private/1", AccessRead); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden outside of the prefix, got %v\n", err)
+	}
+	if buckets, _ := s.ListBuckets(asB, &ListBucketsRequest{}); len(buckets.Buckets) != 1 {
+		t.Errorf("Expected the granted bucket to be listed, got %v\n", buckets.Buckets)
+	}
+	listed, _ := s.ListBucketKeys(asB, &ListBucketKeysRequest{Bucket: "policy-photos", Limit: 10})
+	if listed.Status != NodeSuccess || len(listed.Keys) != 1 || listed.Keys[0].Key != "shared/1" {
+		t.Errorf("Expected only shared/1 to be listed, got %v\n", listed)
+	}
+	if response, _ := s.DeleteBucketKey(asB, &BucketKeyRequest{Bucket: "policy-photos", Key: "shared/1"}); response.Status != NodeNotAuthorized {
+		t.Errorf("Expected NotAuthorized deleting a read-only key, got %d\n", response.Status)
+	}
+	if response, _ := s.GetBucketPolicy(asA, &BucketPolicyRequest{Bucket: "policy-photos"}); len(response.Grants) != 1 {
+		t.Errorf("Expected the grant of tenant-b, got %v\n", response.Grants)
+	}
+}
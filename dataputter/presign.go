@@ -0,0 +1,402 @@
+// Pre-signed URLs
+//
+// Browsers download and upload objects over HTTP without holding an API key
+// by using tokens minted by the Router. A token names its operation, the
+// object or bucket key it's for, the tenant which minted it and when it
+// expires, signed with HMAC-SHA256
+//
+// 	base64url(JSON claims).base64url(HMAC-SHA256(signing key, JSON claims))
+//
+// 	GET /objects/ObjectID?token=TOKEN : Download an object
+// 	PUT /objects?token=TOKEN          : Upload an object, under the bucket key the token names
+//
+// Signing keys are pre-shared like the authenticity token. PRESIGN_KEYS
+// lists id=secret pairs separated by commas, the first signs new tokens and
+// all of them verify. Keys are rotated by putting a new one first and
+// dropping the old one once its tokens have expired. Without PRESIGN_KEYS
+// no tokens are minted or accepted and the HTTP endpoint isn't served.
+//
+// The tenant of a token must still be allowed the operation when the token
+// is used. The HTTP endpoint listens on ROUTER_HTTP_PORT, over TLS when it's
+// configured.
+package dataputter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PresignDownload = "download"
+	PresignUpload   = "upload"
+	// MaxPresignTTL Longest a pre-signed token can be valid for
+	MaxPresignTTL = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrPresignInvalid A token which isn't signed by a known key or isn't
+	// for the operation
+	ErrPresignInvalid = errors.New("Invalid pre-signed token")
+	// ErrPresignExpired A token used after it expired
+	ErrPresignExpired = errors.New("Pre-signed token expired")
+	// ErrPresignNotConfigured Tokens are only minted with PRESIGN_KEYS
+	ErrPresignNotConfigured = errors.New("No pre-signing keys, set PRESIGN_KEYS")
+
+	// presignKeys: Keys verifying tokens, the first signs them, PRESIGN_KEYS
+	presignKeys []signingKey
+	// routerHTTPPort: Port pre-signed requests are served on, ROUTER_HTTP_PORT
+	routerHTTPPort = 5005
+)
+
+func init() {
+	if keys := os.Getenv("PRESIGN_KEYS"); len(keys) > 0 {
+		if err := UsePresignKeys(keys); err != nil {
+			log.Printf("Ignoring PRESIGN_KEYS: %v\n", err)
+		}
+	}
+	if port := os.Getenv("ROUTER_HTTP_PORT"); len(port) > 0 {
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			log.Printf("Ignoring ROUTER_HTTP_PORT=%s: %v\n", port, err)
+			return
+		}
+		routerHTTPPort = n
+	}
+}
+
+// signingKey A pre-shared key tokens name by its id
+type signingKey struct {
+	id     string
+	secret []byte
+}
+
+// PresignClaims What a pre-signed token allows
+type PresignClaims struct {
+	KeyID     string `json:"kid"`
+	Operation string `json:"op"`
+	ObjectID  string `json:"object,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	// Expires: Unix seconds
+	Expires int64 `json:"exp"`
+}
+
+// UsePresignKeys Replaces the signing keys with comma separated id=secret
+// pairs, the first signs new tokens
+func UsePresignKeys(keys string) error {
+	parsed := []signingKey{}
+	for _, pair := range strings.Split(keys, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) < 16 {
+			return fmt.Errorf("Signing keys are id=secret with a secret of at least 16 bytes\n")
+		}
+		// The authenticity token is public, anyone could sign with it
+		if parts[1] == DEFAULT_AUTHENTICITY_TOKEN {
+			return fmt.Errorf("Signing key %s can't be DEFAULT_AUTHENTICITY_TOKEN\n", parts[0])
+		}
+		parsed = append(parsed, signingKey{id: parts[0], secret: []byte(parts[1])})
+	}
+	presignKeys = parsed
+	return nil
+}
+
+// sign HMAC-SHA256 of the claims with a key
+func (k signingKey) sign(claims []byte) []byte {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(claims)
+	return mac.Sum(nil)
+}
+
+// Presign Signs claims valid for ttl with the first signing key
+func Presign(claims PresignClaims, ttl time.Duration) (string, error) {
+	if claims.Operation != PresignDownload && claims.Operation != PresignUpload {
+		return "", fmt.Errorf("Unknown operation '%s'\n", claims.Operation)
+	}
+	if ttl <= 0 || ttl > MaxPresignTTL {
+		return "", fmt.Errorf("Tokens are valid for up to %s, not %s\n", MaxPresignTTL, ttl)
+	}
+	if len(presignKeys) == 0 {
+		return "", ErrPresignNotConfigured
+	}
+	key := presignKeys[0]
+	claims.KeyID = key.id
+	claims.Expires = time.Now().Add(ttl).Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(key.sign(payload)), nil
+}
+
+// VerifyPresigned The claims of a token signed by a known key for the
+// operation, ErrPresignExpired once it has expired
+func VerifyPresigned(token, operation string) (PresignClaims, error) {
+	claims := PresignClaims{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return claims, ErrPresignInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrPresignInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrPresignInvalid
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrPresignInvalid
+	}
+
+	verified := false
+	for _, key := range presignKeys {
+		if key.id == claims.KeyID && hmac.Equal(signature, key.sign(payload)) {
+			verified = true
+			break
+		}
+	}
+	if !verified || claims.Operation != operation {
+		return claims, ErrPresignInvalid
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return claims, ErrPresignExpired
+	}
+	return claims, nil
+}
+
+// PresignObjectDownload A token downloading an object the tenant can read
+// * Has Datastore access
+func PresignObjectDownload(tenant, objectID string, ttl time.Duration) (string, error) {
+	if err := AuthorizeObject(tenant, objectID, AccessRead); err != nil {
+		return "", err
+	}
+	return Presign(PresignClaims{Operation: PresignDownload, ObjectID: objectID, Tenant: tenant}, ttl)
+}
+
+// PresignObjectUpload A token uploading an object owned by the tenant, under
+// a key of a bucket when bucketName isn't empty
+// * Has Datastore access
+func PresignObjectUpload(tenant, bucketName, key string, ttl time.Duration) (string, error) {
+	if len(bucketName) > 0 {
+		if err := authorizeUpload(tenant, bucketName, key); err != nil {
+			return "", err
+		}
+	}
+	return Presign(PresignClaims{Operation: PresignUpload, Bucket: bucketName, Key: key, Tenant: tenant}, ttl)
+}
+
+// authorizeUpload ErrForbidden unless the tenant can write the key of a bucket
+// * Has Datastore access
+func authorizeUpload(tenant, bucketName, key string) error {
+	if err := validateBucketKey(key); err != nil {
+		return err
+	}
+	bucket, err := GetBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	return AuthorizeBucket(tenant, bucket, key, AccessWrite)
+}
+
+// serveRouterHTTP Serves pre-signed downloads and uploads on port
+func serveRouterHTTP(port int, config RouterConfig) {
+	if len(presignKeys) == 0 {
+		log.Printf("Not serving pre-signed requests on %d: %v\n", port, ErrPresignNotConfigured)
+		return
+	}
+	s, err := Listen(port)
+	if err != nil {
+		log.Printf("Unable to listen for pre-signed requests on %d: %v\n", port, err)
+		return
+	}
+	log.Printf("Router HTTP running on port %d\n", port)
+	if err := http.Serve(s, presignHandler(config)); err != nil {
+		log.Printf("Router HTTP stopped: %v\n", err)
+	}
+}
+
+// presignHandler Routes pre-signed requests
+func presignHandler(config RouterConfig) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/objects/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Objects are downloaded with GET", http.StatusMethodNotAllowed)
+			return
+		}
+		servePresignedDownload(w, r, strings.TrimPrefix(r.URL.Path, "/objects/"))
+	})
+	mux.HandleFunc("/objects", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Objects are uploaded with PUT", http.StatusMethodNotAllowed)
+			return
+		}
+		servePresignedUpload(w, r, config)
+	})
+	return mux
+}
+
+// presignError Replies to a request with a token which can't be used
+func presignError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrPresignExpired:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case ErrPresignInvalid, ErrForbidden:
+		http.Error(w, ErrPresignInvalid.Error(), http.StatusForbidden)
+	case ErrBucketNotExist:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrRateLimited:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case ErrOverQuota:
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case ErrRouterBusy:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		log.Printf("Unable to serve pre-signed request: %v\n", err)
+		http.Error(w, "Failed", http.StatusInternalServerError)
+	}
+}
+
+// servePresignedDownload Streams the bytes of a saved object ticket by
+// ticket from its WriteNodes
+// * Has Datastore access
+func servePresignedDownload(w http.ResponseWriter, r *http.Request, objectID string) {
+	audit := AuditEntry{Operation: AuditRead, ObjectID: objectID}
+	claims, err := VerifyPresigned(r.URL.Query().Get("token"), PresignDownload)
+	audit.Tenant = claims.Tenant
+	if err == nil && claims.ObjectID != objectID {
+		err = ErrPresignInvalid
+	}
+	if err == nil {
+		err = AuthorizeObject(claims.Tenant, objectID, AccessRead)
+	}
+	if err == nil {
+		err = AllowRequest(claims.Tenant)
+	}
+	if err != nil {
+		Audit(audit, err)
+		presignError(w, err)
+		return
+	}
+
+	object, err := GetObjectMetadata(objectID)
+	if err == nil && object.Status != ObjectStatus[ObjectSaved] {
+		err = ErrObjectNotExist
+	}
+	var offsets map[string]int64
+	if err == nil {
+		offsets, err = GetObjectTicketOffsets(objectID)
+	}
+	if err == ErrObjectNotExist {
+		Audit(audit, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		Audit(audit, err)
+		presignError(w, err)
+		return
+	}
+
+	if len(object.ContentType) > 0 {
+		w.Header().Set("Content-Type", object.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	for _, ticketID := range sortedByOffset(offsets) {
+		ticket, err := GetTicketMetadata(ticketID)
+		if err != nil {
+			log.Printf("Stopped download of %s at ticket %s: %v\n", objectID, ticketID, err)
+			Audit(audit, err)
+			return
+		}
+		response, err := ReadTicketFromNode(ticket)
+		if err == nil && response.Status != NodeSuccess {
+			err = fmt.Errorf("Node status %d\n", response.Status)
+		}
+		if err != nil {
+			log.Printf("Stopped download of %s at ticket %s: %v\n", objectID, ticketID, err)
+			Audit(audit, err)
+			return
+		}
+		if _, err := w.Write(response.Data); err != nil {
+			Audit(audit, err)
+			return
+		}
+	}
+	Audit(audit, nil)
+}
+
+// httpUpload The body of an upload request, collecting the reply of
+// createObject
+type httpUpload struct {
+	io.Reader
+	reply bytes.Buffer
+}
+
+func (u *httpUpload) Write(b []byte) (int, error) {
+	return u.reply.Write(b)
+}
+
+func (u *httpUpload) Close() error {
+	return nil
+}
+
+// servePresignedUpload Stores the body of a request as a new object of the
+// token's tenant and replies with its ObjectID
+// * Has Datastore access
+func servePresignedUpload(w http.ResponseWriter, r *http.Request, config RouterConfig) {
+	claims, err := VerifyPresigned(r.URL.Query().Get("token"), PresignUpload)
+	if err == nil {
+		err = AllowRequest(claims.Tenant)
+	}
+	var bucket Bucket
+	if err == nil && len(claims.Bucket) > 0 {
+		err = authorizeUpload(claims.Tenant, claims.Bucket, claims.Key)
+		if err == nil {
+			bucket, err = GetBucket(claims.Bucket)
+		}
+	}
+	if err != nil {
+		presignError(w, err)
+		return
+	}
+	if r.ContentLength < 0 {
+		http.Error(w, "Uploads need a Content-Length", http.StatusLengthRequired)
+		return
+	}
+	// Uploads share the connection slots and queue of the TCP Router
+	release, err := AdmitConnection()
+	if err != nil {
+		presignError(w, err)
+		return
+	}
+	defer release()
+
+	upload := &httpUpload{Reader: r.Body}
+	err = createObject(upload, config, claims.Tenant, bucket, claims.Key, r.ContentLength)
+	if err == ErrRateLimited || err == ErrOverQuota {
+		presignError(w, err)
+		return
+	}
+	if err != nil || upload.reply.Len() != IDLength {
+		log.Printf("Failed pre-signed upload: %v\n", err)
+		http.Error(w, "Failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(upload.reply.Bytes())
+}
@@ -0,0 +1,179 @@
+package dataputter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usePresignKeys Signs with keys until the returned func is called
+func usePresignKeys(t *testing.T, keys string) func() {
+	previous := presignKeys
+	if err := UsePresignKeys(keys); err != nil {
+		t.Fatalf("Expected valid signing keys, got %v\n", err)
+	}
+	return func() { presignKeys = previous }
+}
+
+func TestPresign(t *testing.T) {
+	defer usePresignKeys(t, "k1=0123456789abcdef0123")()
+
+	token, err := Presign(PresignClaims{Operation: PresignDownload, ObjectID: "PRESIGN_OBJECT"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected a token, got %v\n", err)
+	}
+	claims, err := VerifyPresigned(token, PresignDownload)
+	if err != nil || claims.ObjectID != "PRESIGN_OBJECT" || claims.KeyID != "k1" {
+		t.Errorf("Expected the claims of PRESIGN_OBJECT, got %+v %v\n", claims, err)
+	}
+	if _, err := VerifyPresigned(token, PresignUpload); err != ErrPresignInvalid {
+		t.Errorf("Expected a download token to be refused for uploads, got %v\n", err)
+	}
+
+	// Changing the claims breaks the signature
+	tampered, _ := json.Marshal(PresignClaims{KeyID: "k1", Operation: PresignDownload, ObjectID: "OTHER_OBJECT", Expires: claims.Expires})
+	signature := token[len(base64.RawURLEncoding.EncodeToString(mustMarshal(claims))):]
+	if _, err := VerifyPresigned(base64.RawURLEncoding.EncodeToString(tampered)+signature, PresignDownload); err != ErrPresignInvalid {
+		t.Errorf("Expected a tampered token to be refused, got %v\n", err)
+	}
+
+	expired := mustMarshal(PresignClaims{KeyID: "k1", Operation: PresignDownload, Expires: time.Now().Add(-time.Second).Unix()})
+	expiredToken := base64.RawURLEncoding.EncodeToString(expired) + "." +
+		base64.RawURLEncoding.EncodeToString(presignKeys[0].sign(expired))
+	if _, err := VerifyPresigned(expiredToken, PresignDownload); err != ErrPresignExpired {
+		t.Errorf("Expected ErrPresignExpired, got %v\n", err)
+	}
+
+	if _, err := Presign(PresignClaims{Operation: PresignDownload}, MaxPresignTTL+time.Second); err == nil {
+		t.Errorf("Expected a token valid for too long to be refused\n")
+	}
+}
+
+func mustMarshal(claims PresignClaims) []byte {
+	b, _ := json.Marshal(claims)
+	return b
+}
+
+func TestPresignKeyRotation(t *testing.T) {
+	defer usePresignKeys(t, "old=0123456789abcdef0123")()
+	token, _ := Presign(PresignClaims{Operation: PresignUpload}, time.Minute)
+
+	usePresignKeys(t, "new=fedcba9876543210fedc,old=0123456789abcdef0123")
+	if _, err := VerifyPresigned(token, PresignUpload); err != nil {
+		t.Errorf("Expected a token of the old key to verify while it's kept, got %v\n", err)
+	}
+	if next, _ := Presign(PresignClaims{Operation: PresignUpload}, time.Minute); next == token {
+		t.Errorf("Expected new tokens to be signed with the new key\n")
+	}
+
+	usePresignKeys(t, "new=fedcba9876543210fedc")
+	if _, err := VerifyPresigned(token, PresignUpload); err != ErrPresignInvalid {
+		t.Errorf("Expected a token of a dropped key to be refused, got %v\n", err)
+	}
+	if err := UsePresignKeys("short=abc"); err == nil {
+		t.Errorf("Expected a short secret to be refused\n")
+	}
+}
+
+func TestPresignedDownload(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer usePresignKeys(t, "k1=0123456789abcdef0123")()
+
+	objectID := FormatID(47)
+	CreateObject(objectID, "")
+	SetObjectStatus(objectID, ObjectStatus[ObjectWriting])
+	SetObjectStatus(objectID, ObjectStatus[ObjectSaved])
+	SetObjectByteSize(objectID, 0)
+
+	server := httptest.NewServer(presignHandler(RouterConfig{}))
+	defer server.Close()
+	download := func(path string) int {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Expected a response, got %v\n", err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	token, err := PresignObjectDownload("", objectID, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected a download token, got %v\n", err)
+	}
+	if status := download("/objects/" + objectID + "?token=" + token); status != http.StatusOK {
+		t.Errorf("Expected 200, got %d\n", status)
+	}
+	if status := download("/objects/" + FormatID(48) + "?token=" + token); status != http.StatusForbidden {
+		t.Errorf("Expected a token of another object to be refused, got %d\n", status)
+	}
+	if status := download("/objects/" + objectID); status != http.StatusForbidden {
+		t.Errorf("Expected a request without a token to be refused, got %d\n", status)
+	}
+
+	// The tenant of a token must still be allowed to read the object
+	defer useAuth()()
+	SetObjectOwner(objectID, "tenant-a")
+	token, _ = PresignObjectDownload("tenant-a", objectID, time.Minute)
+	SetObjectOwner(objectID, "tenant-b")
+	if status := download("/objects/" + objectID + "?token=" + token); status != http.StatusForbidden {
+		t.Errorf("Expected a token of a tenant which lost access to be refused, got %d\n", status)
+	}
+}
+
+func TestPresignedUploadAdmission(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer usePresignKeys(t, "k1=0123456789abcdef0123")()
+	defer UseAdmission(admission)
+	UseAdmission(Admission{Connections: 1, QueueTimeout: 10 * time.Millisecond})
+
+	server := httptest.NewServer(presignHandler(RouterConfig{}))
+	defer server.Close()
+
+	// A TCP upload holds the only slot
+	release, err := AdmitConnection()
+	if err != nil {
+		t.Fatalf("Expected a connection slot, got %v\n", err)
+	}
+	defer release()
+
+	token, _ := PresignObjectUpload("", "", "", time.Minute)
+	request, _ := http.NewRequest(http.MethodPut, server.URL+"/objects?token="+token, strings.NewReader("data"))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Expected a response, got %v\n", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected an upload without a free slot to get 503, got %d\n", response.StatusCode)
+	}
+}
+
+func TestPresignRequiresKeys(t *testing.T) {
+	previous := presignKeys
+	defer func() { presignKeys = previous }()
+	presignKeys = nil
+
+	if _, err := Presign(PresignClaims{Operation: PresignDownload}, time.Minute); err != ErrPresignNotConfigured {
+		t.Errorf("Expected no tokens without signing keys, got %v\n", err)
+	}
+
+	// Anyone can sign with the public authenticity token
+	forged := mustMarshal(PresignClaims{KeyID: "default", Operation: PresignUpload, Tenant: "tenant-a", Expires: time.Now().Add(time.Minute).Unix()})
+	publicKey := signingKey{id: "default", secret: []byte(DEFAULT_AUTHENTICITY_TOKEN)}
+	token := base64.RawURLEncoding.EncodeToString(forged) + "." + base64.RawURLEncoding.EncodeToString(publicKey.sign(forged))
+	if _, err := VerifyPresigned(token, PresignUpload); err != ErrPresignInvalid {
+		t.Errorf("Expected a token signed with the authenticity token to be refused, got %v\n", err)
+	}
+	if err := UsePresignKeys("default=" + DEFAULT_AUTHENTICITY_TOKEN); err == nil {
+		t.Errorf("Expected the authenticity token to be refused as a signing key\n")
+	}
+	if _, err := VerifyPresigned(token, PresignUpload); err != ErrPresignInvalid {
+		t.Errorf("Expected a token signed with the authenticity token to be refused, got %v\n", err)
+	}
+}
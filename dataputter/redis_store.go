@@ -0,0 +1,1269 @@
+// Redis Store
+//
+// A MetadataStore keeping objects and tickets in Redis. Every Router and
+// ObjectServer sharing the Redis server shares the metadata.
+//
+// Each ticket and each object is one hash whose fields are its metadata
+//
+// 	HASH /tickets/ticketID {ticket, object, node, byteStart, byteEnd, byteCount, status, ...}
+// 	HASH /objects/objectID {status, size, storedSize, dataKey}
+//
+// Keyspaces written with a string key per field are converted in place by
+// MigrateHashLayout.
+package dataputter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/mediocregopher/radix/v3"
+)
+
+var (
+	// legacyKeyPatterns: Keys of tickets and objects written a field per key
+	legacyKeyPatterns = []string{"/tickets/*/*", "/objects/*/*"}
+	// legacyTicketFields: Fields of tickets which were /tickets/$ticketID/$field keys
+	legacyTicketFields = []string{
+		"ticket", "object", "node", "byteStart", "byteEnd", "byteCount", "status",
+		"storedByteCount", "compression", "hash", "refCount",
+	}
+	// legacyObjectFields: Fields of objects which were /objects/$objectID/$field keys
+	legacyObjectFields = []string{
+		"status", "size", "storedSize", "dataKey",
+	}
+)
+
+// Scripts run each change to the metadata of an object or ticket as one
+// atomic unit, in a single round trip. Key paths are passed as KEYS.
+var (
+	createObjectScript = redis.NewEvalScript(2, `
+redis.call('SADD', KEYS[1], ARGV[1])
+redis.call('HSETNX', KEYS[2], 'status', ARGV[2])
+redis.call('HSETNX', KEYS[2], 'createdAt', ARGV[3])
+return 1
+`)
+
+	createTicketScript = redis.NewEvalScript(4, `
+redis.call('HSET', KEYS[1],
+	'ticket', ARGV[1], 'object', ARGV[2], 'node', ARGV[3],
+	'byteStart', ARGV[4], 'byteEnd', ARGV[5], 'byteCount', ARGV[6])
+redis.call('HSETNX', KEYS[1], 'status', ARGV[7])
+redis.call('ZADD', KEYS[2], ARGV[4], ARGV[1])
+redis.call('SADD', KEYS[3], ARGV[1])
+redis.call('SADD', KEYS[4], ARGV[3])
+return 1
+`)
+
+	storedByteCountScript = redis.NewEvalScript(2, `
+redis.call('HSET', KEYS[1], 'storedByteCount', ARGV[1], 'compression', ARGV[2])
+redis.call('HINCRBY', KEYS[2], 'storedSize', ARGV[1])
+return 1
+`)
+
+	// setStatusScript Sets ARGV[1] as the status when the current one is
+	// among ARGV[2..], returning the status it had
+	setStatusScript = redis.NewEvalScript(1, `
+local status = redis.call('HGET', KEYS[1], 'status') or ''
+for i = 2, #ARGV do
+	if ARGV[i] == status then
+		redis.call('HSET', KEYS[1], 'status', ARGV[1])
+		break
+	end
+end
+return status
+`)
+
+	shredScript = redis.NewEvalScript(2, `
+local status = redis.call('HGET', KEYS[1], 'status') or ''
+for i = 3, #ARGV do
+	if ARGV[i] == status then
+		redis.call('HDEL', KEYS[1], 'dataKey')
+		redis.call('HSET', KEYS[1], 'status', ARGV[1])
+		redis.call('SADD', KEYS[2], ARGV[2])
+		break
+	end
+end
+return status
+`)
+
+	// stampCreatedAtScript Sets createdAt of an existing object without one
+	stampCreatedAtScript = redis.NewEvalScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('HSETNX', KEYS[1], 'createdAt', ARGV[1])
+`)
+
+	// replaceDataKeyScript Sets the dataKey to ARGV[2] while it's ARGV[1]
+	replaceDataKeyScript = redis.NewEvalScript(1, `
+if redis.call('HGET', KEYS[1], 'dataKey') ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'dataKey', ARGV[2])
+return 1
+`)
+
+	indexTicketHashScript = redis.NewEvalScript(2, `
+redis.call('HSET', KEYS[1], 'hash', ARGV[1], 'refCount', '1')
+redis.call('HSETNX', KEYS[2], ARGV[1], ARGV[2])
+return 1
+`)
+
+	addTicketReferenceScript = redis.NewEvalScript(4, `
+local node = redis.call('HGET', KEYS[1], 'node') or ''
+redis.call('HINCRBY', KEYS[1], 'refCount', 1)
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+redis.call('SADD', KEYS[3], ARGV[1])
+redis.call('SADD', KEYS[4], node)
+return 1
+`)
+
+	releaseTicketReferenceScript = redis.NewEvalScript(3, `
+local remaining = redis.call('HINCRBY', KEYS[1], 'refCount', -1)
+redis.call('SREM', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+return remaining
+`)
+
+	deleteTicketScript = redis.NewEvalScript(3, `
+local hash = redis.call('HGET', KEYS[1], 'hash')
+if hash and redis.call('HGET', KEYS[2], hash) == ARGV[1] then
+	redis.call('HDEL', KEYS[2], hash)
+end
+redis.call('SREM', KEYS[3], ARGV[1])
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+	// KEYS[1] is the set of objects, KEYS[2] onward are deleted
+	// deleteObjectReferenceScript Also removes the bucket key ARGV[3] of
+	// bucket ARGV[2] while the object holds it
+	deleteObjectReferenceScript = redis.NewEvalScript(9, `
+local stored = redis.call('HMGET', KEYS[5], 'bucket', 'key')
+if ARGV[3] ~= '' and stored[1] == ARGV[2] and stored[2] == ARGV[3] and redis.call('HGET', KEYS[8], ARGV[3]) == ARGV[1] then
+	redis.call('HDEL', KEYS[8], ARGV[3])
+	redis.call('ZREM', KEYS[9], ARGV[3])
+end
+redis.call('DEL', unpack(KEYS, 2, 7))
+redis.call('SREM', KEYS[1], ARGV[1])
+return 1
+`)
+
+	// Moves the /path/$field keys of KEYS[1] into its hash
+	migrateHashScript = redis.NewEvalScript(1, `
+local moved = 0
+for _, field in ipairs(ARGV) do
+	local legacy = KEYS[1] .. '/' .. field
+	local value = redis.call('GET', legacy)
+	if value then
+		redis.call('HSET', KEYS[1], field, value)
+		redis.call('DEL', legacy)
+		moved = moved + 1
+	end
+end
+return moved
+`)
+
+	createBucketScript = redis.NewEvalScript(2, `
+if redis.call('SADD', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[2],
+	'compression', ARGV[2], 'retention', ARGV[3],
+	'createdAt', ARGV[4], 'owner', ARGV[5])
+return 1
+`)
+
+	// deleteBucketScript Returns -1 without a bucket, 0 while it has keys
+	deleteBucketScript = redis.NewEvalScript(4, `
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	return -1
+end
+if redis.call('ZCARD', KEYS[3]) > 0 then
+	return 0
+end
+redis.call('DEL', KEYS[2], KEYS[4])
+redis.call('SREM', KEYS[1], ARGV[1])
+return 1
+`)
+
+	putBucketPolicyScript = redis.NewEvalScript(2, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('NOBUCKET')
+end
+redis.call('SET', KEYS[2], ARGV[1])
+return 1
+`)
+
+	// reserveTenantUsageScript Returns 0 when the usage would go over the quota
+	reserveTenantUsageScript = redis.NewEvalScript(2, `
+local quotaBytes = tonumber(redis.call('HGET', KEYS[2], 'bytes') or '0')
+local quotaObjects = tonumber(redis.call('HGET', KEYS[2], 'objects') or '0')
+local usedBytes = tonumber(redis.call('HGET', KEYS[1], 'bytes') or '0')
+local usedObjects = tonumber(redis.call('HGET', KEYS[1], 'objects') or '0')
+if quotaBytes > 0 and usedBytes + tonumber(ARGV[1]) > quotaBytes then
+	return 0
+end
+if quotaObjects > 0 and usedObjects + tonumber(ARGV[2]) > quotaObjects then
+	return 0
+end
+redis.call('HINCRBY', KEYS[1], 'bytes', ARGV[1])
+redis.call('HINCRBY', KEYS[1], 'objects', ARGV[2])
+return 1
+`)
+
+	putBucketKeyScript = redis.NewEvalScript(3, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('NOBUCKET')
+end
+local previous = redis.call('HGET', KEYS[2], ARGV[1]) or ''
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[3], 0, ARGV[1])
+return previous
+`)
+
+	deleteBucketKeyScript = redis.NewEvalScript(2, `
+local objectID = redis.call('HGET', KEYS[1], ARGV[1]) or ''
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+return objectID
+`)
+
+	// migrateObjectStatusScript Saves objects which were complete before
+	// they could become saved
+	migrateObjectStatusScript = redis.NewEvalScript(1, `
+local status = redis.call('HGET', KEYS[1], 'status')
+if (status == ARGV[1] or status == ARGV[2]) and redis.call('HEXISTS', KEYS[1], 'size') == 1 then
+	redis.call('HSET', KEYS[1], 'status', ARGV[3])
+	return 1
+end
+return 0
+`)
+
+	// migrateTicketStatusScript Saves tickets which have no status
+	migrateTicketStatusScript = redis.NewEvalScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.call('HSETNX', KEYS[1], 'status', ARGV[1])
+end
+return 0
+`)
+)
+
+// RedisStore MetadataStore backed by a Redis server
+type RedisStore struct {
+	hostport string
+	poolSize int
+
+	mu   sync.Mutex
+	pool *redis.Pool
+}
+
+// NewRedisStore A RedisStore for the server at hostport. No connection is
+// made until the store is first used.
+func NewRedisStore(hostport string) *RedisStore {
+	return &RedisStore{
+		hostport: hostport,
+		poolSize: 10,
+	}
+}
+
+// connect Creates the connection pool on first use, retrying on later
+// uses when Redis couldn't be reached
+func (r *RedisStore) connect() (*redis.Pool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pool != nil {
+		return r.pool, nil
+	}
+	pool, err := redis.NewPool("tcp", r.hostport, r.poolSize)
+	if err != nil {
+		log.Printf("Unable to establish Redis connection: %v\n", err)
+		return nil, err
+	}
+	fmt.Println("Created Redis connection")
+	r.pool = pool
+	return pool, nil
+}
+
+func (r *RedisStore) do(action redis.Action) error {
+	pool, err := r.connect()
+	if err != nil {
+		return err
+	}
+	return pool.Do(action)
+}
+
+func ticketKey(ticketID string) string {
+	return "/tickets/" + ticketID
+}
+
+func objectKey(objectID string) string {
+	return "/objects/" + objectID
+}
+
+func (r *RedisStore) setField(key, field, value string) error {
+	return r.do(
+		redis.Cmd(nil, "HSET", key, field, value),
+	)
+}
+
+func (r *RedisStore) getField(key, field string) (string, error) {
+	var value string
+	err := r.do(
+		redis.Cmd(&value, "HGET", key, field),
+	)
+	return value, err
+}
+
+func (r *RedisStore) deleteKeyPath(keyPath string) error {
+	return r.do(redis.Cmd(nil, "DEL", keyPath))
+}
+
+// IncrementCounter Adds to a counter, creating it at 0 first
+func (r *RedisStore) IncrementCounter(keyPath string, by int64) (int64, error) {
+	var value int64
+
+	err := r.do(
+		redis.Cmd(&value, "INCRBY", keyPath, strconv.FormatInt(by, 10)),
+	)
+	return value, err
+}
+
+// GetCounter Value of a counter, 0 when it doesn't exist
+func (r *RedisStore) GetCounter(keyPath string) (int64, error) {
+	var value int64
+	err := r.do(
+		redis.Cmd(&value, "GET", keyPath),
+	)
+	return value, err
+}
+
+// DeleteCounter Removes a counter
+func (r *RedisStore) DeleteCounter(keyPath string) error {
+	return r.deleteKeyPath(keyPath)
+}
+
+func (r *RedisStore) GetTicketObject(ticketID string) (string, error) {
+	return r.getField(ticketKey(ticketID), "object")
+}
+
+// Create a new object reference
+// Adds to set: objects { objectID }
+// Sets object status: /objects/$objectID status NEW, unless it has one
+func (r *RedisStore) CreateObject(objectID, ticketID string) error {
+	return r.do(createObjectScript.Cmd(nil,
+		"objects", objectKey(objectID),
+		objectID, ObjectStatus[ObjectNew], strconv.FormatInt(time.Now().Unix(), 10),
+	))
+}
+
+// Every ObjectID in the set of objects
+func (r *RedisStore) GetObjects() ([]string, error) {
+	objects := []string{}
+
+	err := r.do(
+		redis.Cmd(&objects, "SMEMBERS", "objects"),
+	)
+	return objects, err
+}
+
+// A page of count ObjectIDs from the set of objects. SSCAN keeps each call
+// short so listing doesn't block Redis. SSCAN can answer with more members
+// than asked for, so the cursor is the SSCAN cursor of a batch and how many
+// of its members were already returned.
+func (r *RedisStore) ScanObjects(cursor string, count int) ([]string, string, error) {
+	if count < 1 {
+		count = 1
+	}
+	scanCursor, skip := "0", 0
+	if parts := strings.SplitN(cursor, ":", 2); len(parts) == 2 {
+		scanCursor = parts[0]
+		skip, _ = strconv.Atoi(parts[1])
+	}
+
+	objects := []string{}
+	for {
+		members, next, err := r.scanObjectSet(scanCursor, count)
+		if err != nil {
+			return objects, cursor, err
+		}
+		if skip > len(members) {
+			skip = len(members)
+		}
+		members = members[skip:]
+		if remaining := count - len(objects); len(members) > remaining {
+			objects = append(objects, members[:remaining]...)
+			return objects, fmt.Sprintf("%s:%d", scanCursor, skip+remaining), nil
+		}
+		objects = append(objects, members...)
+		if next == "0" {
+			return objects, "", nil
+		}
+		scanCursor, skip = next, 0
+		if len(objects) == count {
+			return objects, next + ":0", nil
+		}
+	}
+}
+
+// scanObjectSet One SSCAN of the set of objects
+func (r *RedisStore) scanObjectSet(cursor string, count int) ([]string, string, error) {
+	var reply []interface{}
+	err := r.do(redis.Cmd(&reply, "SSCAN", "objects", cursor, "COUNT", strconv.Itoa(count)))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(reply) != 2 {
+		return nil, "", fmt.Errorf("Unexpected SSCAN reply %v\n", reply)
+	}
+
+	members := []string{}
+	items, _ := reply[1].([]interface{})
+	for _, item := range items {
+		if b, ok := item.([]byte); ok {
+			members = append(members, string(b))
+		}
+	}
+	next, _ := reply[0].([]byte)
+	return members, string(next), nil
+}
+
+// GetObjectMetadata Everything known about an object with one HGETALL
+func (r *RedisStore) GetObjectMetadata(objectID string) (ObjectMetadata, error) {
+	object := ObjectMetadata{ObjectID: objectID}
+	fields := map[string]string{}
+	if err := r.do(redis.Cmd(&fields, "HGETALL", objectKey(objectID))); err != nil {
+		return object, err
+	}
+
+	object.Status = fields["status"]
+	object.ContentType = fields["contentType"]
+	object.Checksum = fields["checksum"]
+	object.Owner = fields["owner"]
+	object.Bucket = fields["bucket"]
+	object.Key = fields["key"]
+	intFields := map[string]*int64{
+		"size":       &object.Size,
+		"storedSize": &object.StoredSize,
+	}
+	for field, v := range intFields {
+		if len(fields[field]) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[field], 10, 64)
+		if err != nil {
+			log.Printf("Error parsing %s of %s: %v\n", field, objectKey(objectID), err)
+			return object, err
+		}
+		*v = n
+	}
+	if createdAt, err := strconv.ParseInt(fields["createdAt"], 10, 64); err == nil {
+		object.CreatedAt = time.Unix(createdAt, 0)
+	}
+	return object, nil
+}
+
+// Set the content type an object was uploaded with
+func (r *RedisStore) SetObjectContentType(objectID, contentType string) error {
+	return r.setField(objectKey(objectID), "contentType", contentType)
+}
+
+// Set the SHA-256 of the bytes of an object
+func (r *RedisStore) SetObjectChecksum(objectID, checksum string) error {
+	return r.setField(objectKey(objectID), "checksum", checksum)
+}
+
+// Set the tenant which created an object
+func (r *RedisStore) SetObjectOwner(objectID, tenant string) error {
+	return r.setField(objectKey(objectID), "owner", tenant)
+}
+
+// Set the bucket and key an object is stored under
+func (r *RedisStore) SetObjectKey(objectID, bucket, key string) error {
+	return r.do(redis.Cmd(nil, "HSET", objectKey(objectID), "bucket", bucket, "key", key))
+}
+
+// Set the wrapped data key of an object
+func (r *RedisStore) SetObjectDataKey(objectID, wrappedKey string) error {
+	return r.setField(objectKey(objectID), "dataKey", wrappedKey)
+}
+
+// Replace the wrapped data key of an object in one script, so a key
+// shredded meanwhile is never written back
+func (r *RedisStore) ReplaceObjectDataKey(objectID, previous, wrappedKey string) (bool, error) {
+	var replaced int
+	err := r.do(replaceDataKeyScript.Cmd(&replaced, objectKey(objectID), previous, wrappedKey))
+	return replaced == 1, err
+}
+
+// Get the wrapped data key of an object, empty when it isn't encrypted
+func (r *RedisStore) GetObjectDataKey(objectID string) (string, error) {
+	return r.getField(objectKey(objectID), "dataKey")
+}
+
+// Destroy the wrapped data key of an object and queue it for byte cleanup
+func (r *RedisStore) ShredObjectDataKey(objectID string) error {
+	shredded := ObjectStatus[ObjectShredded]
+	args := append([]string{objectKey(objectID), "shreddedObjects", shredded, objectID},
+		allowedFrom(ObjectTransitions, shredded)...)
+
+	var status string
+	if err := r.do(shredScript.Cmd(&status, args...)); err != nil {
+		return err
+	}
+	if !CanTransition(ObjectTransitions, status, shredded) {
+		return transitionError("object", objectID, status, shredded)
+	}
+	return nil
+}
+
+// Objects whose data key has been shredded but whose bytes may remain on nodes
+func (r *RedisStore) GetShreddedObjects() ([]string, error) {
+	objects := []string{}
+
+	err := r.do(
+		redis.Cmd(&objects, "SMEMBERS", "shreddedObjects"),
+	)
+	return objects, err
+}
+
+// Remove an object from the shredded objects once its bytes are deleted
+func (r *RedisStore) RemoveShreddedObject(objectID string) error {
+	return r.do(redis.Cmd(nil, "SREM", "shreddedObjects", objectID))
+}
+
+// Set the size of an object
+func (r *RedisStore) SetObjectByteSize(objectID string, sizeInBytes int64) error {
+	return r.setField(objectKey(objectID), "size", fmt.Sprintf("%d", sizeInBytes))
+}
+
+// Sets a new Object status
+func (r *RedisStore) SetObjectStatus(objectID, status string) error {
+	return r.setStatus(objectKey(objectID), "object", objectID, status, ObjectTransitions)
+}
+
+// Gets the status of an Object
+func (r *RedisStore) GetObjectStatus(objectID string) (string, error) {
+	return r.getField(objectKey(objectID), "status")
+}
+
+// Sets a new ticket status
+func (r *RedisStore) SetTicketStatus(ticketID, status string) error {
+	log.Printf("SetTicketStatus of %s to %s\n", ticketID, status)
+	return r.setStatus(ticketKey(ticketID), "ticket", ticketID, status, TicketTransitions)
+}
+
+// setStatus Compares and sets the status field of a hash in one step so
+// concurrent Routers can't make a transition the other didn't expect
+func (r *RedisStore) setStatus(key, kind, id, status string, transitions map[string][]string) error {
+	var previous string
+	args := append([]string{key, status}, allowedFrom(transitions, status)...)
+	if err := r.do(setStatusScript.Cmd(&previous, args...)); err != nil {
+		return err
+	}
+	if !CanTransition(transitions, previous, status) {
+		return transitionError(kind, id, previous, status)
+	}
+	return nil
+}
+
+// Get a list of tickets for in the inclusive range from offset to minByt + 512KB
+func (r *RedisStore) GetTicketsFromOffset(objectID string, offset int64) (tickets []string, err error) {
+	log.Printf("GetTicketFromOffset %d %s\n", offset, objectID)
+	err = r.do(
+		redis.Cmd(
+			&tickets,
+			"ZRANGEBYSCORE",
+			"objectBytes/"+objectID,
+			strconv.FormatInt(offset, 10),
+			strconv.FormatInt(offset+int64(1024*512), 10),
+		),
+	)
+
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// Create a new ticket in the datastore
+// Sets /tickets/$ticketID fields ticket, object, node, byteStart, byteEnd and byteCount
+// Adds byteStart position to set of objectBytes: objectBytes/$objectID { byteStart }
+// Adds ticket to set of Object tickets: objectTickets/$objectID { ticketID }
+// Adds node to set of nodes containing tickets: objectNodes/$objectID { nodeID }
+func (r *RedisStore) CreateTicket(ticketID, objectID, nodeID string, byteStart, byteEnd, byteCount int64) error {
+	log.Printf("[%d:%d] CreateTicket %s for object %s\n", byteStart, byteEnd, ticketID, objectID)
+
+	return r.do(createTicketScript.Cmd(nil,
+		ticketKey(ticketID),
+		// Keep tickets sorted for an object by storing the start byte as the score of a ticket
+		"objectBytes/"+objectID,
+		// Track which tickets an object has
+		"objectTickets/"+objectID,
+		// Track which nodes have tickets for an object
+		"objectNodes/"+objectID,
+		ticketID,
+		objectID,
+		nodeID,
+		strconv.FormatInt(byteStart, 10),
+		strconv.FormatInt(byteEnd, 10),
+		strconv.FormatInt(byteCount, 10),
+		TicketStatus[TicketNew],
+	))
+}
+
+// Record how many bytes a node stored for a ticket and the codec it used.
+// The logical byteCount of the ticket is left as it is so object sizes
+// are unaffected by compression.
+func (r *RedisStore) SetTicketStoredByteCount(objectID, ticketID string, storedByteCount int64, compression string) error {
+	return r.do(storedByteCountScript.Cmd(nil,
+		ticketKey(ticketID), objectKey(objectID),
+		strconv.FormatInt(storedByteCount, 10), compression,
+	))
+}
+
+// Index a ticket by the hash of its content so duplicates can reference it.
+// A ticket starts with the one reference of the object which wrote it.
+func (r *RedisStore) IndexTicketHash(ticketID, contentHash string) error {
+	// The first ticket stored with a hash keeps it
+	return r.do(indexTicketHashScript.Cmd(nil,
+		ticketKey(ticketID), "ticketHashes",
+		contentHash, ticketID,
+	))
+}
+
+// Get the TicketID indexed by a content hash, empty when there is none
+func (r *RedisStore) GetTicketByHash(contentHash string) (string, error) {
+	var ticketID string
+	err := r.do(redis.Cmd(&ticketID, "HGET", "ticketHashes", contentHash))
+	return ticketID, err
+}
+
+// True when an object already has a ticket in its set of tickets
+func (r *RedisStore) IsObjectTicket(objectID, ticketID string) (bool, error) {
+	var isMember bool
+	err := r.do(redis.Cmd(&isMember, "SISMEMBER", "objectTickets/"+objectID, ticketID))
+	return isMember, err
+}
+
+// Add a reference from an object to a ticket which is already stored
+// Adds byteStart position to set of objectBytes: objectBytes/$objectID { byteStart }
+// Adds ticket to set of Object tickets: objectTickets/$objectID { ticketID }
+// Adds node to set of nodes containing tickets: objectNodes/$objectID { nodeID }
+func (r *RedisStore) AddTicketReference(objectID, ticketID string, byteStart int64) error {
+	return r.do(addTicketReferenceScript.Cmd(nil,
+		ticketKey(ticketID),
+		"objectBytes/"+objectID,
+		"objectTickets/"+objectID,
+		"objectNodes/"+objectID,
+		ticketID, strconv.FormatInt(byteStart, 10),
+	))
+}
+
+// Number of objects referencing a ticket, 0 when it was never indexed
+func (r *RedisStore) GetTicketReferenceCount(ticketID string) (int64, error) {
+	var references int64
+	err := r.do(redis.Cmd(&references, "HGET", ticketKey(ticketID), "refCount"))
+	return references, err
+}
+
+// Remove the reference of an object to a ticket other objects still reference
+// Returns the number of references which remain
+func (r *RedisStore) ReleaseTicketReference(objectID, ticketID string) (int64, error) {
+	var remaining int64
+	err := r.do(releaseTicketReferenceScript.Cmd(&remaining,
+		ticketKey(ticketID),
+		"objectTickets/"+objectID,
+		"objectBytes/"+objectID,
+		ticketID,
+	))
+	return remaining, err
+}
+
+func (r *RedisStore) GetTicketStatus(ticketID string) (string, error) {
+	return r.getField(ticketKey(ticketID), "status")
+}
+
+func (r *RedisStore) GetTicketNode(ticketID string) (string, error) {
+	return r.getField(ticketKey(ticketID), "node")
+}
+
+func (r *RedisStore) GetTicketSize(ticketID string) (int64, error) {
+	v, err := r.getField(ticketKey(ticketID), "byteCount")
+
+	if err != nil {
+		return int64(0), err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func (r *RedisStore) GetObjectTickets(objectID string) ([]string, error) {
+	tickets := []string{}
+
+	err := r.do(
+		redis.Cmd(&tickets, "smembers", "objectTickets/"+objectID),
+	)
+
+	return tickets, err
+}
+
+func (r *RedisStore) GetObjectNodes(objectID string) ([]string, error) {
+	nodes := []string{}
+
+	err := r.do(
+		redis.Cmd(&nodes, "SMEMBERS", "objectNodes/"+objectID),
+	)
+	return nodes, err
+}
+
+func (r *RedisStore) GetObjectTicketOffsets(objectID string) (map[string]int64, error) {
+	offsets := map[string]int64{}
+
+	err := r.do(
+		redis.Cmd(&offsets, "ZRANGE", "objectBytes/"+objectID, "0", "-1", "WITHSCORES"),
+	)
+	return offsets, err
+}
+
+// RestoreObject Writes the keys of an exported object in one round trip
+func (r *RedisStore) RestoreObject(object ObjectRecord) error {
+	objectID := object.ObjectID
+	fields := []string{objectKey(objectID), "storedSize", strconv.FormatInt(object.StoredSize, 10)}
+	if len(object.Status) > 0 {
+		fields = append(fields, "status", object.Status)
+	}
+	if object.Size != nil {
+		fields = append(fields, "size", strconv.FormatInt(*object.Size, 10))
+	}
+	if len(object.DataKey) > 0 {
+		fields = append(fields, "dataKey", object.DataKey)
+	}
+	if len(object.ContentType) > 0 {
+		fields = append(fields, "contentType", object.ContentType)
+	}
+	if len(object.Checksum) > 0 {
+		fields = append(fields, "checksum", object.Checksum)
+	}
+	if len(object.Owner) > 0 {
+		fields = append(fields, "owner", object.Owner)
+	}
+	if object.CreatedAt > 0 {
+		fields = append(fields, "createdAt", strconv.FormatInt(object.CreatedAt, 10))
+	}
+
+	cmds := []redis.CmdAction{
+		redis.Cmd(nil, "SADD", "objects", objectID),
+		redis.Cmd(nil, "HSET", fields...),
+		redis.Cmd(nil, "SET", "/objects/"+objectID+"/ticketCounter", strconv.FormatInt(object.TicketCounter, 10)),
+		redis.Cmd(nil, "SET", "/objects/"+objectID+"/writeCounter", strconv.FormatInt(object.WriteCounter, 10)),
+	}
+	for ticketID, byteStart := range object.Tickets {
+		cmds = append(cmds,
+			redis.Cmd(nil, "ZADD", "objectBytes/"+objectID, strconv.FormatInt(byteStart, 10), ticketID),
+			redis.Cmd(nil, "SADD", "objectTickets/"+objectID, ticketID),
+		)
+	}
+	if len(object.Nodes) > 0 {
+		cmds = append(cmds, redis.Cmd(nil, "SADD", append([]string{"objectNodes/" + objectID}, object.Nodes...)...))
+	}
+	if object.PendingCleanup {
+		cmds = append(cmds, redis.Cmd(nil, "SADD", "shreddedObjects", objectID))
+	}
+	return r.do(redis.Pipeline(cmds...))
+}
+
+// RestoreTicket Writes the hash of an exported ticket
+func (r *RedisStore) RestoreTicket(ticket Ticket) error {
+	fields := []string{
+		ticketKey(ticket.TicketID),
+		"ticket", ticket.TicketID,
+		"object", ticket.ObjectID,
+		"node", ticket.NodeID,
+		"byteStart", strconv.FormatInt(ticket.ByteStart, 10),
+		"byteEnd", strconv.FormatInt(ticket.ByteEnd, 10),
+		"byteCount", strconv.FormatInt(ticket.ByteCount, 10),
+	}
+	optional := map[string]string{
+		"status":      ticket.Status,
+		"compression": ticket.Compression,
+		"hash":        ticket.ContentHash,
+	}
+	for field, value := range optional {
+		if len(value) > 0 {
+			fields = append(fields, field, value)
+		}
+	}
+	if ticket.StoredByteCount > 0 {
+		fields = append(fields, "storedByteCount", strconv.FormatInt(ticket.StoredByteCount, 10))
+	}
+	if ticket.References > 0 {
+		fields = append(fields, "refCount", strconv.FormatInt(ticket.References, 10))
+	}
+
+	cmds := []redis.CmdAction{redis.Cmd(nil, "HSET", fields...)}
+	if len(ticket.ContentHash) > 0 {
+		cmds = append(cmds, redis.Cmd(nil, "HSETNX", "ticketHashes", ticket.ContentHash, ticket.TicketID))
+	}
+	return r.do(redis.Pipeline(cmds...))
+}
+
+// GetTicketMetadata An entire tickets metadata without data
+func (r *RedisStore) GetTicketMetadata(ticketID string) (Ticket, error) {
+	ticket := Ticket{
+		KeyPath: ticketKey(ticketID),
+	}
+	fields := map[string]string{}
+	if err := r.do(redis.Cmd(&fields, "HGETALL", ticket.KeyPath)); err != nil {
+		log.Printf("Error getting %s: %v\n", ticket.KeyPath, err)
+		return ticket, err
+	}
+
+	ticket.TicketID = fields["ticket"]
+	ticket.ObjectID = fields["object"]
+	ticket.NodeID = fields["node"]
+	ticket.Status = fields["status"]
+	ticket.Compression = fields["compression"]
+	ticket.ContentHash = fields["hash"]
+
+	intFields := map[string]*int64{
+		"byteCount":       &ticket.ByteCount,
+		"byteStart":       &ticket.ByteStart,
+		"byteEnd":         &ticket.ByteEnd,
+		"storedByteCount": &ticket.StoredByteCount,
+		"refCount":        &ticket.References,
+	}
+	for field, v := range intFields {
+		if len(fields[field]) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[field], 10, 64)
+		if err != nil {
+			log.Printf("Error parsing %s of %s: %v\n", field, ticket.KeyPath, err)
+			return ticket, err
+		}
+		*v = n
+	}
+
+	return ticket, nil
+}
+
+// DeleteTicket Removes a ticket from its object and deletes its metadata
+func (r *RedisStore) DeleteTicket(objectID, ticketID string) error {
+	// The hash index keeps the ticket only when it's the indexed ticket
+	return r.do(deleteTicketScript.Cmd(nil,
+		ticketKey(ticketID),
+		"ticketHashes",
+		// Remove ticket from set of object tickets
+		"objectTickets/"+objectID,
+		ticketID,
+	))
+}
+
+func (r *RedisStore) DeleteObjectReference(objectID string) error {
+	log.Printf("DeleteObjectReference %s\n", objectID)
+	object, err := r.GetObjectMetadata(objectID)
+	if err != nil {
+		return err
+	}
+
+	// Delete the object from the set of objects along with its keys, and
+	// from the bucket key it's stored under
+	return r.do(deleteObjectReferenceScript.Cmd(nil,
+		"objects",
+		// Delete set of tickets associated with the object
+		"objectTickets/"+objectID,
+		// Delete set of nodes the object was written to
+		"objectNodes/"+objectID,
+		// Delete min heap of ticket ids
+		"objectBytes/"+objectID,
+		objectKey(objectID),
+		"/objects/"+objectID+"/writeCounter",
+		"/objects/"+objectID+"/ticketCounter",
+		"bucketKeys/"+object.Bucket,
+		"bucketIndex/"+object.Bucket,
+		objectID, object.Bucket, object.Key,
+	))
+}
+
+func (r *RedisStore) GetObjectSize(objectID string) (int64, error) {
+	v, err := r.getField(objectKey(objectID), "size")
+
+	if err != nil {
+		return int64(0), err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// GetObjectStoredSize Bytes an object occupies on disk after compression
+func (r *RedisStore) GetObjectStoredSize(objectID string) (int64, error) {
+	var storedSize int64
+	err := r.do(redis.Cmd(&storedSize, "HGET", objectKey(objectID), "storedSize"))
+	return storedSize, err
+}
+
+// GetObjectCreatedAt When the first ticket of an object was created, zero
+// for objects created before it was recorded
+func (r *RedisStore) GetObjectCreatedAt(objectID string) (time.Time, error) {
+	var createdAt int64
+	err := r.do(redis.Cmd(&createdAt, "HGET", objectKey(objectID), "createdAt"))
+	if err != nil || createdAt == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(createdAt, 0), nil
+}
+
+// StampObjectCreatedAt HSETNX of createdAt on the hash of an object which
+// still exists, so a deleted object isn't created again
+func (r *RedisStore) StampObjectCreatedAt(objectID string) error {
+	return r.do(stampCreatedAtScript.Cmd(nil, objectKey(objectID), strconv.FormatInt(time.Now().Unix(), 10)))
+}
+
+// MigrateHashLayout Converts tickets and objects kept as a string key per
+// field into hashes. Safe to run again, or while nothing is writing.
+// Returns the number of fields moved.
+func (r *RedisStore) MigrateHashLayout() (int, error) {
+	pool, err := r.connect()
+	if err != nil {
+		return 0, err
+	}
+
+	tickets := map[string]bool{}
+	objects := map[string]bool{}
+	staleKeys := []string{}
+
+	// Only the legacy keys of tickets and objects are scanned, the rest of
+	// the keyspace may not belong to data-putter
+	for _, pattern := range legacyKeyPatterns {
+		scanner := redis.NewScanner(pool, redis.ScanOpts{Command: "SCAN", Pattern: pattern, Count: 1000})
+		var key string
+		for scanner.Next(&key) {
+			parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+			switch {
+			case len(parts) == 3 && parts[0] == "tickets":
+				tickets[parts[1]] = true
+			// Counters stay as keys of their own
+			case len(parts) == 3 && parts[0] == "objects" && !strings.HasSuffix(parts[2], "Counter"):
+				objects[parts[1]] = true
+			// /objects/$objectID/tickets/$ticketID duplicated objectTickets/$objectID
+			case len(parts) == 4 && parts[0] == "objects" && parts[2] == "tickets":
+				staleKeys = append(staleKeys, key)
+			}
+		}
+		if err := scanner.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	moved := 0
+	migrate := func(key string, fields []string) error {
+		var n int
+		err := r.do(migrateHashScript.Cmd(&n, append([]string{key}, fields...)...))
+		moved += n
+		return err
+	}
+	for ticketID := range tickets {
+		if err := migrate(ticketKey(ticketID), legacyTicketFields); err != nil {
+			return moved, err
+		}
+	}
+	for objectID := range objects {
+		if err := migrate(objectKey(objectID), legacyObjectFields); err != nil {
+			return moved, err
+		}
+	}
+	for _, key := range staleKeys {
+		if err := r.deleteKeyPath(key); err != nil {
+			return moved, err
+		}
+	}
+	log.Printf("Migrated %d fields of %d tickets and %d objects\n", moved, len(tickets), len(objects))
+	return moved, nil
+}
+
+// MigrateStatuses Gives metadata from before status transitions were
+// enforced a status they can be deleted from. Tickets were only created
+// once their node wrote them, so those without a status are saved, and
+// new or writing objects with a size are saved.
+// Returns the number of statuses set.
+func (r *RedisStore) MigrateStatuses() (int, error) {
+	objectIDs, err := r.GetObjects()
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, objectID := range objectIDs {
+		var n int
+		err := r.do(migrateObjectStatusScript.Cmd(&n, objectKey(objectID),
+			ObjectStatus[ObjectNew], ObjectStatus[ObjectWriting], ObjectStatus[ObjectSaved],
+		))
+		if err != nil {
+			return migrated, err
+		}
+		migrated += n
+
+		ticketIDs, err := r.GetObjectTickets(objectID)
+		if err != nil {
+			return migrated, err
+		}
+		for _, ticketID := range ticketIDs {
+			err := r.do(migrateTicketStatusScript.Cmd(&n, ticketKey(ticketID), TicketStatus[TicketSaved]))
+			if err != nil {
+				return migrated, err
+			}
+			migrated += n
+		}
+	}
+	log.Printf("Set %d statuses of %d objects\n", migrated, len(objectIDs))
+	return migrated, nil
+}
+
+func bucketKey(name string) string {
+	return "/buckets/" + name
+}
+
+// CreateBucket Adds the bucket to the set of buckets and writes its settings
+func (r *RedisStore) CreateBucket(bucket Bucket) error {
+	var created int
+	err := r.do(createBucketScript.Cmd(&created,
+		"buckets", bucketKey(bucket.Name),
+		bucket.Name,
+		bucket.Compression,
+		strconv.FormatInt(int64(bucket.Retention/time.Second), 10),
+		strconv.FormatInt(bucket.CreatedAt.Unix(), 10),
+		bucket.Owner,
+	))
+	if err == nil && created == 0 {
+		return ErrBucketExists
+	}
+	return err
+}
+
+// GetBucket The settings of a bucket with one HGETALL
+func (r *RedisStore) GetBucket(name string) (Bucket, error) {
+	bucket := Bucket{Name: name}
+	fields := map[string]string{}
+	if err := r.do(redis.Cmd(&fields, "HGETALL", bucketKey(name))); err != nil {
+		return bucket, err
+	}
+	if len(fields) == 0 {
+		return bucket, ErrBucketNotExist
+	}
+
+	bucket.Compression = fields["compression"]
+	bucket.Owner = fields["owner"]
+	if retention, err := strconv.ParseInt(fields["retention"], 10, 64); err == nil {
+		bucket.Retention = time.Duration(retention) * time.Second
+	}
+	if createdAt, err := strconv.ParseInt(fields["createdAt"], 10, 64); err == nil {
+		bucket.CreatedAt = time.Unix(createdAt, 0)
+	}
+	return bucket, nil
+}
+
+// GetBuckets Every bucket name in the set of buckets
+func (r *RedisStore) GetBuckets() ([]string, error) {
+	buckets := []string{}
+	err := r.do(redis.Cmd(&buckets, "SMEMBERS", "buckets"))
+	return buckets, err
+}
+
+// DeleteBucket Deletes the settings of a bucket when it has no keys
+func (r *RedisStore) DeleteBucket(name string) error {
+	var deleted int
+	err := r.do(deleteBucketScript.Cmd(&deleted,
+		"buckets", bucketKey(name), "bucketIndex/"+name, "bucketPolicy/"+name,
+		name,
+	))
+	switch {
+	case err != nil:
+		return err
+	case deleted < 0:
+		return ErrBucketNotExist
+	case deleted == 0:
+		return ErrBucketNotEmpty
+	}
+	return nil
+}
+
+// PutBucketKey Points a key at an ObjectID and indexes the key
+func (r *RedisStore) PutBucketKey(bucket, key, objectID string) (string, error) {
+	var previous string
+	err := r.do(putBucketKeyScript.Cmd(&previous,
+		bucketKey(bucket), "bucketKeys/"+bucket, "bucketIndex/"+bucket,
+		key, objectID,
+	))
+	if err != nil && strings.Contains(err.Error(), "NOBUCKET") {
+		return "", ErrBucketNotExist
+	}
+	return previous, err
+}
+
+// GetBucketKey The ObjectID of a key, empty when it has none
+func (r *RedisStore) GetBucketKey(bucket, key string) (string, error) {
+	return r.getField("bucketKeys/"+bucket, key)
+}
+
+// DeleteBucketKey Removes a key from a bucket and its index
+func (r *RedisStore) DeleteBucketKey(bucket, key string) (string, error) {
+	var objectID string
+	err := r.do(deleteBucketKeyScript.Cmd(&objectID,
+		"bucketKeys/"+bucket, "bucketIndex/"+bucket,
+		key,
+	))
+	return objectID, err
+}
+
+// ScanBucketKeys Pages through the keys of a bucket with ZRANGEBYLEX, the
+// cursor is the last key of the previous page
+func (r *RedisStore) ScanBucketKeys(bucket, prefix, cursor string, count int) ([]BucketKey, string, error) {
+	min, max := "-", "+"
+	if len(prefix) > 0 {
+		min, max = "["+prefix, "["+prefix+"\xff"
+	}
+	if len(cursor) > 0 {
+		min = "(" + cursor
+	}
+
+	keys := []string{}
+	err := r.do(redis.Cmd(&keys, "ZRANGEBYLEX", "bucketIndex/"+bucket, min, max, "LIMIT", "0", strconv.Itoa(count)))
+	if err != nil || len(keys) == 0 {
+		return []BucketKey{}, "", err
+	}
+	objectIDs := []string{}
+	err = r.do(redis.Cmd(&objectIDs, "HMGET", append([]string{"bucketKeys/" + bucket}, keys...)...))
+	if err != nil {
+		return []BucketKey{}, "", err
+	}
+
+	page := make([]BucketKey, len(keys))
+	for i, key := range keys {
+		page[i] = BucketKey{Key: key}
+		if i < len(objectIDs) {
+			page[i].ObjectID = objectIDs[i]
+		}
+	}
+	if len(keys) < count {
+		return page, "", nil
+	}
+	return page, keys[len(keys)-1], nil
+}
+
+// PutBucketPolicy Writes the grants of a bucket as JSON
+func (r *RedisStore) PutBucketPolicy(bucket string, grants []Grant) error {
+	policy, err := json.Marshal(grants)
+	if err != nil {
+		return err
+	}
+	err = r.do(putBucketPolicyScript.Cmd(nil, bucketKey(bucket), "bucketPolicy/"+bucket, string(policy)))
+	if err != nil && strings.Contains(err.Error(), "NOBUCKET") {
+		return ErrBucketNotExist
+	}
+	return err
+}
+
+// GetBucketPolicy The grants of a bucket, empty when it has none
+func (r *RedisStore) GetBucketPolicy(bucket string) ([]Grant, error) {
+	grants := []Grant{}
+	var policy string
+	if err := r.do(redis.Cmd(&policy, "GET", "bucketPolicy/"+bucket)); err != nil || len(policy) == 0 {
+		return grants, err
+	}
+	err := json.Unmarshal([]byte(policy), &grants)
+	return grants, err
+}
+
+// PutAPIKey Adds a key to the apiKeys hash {SHA-256: tenant}
+func (r *RedisStore) PutAPIKey(keyHash, tenant string) error {
+	return r.setField("apiKeys", keyHash, tenant)
+}
+
+// GetAPIKeyTenant The tenant of a key, empty when it doesn't exist
+func (r *RedisStore) GetAPIKeyTenant(keyHash string) (string, error) {
+	return r.getField("apiKeys", keyHash)
+}
+
+// GetAPIKeys The whole apiKeys hash
+func (r *RedisStore) GetAPIKeys() (map[string]string, error) {
+	keys := map[string]string{}
+	err := r.do(redis.Cmd(&keys, "HGETALL", "apiKeys"))
+	return keys, err
+}
+
+// GetTenants Scans for the tenantQuotas/ and tenantUsage/ hashes
+func (r *RedisStore) GetTenants() ([]string, error) {
+	pool, err := r.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := map[string]bool{}
+	for _, prefix := range []string{"tenantQuotas/", "tenantUsage/"} {
+		scanner := redis.NewScanner(pool, redis.ScanOpts{Command: "SCAN", Pattern: prefix + "*", Count: 1000})
+		var key string
+		for scanner.Next(&key) {
+			tenants[strings.TrimPrefix(key, prefix)] = true
+		}
+		if err := scanner.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return setMembers(tenants), nil
+}
+
+// getQuotaFields The bytes and objects fields of a quota or usage hash
+func (r *RedisStore) getQuotaFields(key string) (Quota, error) {
+	quota := Quota{}
+	fields := map[string]string{}
+	if err := r.do(redis.Cmd(&fields, "HGETALL", key)); err != nil {
+		return quota, err
+	}
+	quota.Bytes, _ = strconv.ParseInt(fields["bytes"], 10, 64)
+	quota.Objects, _ = strconv.ParseInt(fields["objects"], 10, 64)
+	return quota, nil
+}
+
+// SetTenantQuota Writes the limits of a tenant to tenantQuotas/tenant
+func (r *RedisStore) SetTenantQuota(tenant string, quota Quota) error {
+	return r.do(redis.Cmd(nil, "HSET", "tenantQuotas/"+tenant,
+		"bytes", strconv.FormatInt(quota.Bytes, 10),
+		"objects", strconv.FormatInt(quota.Objects, 10),
+	))
+}
+
+func (r *RedisStore) GetTenantQuota(tenant string) (Quota, error) {
+	return r.getQuotaFields("tenantQuotas/" + tenant)
+}
+
+func (r *RedisStore) GetTenantUsage(tenant string) (Quota, error) {
+	return r.getQuotaFields("tenantUsage/" + tenant)
+}
+
+// ReserveTenantUsage Checks the quota and adds to the usage in one script
+func (r *RedisStore) ReserveTenantUsage(tenant string, bytes, objects int64) (bool, error) {
+	var reserved int
+	err := r.do(reserveTenantUsageScript.Cmd(&reserved,
+		"tenantUsage/"+tenant, "tenantQuotas/"+tenant,
+		strconv.FormatInt(bytes, 10), strconv.FormatInt(objects, 10),
+	))
+	return reserved == 1, err
+}
+
+func (r *RedisStore) AddTenantUsage(tenant string, bytes, objects int64) error {
+	return r.do(redis.Pipeline(
+		redis.Cmd(nil, "HINCRBY", "tenantUsage/"+tenant, "bytes", strconv.FormatInt(bytes, 10)),
+		redis.Cmd(nil, "HINCRBY", "tenantUsage/"+tenant, "objects", strconv.FormatInt(objects, 10)),
+	))
+}
+
+// DeleteAPIKey Removes a key from the apiKeys hash
+func (r *RedisStore) DeleteAPIKey(keyHash string) error {
+	return r.do(redis.Cmd(nil, "HDEL", "apiKeys", keyHash))
+}
@@ -0,0 +1,307 @@
+package dataputter
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	redis "github.com/mediocregopher/radix/v3"
+)
+
+func TestMigrateHashLayout(t *testing.T) {
+	hostport := os.Getenv("REDIS_HOSTPORT")
+	if len(hostport) == 0 {
+		t.Skip("REDIS_HOSTPORT is not set")
+	}
+	store := NewRedisStore(hostport)
+	defer store.DeleteObjectReference("TEST_MIGRATE_OBJECT")
+	defer store.DeleteTicket("TEST_MIGRATE_OBJECT", "TEST_MIGRATE_TICKET")
+
+	legacy := map[string]string{
+		"/tickets/TEST_MIGRATE_TICKET/ticket":                      "TEST_MIGRATE_TICKET",
+		"/tickets/TEST_MIGRATE_TICKET/object":                      "TEST_MIGRATE_OBJECT",
+		"/tickets/TEST_MIGRATE_TICKET/node":                        "TEST_NODE_ID",
+		"/tickets/TEST_MIGRATE_TICKET/byteStart":                   "0",
+		"/tickets/TEST_MIGRATE_TICKET/byteEnd":                     "10",
+		"/tickets/TEST_MIGRATE_TICKET/byteCount":                   "10",
+		"/objects/TEST_MIGRATE_OBJECT/size":                        "10",
+		"/objects/TEST_MIGRATE_OBJECT/status":                      "writing",
+		"/objects/TEST_MIGRATE_OBJECT/tickets/TEST_MIGRATE_TICKET": "TEST_MIGRATE_TICKET",
+	}
+	for key, value := range legacy {
+		if err := store.do(redis.Cmd(nil, "SET", key, value)); err != nil {
+			t.Fatalf("Expected to write %s, got %v\n", key, err)
+		}
+	}
+
+	// Keys outside of data-putter are left alone
+	store.do(redis.Cmd(nil, "SET", "TEST_MIGRATE_OTHER/tickets/x", "other"))
+	defer store.do(redis.Cmd(nil, "DEL", "TEST_MIGRATE_OTHER/tickets/x"))
+
+	moved, err := store.MigrateHashLayout()
+	if err != nil {
+		t.Fatalf("Expected migration to succeed, got %v\n", err)
+	}
+	if moved < 8 {
+		t.Errorf("Expected at least 8 fields moved, got %d\n", moved)
+	}
+
+	ticket, err := store.GetTicketMetadata("TEST_MIGRATE_TICKET")
+	if err != nil || ticket.ObjectID != "TEST_MIGRATE_OBJECT" || ticket.ByteCount != 10 {
+		t.Errorf("Expected migrated ticket metadata, got %s %v\n", ticket, err)
+	}
+	if size, _ := store.GetObjectSize("TEST_MIGRATE_OBJECT"); size != 10 {
+		t.Errorf("Expected migrated object size 10, got %d\n", size)
+	}
+	for key := range legacy {
+		var exists int
+		store.do(redis.Cmd(&exists, "EXISTS", key))
+		if exists != 0 {
+			t.Errorf("Expected %s to be removed, got it\n", key)
+		}
+	}
+
+	var other string
+	if store.do(redis.Cmd(&other, "GET", "TEST_MIGRATE_OTHER/tickets/x")); other != "other" {
+		t.Errorf("Expected keys outside of data-putter to be kept, got %s\n", other)
+	}
+
+	if moved, _ := store.MigrateHashLayout(); moved != 0 {
+		t.Errorf("Expected nothing to move the second time, got %d\n", moved)
+	}
+
+	// Complete objects and their tickets become saved
+	store.do(redis.Cmd(nil, "SADD", "objectTickets/TEST_MIGRATE_OBJECT", "TEST_MIGRATE_TICKET"))
+	store.do(redis.Cmd(nil, "SADD", "objects", "TEST_MIGRATE_OBJECT"))
+	if migrated, err := store.MigrateStatuses(); err != nil || migrated < 2 {
+		t.Errorf("Expected at least 2 statuses set, got %d %v\n", migrated, err)
+	}
+	if status, _ := store.GetObjectStatus("TEST_MIGRATE_OBJECT"); status != ObjectStatus[ObjectSaved] {
+		t.Errorf("Expected migrated object to be saved, got %s\n", status)
+	}
+	if status, _ := store.GetTicketStatus("TEST_MIGRATE_TICKET"); status != TicketStatus[TicketSaved] {
+		t.Errorf("Expected migrated ticket to be saved, got %s\n", status)
+	}
+}
+
+func TestScanObjects(t *testing.T) {
+	hostport := os.Getenv("REDIS_HOSTPORT")
+	if len(hostport) == 0 {
+		t.Skip("REDIS_HOSTPORT is not set")
+	}
+	store := NewRedisStore(hostport)
+	for i := 0; i < 5; i++ {
+		objectID := fmt.Sprintf("TEST_SCAN_OBJECT_%d", i)
+		defer store.DeleteObjectReference(objectID)
+		store.CreateObject(objectID, "TEST_SCAN_TICKET")
+	}
+
+	seen := map[string]int{}
+	cursor := ""
+	for {
+		objectIDs, next, err := store.ScanObjects(cursor, 2)
+		if err != nil {
+			t.Fatalf("Expected a page of objects, got %v\n", err)
+		}
+		if len(objectIDs) > 2 {
+			t.Errorf("Expected at most 2 objects in a page, got %d\n", len(objectIDs))
+		}
+		for _, objectID := range objectIDs {
+			seen[objectID]++
+		}
+		if cursor = next; len(cursor) == 0 {
+			break
+		}
+	}
+	for i := 0; i < 5; i++ {
+		objectID := fmt.Sprintf("TEST_SCAN_OBJECT_%d", i)
+		if seen[objectID] != 1 {
+			t.Errorf("Expected %s listed once, got %d\n", objectID, seen[objectID])
+		}
+	}
+}
+
+func TestRedisBucketKeys(t *testing.T) {
+	hostport := os.Getenv("REDIS_HOSTPORT")
+	if len(hostport) == 0 {
+		t.Skip("REDIS_HOSTPORT is not set")
+	}
+	store := NewRedisStore(hostport)
+	defer store.DeleteBucket("test-bucket")
+	defer store.DeleteBucketKey("test-bucket", "a/1")
+	defer store.DeleteBucketKey("test-bucket", "a/2")
+	defer store.DeleteBucketKey("test-bucket", "b/1")
+
+	if err := store.CreateBucket(Bucket{Name: "test-bucket", Compression: CompressionZstd, Retention: time.Hour}); err != nil {
+		t.Fatalf("Expected to create test-bucket, got %v\n", err)
+	}
+	if err := store.CreateBucket(Bucket{Name: "test-bucket"}); err != ErrBucketExists {
+		t.Errorf("Expected ErrBucketExists, got %v\n", err)
+	}
+	if bucket, _ := store.GetBucket("test-bucket"); bucket.Compression != CompressionZstd || bucket.Retention != time.Hour {
+		t.Errorf("Expected zstd kept for an hour, got %+v\n", bucket)
+	}
+	if _, err := store.PutBucketKey("missing-bucket", "a/1", "OBJECT"); err != ErrBucketNotExist {
+		t.Errorf("Expected ErrBucketNotExist, got %v\n", err)
+	}
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		store.PutBucketKey("test-bucket", key, "OBJECT_"+key)
+	}
+
+	keys, cursor, err := store.ScanBucketKeys("test-bucket", "a/", "", 1)
+	if err != nil || len(keys) != 1 || keys[0].ObjectID != "OBJECT_a/1" {
+		t.Errorf("Expected a/1, got %v %v\n", keys, err)
+	}
+	keys, _, _ = store.ScanBucketKeys("test-bucket", "a/", cursor, 10)
+	if len(keys) != 1 || keys[0].Key != "a/2" {
+		t.Errorf("Expected a/2 after a/1, got %v\n", keys)
+	}
+	if err := store.DeleteBucket("test-bucket"); err != ErrBucketNotEmpty {
+		t.Errorf("Expected ErrBucketNotEmpty, got %v\n", err)
+	}
+	if objectID, _ := store.DeleteBucketKey("test-bucket", "b/1"); objectID != "OBJECT_b/1" {
+		t.Errorf("Expected to delete OBJECT_b/1, got %s\n", objectID)
+	}
+}
+
+func TestRedisDeleteBucketedObject(t *testing.T) {
+	hostport := os.Getenv("REDIS_HOSTPORT")
+	if len(hostport) == 0 {
+		t.Skip("REDIS_HOSTPORT is not set")
+	}
+	store := NewRedisStore(hostport)
+	defer store.DeleteBucket("test-bucket")
+	defer store.DeleteBucketKey("test-bucket", "b/1")
+
+	store.CreateBucket(Bucket{Name: "test-bucket"})
+	for key, objectID := range map[string]string{"a/1": "TEST_BUCKETED_A", "b/1": "TEST_BUCKETED_B"} {
+		store.CreateObject(objectID, "")
+		store.PutBucketKey("test-bucket", key, objectID)
+		store.SetObjectKey(objectID, "test-bucket", key)
+	}
+	store.PutBucketKey("test-bucket", "b/1", "TEST_BUCKETED_C")
+
+	for _, objectID := range []string{"TEST_BUCKETED_A", "TEST_BUCKETED_B"} {
+		if err := store.DeleteObjectReference(objectID); err != nil {
+			t.Fatalf("Expected to delete %s, got %v\n", objectID, err)
+		}
+	}
+	if objectID, _ := store.GetBucketKey("test-bucket", "a/1"); len(objectID) > 0 {
+		t.Errorf("Expected a/1 to be deleted with its object, got %s\n", objectID)
+	}
+	if objectID, _ := store.GetBucketKey("test-bucket", "b/1"); objectID != "TEST_BUCKETED_C" {
+		t.Errorf("Expected b/1 to keep TEST_BUCKETED_C, got %s\n", objectID)
+	}
+	store.DeleteBucketKey("test-bucket", "b/1")
+	if err := store.DeleteBucket("test-bucket"); err != nil {
+		t.Errorf("Expected to delete test-bucket, got %v\n", err)
+	}
+}
+
+func TestRedisStampObjectCreatedAt(t *testing.T) {
+	hostport := os.Getenv("REDIS_HOSTPORT")
+	if len(hostport) == 0 {
+		t.Skip("REDIS_HOSTPORT is not set")
+	}
+	store := NewRedisStore(hostport)
+	defer store.DeleteObjectReference("TEST_STAMP_LEGACY")
+
+	store.RestoreObject(ObjectRecord{ObjectID: "TEST_STAMP_LEGACY", Status: ObjectStatus[ObjectNew]})
+	for _, objectID := range []string{"TEST_STAMP_LEGACY", "TEST_STAMP_DELETED"} {
+		if err := store.StampObjectCreatedAt(objectID); err != nil {
+			t.Fatalf("Expected to stamp %s, got %v\n", objectID, err)
+		}
+	}
+	if createdAt, _ := store.GetObjectCreatedAt("TEST_STAMP_LEGACY"); createdAt.IsZero() {
+		t.Errorf("Expected TEST_STAMP_LEGACY to get a createdAt\n")
+	}
+	var exists int
+	store.do(redis.Cmd(&exists, "EXISTS", objectKey("TEST_STAMP_DELETED")))
+	if exists != 0 {
+		t.Errorf("Expected TEST_STAMP_DELETED not to be created\n")
+	}
+}
+
+func TestRedisBucketPolicy(t *testing.T) {
+	hostport := os.Getenv("REDIS_HOSTPORT")
+	if len(hostport) == 0 {
+		t.Skip("REDIS_HOSTPORT is not set")
+	}
+	store := NewRedisStore(hostport)
+	defer store.DeleteBucket("test-policy-bucket")
+
+	grants := []Grant{{Tenant: "tenant-b", Prefix: "shared/", Access: AccessRead}}
+	if err := store.PutBucketPolicy("test-policy-bucket", grants); err != ErrBucketNotExist {
+		t.Errorf("Expected ErrBucketNotExist, got %v\n", err)
+	}
+	store.CreateBucket(Bucket{Name: "test-policy-bucket"})
+	if policy, err := store.GetBucketPolicy("test-policy-bucket"); err != nil || len(policy) != 0 {
+		t.Errorf("Expected no grants, got %v %v\n", policy, err)
+	}
+	if err := store.PutBucketPolicy("test-policy-bucket", grants); err != nil {
+		t.Fatalf("Expected to put the policy, got %v\n", err)
+	}
+	if policy, _ := store.GetBucketPolicy("test-policy-bucket"); len(policy) != 1 || policy[0] != grants[0] {
+		t.Errorf("Expected %v, got %v\n", grants, policy)
+	}
+
+	store.DeleteBucket("test-policy-bucket")
+	store.CreateBucket(Bucket{Name: "test-policy-bucket"})
+	if policy, _ := store.GetBucketPolicy("test-policy-bucket"); len(policy) != 0 {
+		t.Errorf("Expected a deleted bucket to lose its policy, got %v\n", policy)
+	}
+}
+
+func TestRedisTenantQuota(t *testing.T) {
+	hostport := os.Getenv("REDIS_HOSTPORT")
+	if len(hostport) == 0 {
+		t.Skip("REDIS_HOSTPORT is not set")
+	}
+	store := NewRedisStore(hostport)
+	defer store.do(redis.Cmd(nil, "DEL", "tenantQuotas/test-tenant", "tenantUsage/test-tenant"))
+
+	store.SetTenantQuota("test-tenant", Quota{Bytes: 100, Objects: 5})
+	if reserved, err := store.ReserveTenantUsage("test-tenant", 80, 1); err != nil || !reserved {
+		t.Fatalf("Expected 80 bytes to be reserved, got %v %v\n", reserved, err)
+	}
+	if reserved, _ := store.ReserveTenantUsage("test-tenant", 30, 1); reserved {
+		t.Errorf("Expected 110 bytes to be refused\n")
+	}
+	store.AddTenantUsage("test-tenant", -80, -1)
+	if usage, _ := store.GetTenantUsage("test-tenant"); usage != (Quota{}) {
+		t.Errorf("Expected no usage, got %+v\n", usage)
+	}
+	if quota, _ := store.GetTenantQuota("test-tenant"); quota != (Quota{Bytes: 100, Objects: 5}) {
+		t.Errorf("Expected the quota to be kept, got %+v\n", quota)
+	}
+}
+
+func TestRedisTenants(t *testing.T) {
+	hostport := os.Getenv("REDIS_HOSTPORT")
+	if len(hostport) == 0 {
+		t.Skip("REDIS_HOSTPORT is not set")
+	}
+	store := NewRedisStore(hostport)
+	defer store.do(redis.Cmd(nil, "DEL", "tenantQuotas/test-quota", "tenantUsage/test-usage"))
+	defer store.DeleteAPIKey("TEST_KEY_HASH")
+
+	store.SetTenantQuota("test-quota", Quota{Objects: 5})
+	store.AddTenantUsage("test-usage", 10, 1)
+	store.PutAPIKey("TEST_KEY_HASH", "test-key")
+
+	tenants, err := store.GetTenants()
+	if err != nil {
+		t.Fatalf("Expected tenants, got %v\n", err)
+	}
+	found := map[string]bool{}
+	for _, tenant := range tenants {
+		found[tenant] = true
+	}
+	if !found["test-quota"] || !found["test-usage"] {
+		t.Errorf("Expected the tenants with a quota and usage, got %v\n", tenants)
+	}
+	if keys, _ := store.GetAPIKeys(); keys["TEST_KEY_HASH"] != "test-key" {
+		t.Errorf("Expected TEST_KEY_HASH of test-key, got %v\n", keys)
+	}
+}
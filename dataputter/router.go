@@ -3,7 +3,8 @@
 // The router is responsible for listening for an entire
 // file of bytes from an object owner. It reads at least 1458 bytes into memory.
 //
-// Bytes are ready in 1450-byte chunks preceded by an 8-byte content length int.
+// Bytes are ready in 1450-byte chunks preceded by an 8-byte content length int,
+// or in content defined chunks when a Chunker is configured for them.
 // Each time a chunk is ready, it is assigned a ticket and a checksum (TODO) is done on the bytes.
 //
 // After the ticket, a WriteTicket and checksum are created, they are sent along with
@@ -12,23 +13,55 @@ package dataputter
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"strconv"
 	"sync"
 	"time"
+
+	grpc "google.golang.org/grpc"
 )
 
 const (
 	STANDALONE_NODE_ID         = "TARGET_PUTTER_NODE_UNKNOWN"
 	DELETE_HEADER              = "0DEL0DEL"
+	SHRED_HEADER               = "0SHR0SHR"
+	BUCKET_HEADER              = "0BKT0BKT"
+	AUTH_HEADER                = "0KEY0KEY"
+	LIST_HEADER                = "0LST0LST"
+	STAT_HEADER                = "0STA0STA"
 	DEFAULT_AUTHENTICITY_TOKEN = "ABadSharedToken!"
 	NodeSuccess                = 0
 	NodeFailed                 = 1
+	NodeNotExist               = 2
+	// The request has no API key or its tenant doesn't own what it asked for
+	NodeNotAuthorized = 3
+	// Ticket data could not be decrypted with the data key given
+	NodeDecryptFailed = 4
+	// The node has too many writes waiting, the write belongs on another
+	NodeBusy = 5
 )
 
+// routerRPCPort: Port the Router gRPC service listens on, ROUTER_RPC_PORT
+var routerRPCPort = 5003
+
+func init() {
+	if port := os.Getenv("ROUTER_RPC_PORT"); len(port) > 0 {
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			log.Printf("Ignoring ROUTER_RPC_PORT=%s: %v\n", port, err)
+			return
+		}
+		routerRPCPort = n
+	}
+}
+
 type routerServer struct {
 	UnimplementedRouterServer
 	Config RouterConfig
@@ -38,92 +71,507 @@ func (s *routerServer) CreateObject(ctx context.Context, req *CreateObjectReques
 	return &ObjectActionResponse{}, nil
 }
 
+// DeleteObject Deletes, or shreds, an object and its tickets
+func (s *routerServer) DeleteObject(ctx context.Context, req *DeleteObjectRequest) (*ObjectActionResponse, error) {
+	audit := AuditEntry{Operation: AuditDelete, Tenant: tenantOf(ctx), ObjectID: req.ObjectId}
+	if req.Shred {
+		audit.Operation = AuditShred
+	}
+	if err := AuthorizeObject(tenantOf(ctx), req.ObjectId, AccessWrite); err != nil {
+		log.Printf("Refused to delete %s: %v\n", req.ObjectId, err)
+		Audit(audit, err)
+		return &ObjectActionResponse{Status: NodeNotAuthorized, ObjectId: req.ObjectId}, nil
+	}
+	var err error
+	if req.Shred {
+		err = ShredObject(req.ObjectId)
+	} else {
+		_, err = DeleteObject(req.ObjectId)
+	}
+	Audit(audit, err)
+	if err != nil {
+		log.Printf("Failed to delete %s: %v\n", req.ObjectId, err)
+		return &ObjectActionResponse{Status: NodeFailed, ObjectId: req.ObjectId}, nil
+	}
+	return &ObjectActionResponse{Status: NodeSuccess, ObjectId: req.ObjectId}, nil
+}
+
+// ListObjects A page of the tenant's objects with their size, status and
+// content type
+func (s *routerServer) ListObjects(ctx context.Context, req *ListObjectsRequest) (*ListObjectsResponse, error) {
+	objects, cursor, err := ListObjects(req.Cursor, int(req.Limit), req.Status, tenantOf(ctx))
+	if err != nil {
+		log.Printf("Failed to list objects after %s: %v\n", req.Cursor, err)
+		return nil, err
+	}
+	response := &ListObjectsResponse{Cursor: cursor}
+	for _, object := range objects {
+		response.Objects = append(response.Objects, objectInfo(object))
+	}
+	return response, nil
+}
+
+// StatObject Metadata of an object without reading any of its data
+func (s *routerServer) StatObject(ctx context.Context, req *StatObjectRequest) (*StatObjectResponse, error) {
+	if err := AuthorizeObject(tenantOf(ctx), req.ObjectId, AccessRead); err != nil {
+		return &StatObjectResponse{Status: NodeNotAuthorized}, nil
+	}
+	stat, err := StatObject(req.ObjectId)
+	if err == ErrObjectNotExist {
+		return &StatObjectResponse{Status: NodeNotExist}, nil
+	}
+	if err != nil {
+		log.Printf("Failed to stat %s: %v\n", req.ObjectId, err)
+		return &StatObjectResponse{Status: NodeFailed}, nil
+	}
+	return &StatObjectResponse{
+		Status:      NodeSuccess,
+		Object:      objectInfo(stat.ObjectMetadata),
+		TicketCount: int64(stat.TicketCount),
+		Nodes:       stat.Nodes,
+	}, nil
+}
+
+// CreateBucket Creates an empty bucket with its settings
+func (s *routerServer) CreateBucket(ctx context.Context, req *CreateBucketRequest) (*BucketResponse, error) {
+	bucket := Bucket{Name: req.Name, Owner: tenantOf(ctx)}
+	if settings := req.Settings; settings != nil {
+		bucket.Compression = settings.Compression
+		bucket.Retention = time.Duration(settings.RetentionSeconds) * time.Second
+	}
+	if err := CreateBucket(bucket); err != nil {
+		log.Printf("Failed to create bucket %s: %v\n", req.Name, err)
+		return &BucketResponse{Status: NodeFailed}, nil
+	}
+	bucket, err := GetBucket(req.Name)
+	if err != nil {
+		return &BucketResponse{Status: NodeFailed}, nil
+	}
+	return &BucketResponse{Status: NodeSuccess, Bucket: bucketInfo(bucket)}, nil
+}
+
+// DeleteBucket Deletes a bucket without keys
+func (s *routerServer) DeleteBucket(ctx context.Context, req *DeleteBucketRequest) (*BucketResponse, error) {
+	err := authorizeBucketName(ctx, req.Name, "", AccessAdmin)
+	if err == nil {
+		err = DeleteBucket(req.Name)
+	}
+	switch err {
+	case nil:
+		return &BucketResponse{Status: NodeSuccess}, nil
+	case ErrBucketNotExist:
+		return &BucketResponse{Status: NodeNotExist}, nil
+	case ErrForbidden:
+		return &BucketResponse{Status: NodeNotAuthorized}, nil
+	default:
+		log.Printf("Failed to delete bucket %s: %v\n", req.Name, err)
+		return &BucketResponse{Status: NodeFailed}, nil
+	}
+}
+
+// ListBuckets Every bucket the tenant owns or is granted access to with its
+// settings
+func (s *routerServer) ListBuckets(ctx context.Context, req *ListBucketsRequest) (*ListBucketsResponse, error) {
+	names, err := GetBuckets()
+	if err != nil {
+		return nil, err
+	}
+	response := &ListBucketsResponse{}
+	for _, name := range names {
+		bucket, err := GetBucket(name)
+		if err != nil {
+			return nil, err
+		}
+		visible, err := IsBucketVisible(tenantOf(ctx), bucket)
+		if err != nil {
+			return nil, err
+		}
+		if !visible {
+			continue
+		}
+		response.Buckets = append(response.Buckets, bucketInfo(bucket))
+	}
+	return response, nil
+}
+
+// ListBucketKeys A page of the keys of a bucket starting with a prefix,
+// without those the tenant can't read
+func (s *routerServer) ListBucketKeys(ctx context.Context, req *ListBucketKeysRequest) (*ListBucketKeysResponse, error) {
+	bucket, err := GetBucket(req.Bucket)
+	if err != nil {
+		return &ListBucketKeysResponse{Status: bucketErrorStatus(err)}, nil
+	}
+	if visible, err := IsBucketVisible(tenantOf(ctx), bucket); err != nil || !visible {
+		if err == nil {
+			err = ErrForbidden
+		}
+		return &ListBucketKeysResponse{Status: bucketErrorStatus(err)}, nil
+	}
+	keys, cursor, err := ListBucketKeys(req.Bucket, req.Prefix, req.Cursor, int(req.Limit))
+	switch {
+	case err == ErrBucketNotExist:
+		return &ListBucketKeysResponse{Status: NodeNotExist}, nil
+	case err != nil:
+		log.Printf("Failed to list keys of %s: %v\n", req.Bucket, err)
+		return &ListBucketKeysResponse{Status: NodeFailed}, nil
+	}
+	response := &ListBucketKeysResponse{Status: NodeSuccess, Cursor: cursor}
+	for _, key := range keys {
+		if AuthorizeBucket(tenantOf(ctx), bucket, key.Key, AccessRead) != nil {
+			continue
+		}
+		response.Keys = append(response.Keys, &BucketKeyInfo{Key: key.Key, ObjectId: key.ObjectID})
+	}
+	return response, nil
+}
+
+// GetBucketKey The ObjectID a key of a bucket holds
+func (s *routerServer) GetBucketKey(ctx context.Context, req *BucketKeyRequest) (*BucketKeyResponse, error) {
+	if err := authorizeBucketName(ctx, req.Bucket, req.Key, AccessRead); err != nil {
+		return &BucketKeyResponse{Status: bucketErrorStatus(err)}, nil
+	}
+	objectID, err := GetObjectKey(req.Bucket, req.Key)
+	switch {
+	case err != nil:
+		log.Printf("Failed to get %s/%s: %v\n", req.Bucket, req.Key, err)
+		return &BucketKeyResponse{Status: NodeFailed}, nil
+	case len(objectID) == 0:
+		return &BucketKeyResponse{Status: NodeNotExist}, nil
+	}
+	return &BucketKeyResponse{Status: NodeSuccess, ObjectId: objectID}, nil
+}
+
+// DeleteBucketKey Deletes a key of a bucket and its object
+func (s *routerServer) DeleteBucketKey(ctx context.Context, req *BucketKeyRequest) (*BucketKeyResponse, error) {
+	if err := authorizeBucketName(ctx, req.Bucket, req.Key, AccessWrite); err != nil {
+		return &BucketKeyResponse{Status: bucketErrorStatus(err)}, nil
+	}
+	objectID, err := DeleteObjectKey(req.Bucket, req.Key)
+	if len(objectID) > 0 {
+		Audit(AuditEntry{Operation: AuditDelete, Tenant: tenantOf(ctx), ObjectID: objectID}, err)
+	}
+	switch {
+	case err != nil:
+		log.Printf("Failed to delete %s/%s: %v\n", req.Bucket, req.Key, err)
+		return &BucketKeyResponse{Status: NodeFailed, ObjectId: objectID}, nil
+	case len(objectID) == 0:
+		return &BucketKeyResponse{Status: NodeNotExist}, nil
+	}
+	return &BucketKeyResponse{Status: NodeSuccess, ObjectId: objectID}, nil
+}
+
+// PutBucketPolicy Replaces the grants of a bucket, only for its admins
+func (s *routerServer) PutBucketPolicy(ctx context.Context, req *BucketPolicyRequest) (*BucketPolicyResponse, error) {
+	if err := authorizeBucketName(ctx, req.Bucket, "", AccessAdmin); err != nil {
+		return &BucketPolicyResponse{Status: bucketErrorStatus(err)}, nil
+	}
+	grants := make([]Grant, len(req.Grants))
+	for i, grant := range req.Grants {
+		grants[i] = Grant{Tenant: grant.Tenant, Prefix: grant.Prefix, Access: grant.Access, Deny: grant.Deny}
+	}
+	if err := PutBucketPolicy(req.Bucket, grants); err != nil {
+		log.Printf("Failed to put policy of %s: %v\n", req.Bucket, err)
+		return &BucketPolicyResponse{Status: NodeFailed}, nil
+	}
+	return &BucketPolicyResponse{Status: NodeSuccess, Grants: req.Grants}, nil
+}
+
+// GetBucketPolicy The grants of a bucket, only for its admins
+func (s *routerServer) GetBucketPolicy(ctx context.Context, req *BucketPolicyRequest) (*BucketPolicyResponse, error) {
+	if err := authorizeBucketName(ctx, req.Bucket, "", AccessAdmin); err != nil {
+		return &BucketPolicyResponse{Status: bucketErrorStatus(err)}, nil
+	}
+	grants, err := GetBucketPolicy(req.Bucket)
+	if err != nil {
+		log.Printf("Failed to get policy of %s: %v\n", req.Bucket, err)
+		return &BucketPolicyResponse{Status: NodeFailed}, nil
+	}
+	response := &BucketPolicyResponse{Status: NodeSuccess}
+	for _, grant := range grants {
+		response.Grants = append(response.Grants, &PolicyGrant{
+			Tenant: grant.Tenant, Prefix: grant.Prefix, Access: grant.Access, Deny: grant.Deny,
+		})
+	}
+	return response, nil
+}
+
+// PresignObject Mints a token downloading an object, or uploading one, over
+// the Router HTTP endpoint
+func (s *routerServer) PresignObject(ctx context.Context, req *PresignRequest) (*PresignResponse, error) {
+	ttl := time.Duration(req.ExpiresSeconds) * time.Second
+	var token, path string
+	var err error
+	switch req.Operation {
+	case PresignDownload:
+		token, err = PresignObjectDownload(tenantOf(ctx), req.ObjectId, ttl)
+		path = "/objects/" + req.ObjectId
+	case PresignUpload:
+		token, err = PresignObjectUpload(tenantOf(ctx), req.Bucket, req.Key, ttl)
+		path = "/objects"
+	default:
+		err = fmt.Errorf("Unknown operation '%s'\n", req.Operation)
+	}
+	switch err {
+	case nil:
+	case ErrBucketNotExist:
+		return &PresignResponse{Status: NodeNotExist}, nil
+	case ErrForbidden:
+		return &PresignResponse{Status: NodeNotAuthorized}, nil
+	default:
+		log.Printf("Failed to presign %s: %v\n", req.Operation, err)
+		return &PresignResponse{Status: NodeFailed}, nil
+	}
+	return &PresignResponse{
+		Status:    NodeSuccess,
+		Token:     token,
+		Path:      path + "?token=" + token,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}, nil
+}
+
+// authorizeBucketName ErrBucketNotExist or ErrForbidden unless the tenant of
+// the request owns the bucket or its policy allows the access to key
+func authorizeBucketName(ctx context.Context, name, key, access string) error {
+	bucket, err := GetBucket(name)
+	if err != nil {
+		return err
+	}
+	return AuthorizeBucket(tenantOf(ctx), bucket, key, access)
+}
+
+// bucketErrorStatus The status of a response to a bucket which couldn't be used
+func bucketErrorStatus(err error) int32 {
+	switch err {
+	case ErrBucketNotExist:
+		return NodeNotExist
+	case ErrForbidden:
+		return NodeNotAuthorized
+	}
+	log.Printf("Unable to get bucket: %v\n", err)
+	return NodeFailed
+}
+
+// bucketInfo The BucketInfo message of a bucket
+func bucketInfo(bucket Bucket) *BucketInfo {
+	return &BucketInfo{
+		Name: bucket.Name,
+		Settings: &BucketSettings{
+			Compression:      bucket.Compression,
+			RetentionSeconds: int64(bucket.Retention / time.Second),
+		},
+		CreatedAt: bucket.CreatedAt.Unix(),
+	}
+}
+
+// objectInfo The ObjectInfo message of an object
+func objectInfo(object ObjectMetadata) *ObjectInfo {
+	info := &ObjectInfo{
+		ObjectId:    object.ObjectID,
+		Size:        object.Size,
+		Status:      object.Status,
+		ContentType: object.ContentType,
+		Checksum:    object.Checksum,
+	}
+	if !object.CreatedAt.IsZero() {
+		info.CreatedAt = object.CreatedAt.Unix()
+	}
+	return info
+}
+
+// serveRouterRPC Serves the Router gRPC service on port
+func serveRouterRPC(port int, config RouterConfig) {
+	s, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Printf("Unable to listen for Router RPC on %d: %v\n", port, err)
+		return
+	}
+	options, err := GRPCServerOptions()
+	if err != nil {
+		log.Printf("Unable to serve Router RPC on %d: %v\n", port, err)
+		return
+	}
+	options = append(options, grpc.ChainUnaryInterceptor(authInterceptor, limitInterceptor))
+	rpcServer := grpc.NewServer(options...)
+	RegisterRouterServer(rpcServer, &routerServer{Config: config})
+	log.Printf("Router RPC running on port %d\n", port)
+	if err := rpcServer.Serve(s); err != nil {
+		log.Printf("Router RPC stopped: %v\n", err)
+	}
+}
+
 // RouterServer Listens for bytes and creates WriteTickets which are
 // sent to the putterRequests channel for DataPutter Nodes to write
 func RunRouterServer(config RouterConfig) error {
 	port := config.Port
 
-	// rpcServer := RegisterRouterServer(rpcServer, &RouterServer{})
-
-	s, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	s, err := Listen(port)
 	if err != nil {
 		return err
 	}
 	log.Printf("PutterRouter running on port %d\n", port)
+
+	go serveRouterRPC(routerRPCPort, config)
+	go serveRouterHTTP(routerHTTPPort, config)
+
+	// Shredded objects have their remaining bytes deleted in the background
+	go RunShredCleanup(time.Minute)
+	// Abandoned uploads are deleted after ROUTER_GC_TTL
+	go RunGC(time.Minute)
+
 	for {
 		conn, err := s.Accept()
 		if err != nil {
 			log.Printf("Error in connection: %v\n", err)
 			continue
 		}
-		// Handle a request to store a file/bunch-of-bytes somewhere
-		go DoCreateObject(conn, config)
+		// Handle a request to store a file/bunch-of-bytes somewhere, once
+		// there's room for it
+		go admitCreateObject(conn, config)
 	}
 }
 
 // putterRequestHandler : Handles a single TCP connection creating an
 // ObjectID for the file and then WriteTickets for each byte region.
-// When it has written all the bytes sent, it will reply with the 8 Byte
-// ObjectID it has assigned.
+// When it has written all the bytes sent, it will reply with the IDLength
+// Byte ObjectID it has assigned.
 func DoCreateObject(c net.Conn, config RouterConfig) error {
 	defer c.Close()
 
-	// Specific header prefix for Delete requests which are handled synchronously
-	deleteRequestHeader := []byte(DELETE_HEADER)
-
 	// [8B size][1450B data]
 	// Limits requests to 16 GB
 	// ContentLength must be bigEndian
 	contentLenBuf := make([]byte, 8)
-	if _, err := c.Read(contentLenBuf); err != nil {
+	if _, err := io.ReadFull(c, contentLenBuf); err != nil {
 		log.Printf("Unable to get the content length for request %s\n", err)
 		log.Printf("\tReceived: %s\n", string(contentLenBuf))
 		return err
 	}
 	// log.Printf("Read contentLen as %s\n", string(contentLenBuf))
 
-	// Delete an object
-	// [8B Delete Header][8B ObjectID][16B PSK / Authenticity Token]
-	if string(contentLenBuf) == string(deleteRequestHeader) {
-		log.Printf("Handling delete request\n")
-		defer c.Close()
-		objectIDBuf := make([]byte, 8)
-		_, err := c.Read(objectIDBuf)
+	// Requests without an authenticity token name their API key first
+	var tenant string
+	authenticated := false
+	if string(contentLenBuf) == AUTH_HEADER {
+		var err error
+		tenant, err = readAPIKey(c)
+		if err == nil {
+			_, err = io.ReadFull(c, contentLenBuf)
+		}
 		if err != nil {
-			log.Printf("Unable to read delete request objectID: %v\n", err)
+			log.Printf("Unable to authenticate request: %v\n", err)
 			c.Write([]byte("_FAILED_"))
+			Audit(AuditEntry{Operation: AuditCreate}, err)
 			return err
 		}
+		authenticated = true
+	}
+	if err := AllowRequest(tenant); err != nil {
+		log.Printf("Refused a request of %s: %v\n", tenant, err)
+		c.Write(limitReply(err))
+		return err
+	}
 
-		log.Printf("Handling a delete request for objectID: %s\n", string(objectIDBuf))
+	// Specific header prefixes for Delete requests which are handled synchronously
+	switch string(contentLenBuf) {
+	case DELETE_HEADER:
+		return doDeleteObject(c, false)
+	case SHRED_HEADER:
+		return doDeleteObject(c, true)
+	}
+	if authRequired && !authenticated {
+		log.Printf("Refused a create request without an API key\n")
+		c.Write([]byte("_FAILED_"))
+		Audit(AuditEntry{Operation: AuditCreate}, ErrUnauthenticated)
+		return ErrUnauthenticated
+	}
 
-		// DeleteTicketHandler
-		tickets, err := DeleteObject(
-			string(objectIDBuf),
-		)
+	// Objects stored under a key of a bucket name it before their size
+	// [8B Bucket Header][2B len][Bucket][2B len][Key][8B size][data]
+	var bucket Bucket
+	var key string
+	if string(contentLenBuf) == BUCKET_HEADER {
+		bucketName, err := readBucketKeyPart(c)
+		if err == nil {
+			key, err = readBucketKeyPart(c)
+		}
+		if err == nil {
+			err = validateBucketKey(key)
+		}
+		if err == nil {
+			bucket, err = GetBucket(bucketName)
+		}
+		if err == nil {
+			err = AuthorizeBucket(tenant, bucket, key, AccessWrite)
+		}
+		if err == nil {
+			_, err = io.ReadFull(c, contentLenBuf)
+		}
 		if err != nil {
-			log.Printf("Failed to delete %s: %v\n", string(objectIDBuf), err)
+			log.Printf("Unable to read bucket and key of request: %v\n", err)
 			c.Write([]byte("_FAILED_"))
+			Audit(AuditEntry{Operation: AuditCreate, Tenant: tenant}, err)
 			return err
 		}
-		c.Write(objectIDBuf)
-		for _, ticket := range tickets {
-			log.Printf("Deleted %s\n", ticket)
-		}
-		return nil
 	}
 
 	contentLength := int64(binary.BigEndian.Uint64(contentLenBuf))
+	return createObject(c, config, tenant, bucket, key, contentLength)
+}
+
+// createObject Stores contentLength bytes read from c as a new object of the
+// tenant, under the key of bucket when it has a name, and replies with its
+// ObjectID
+func createObject(c io.ReadWriteCloser, config RouterConfig, tenant string, bucket Bucket, key string, contentLength int64) (err error) {
+	var objectID []byte
+	defer func() {
+		Audit(AuditEntry{Operation: AuditCreate, Tenant: tenant, ObjectID: string(objectID)}, err)
+	}()
+
+	// Uploads are limited and reserve their size against the tenant's quota
+	// before any tickets are issued
+	release, err := AcquireUpload(tenant)
+	if err != nil {
+		log.Printf("Refused an upload of %s: %v\n", tenant, err)
+		c.Write(limitReply(err))
+		return err
+	}
+	defer release()
+	if err = ReserveQuota(tenant, contentLength); err != nil {
+		log.Printf("Refused a %d-byte upload of %s: %v\n", contentLength, tenant, err)
+		c.Write(limitReply(err))
+		return err
+	}
+	// The reservation is kept once the object has an owner
+	owned := false
+	defer func() {
+		if !owned {
+			ReleaseQuota(tenant, contentLength)
+		}
+	}()
 
 	// Grant a new ObjectID for this TCP connection / file
-	objectID := NextObjectID()
+	objectID, err = NextObjectID()
+	if err != nil {
+		log.Printf("Unable to grant an ObjectID: %v\n", err)
+		c.Write([]byte("_FAILED_"))
+		return err
+	}
 
 	log.Printf("Handling router connection for %d-byte Object: %s\n", contentLength, string(objectID))
 
+	// Objects encrypted at rest have their own data key which is sent with each ticket
+	dataKey, err := CreateObjectDataKey(string(objectID))
+	if err != nil {
+		log.Printf("Unable to create data key for Object %s: %v\n", string(objectID), err)
+		c.Write([]byte("_FAILED_"))
+		return err
+	}
+
 	log.Printf("Opening %d bytes of content from Object %s\n", contentLength, string(objectID))
 	var n int
-	var err error
 	var objBytesCnt = int64(0)
+	// Checksum of the object bytes as they were sent
+	checksum := sha256.New()
 
 	var writeInProgress sync.WaitGroup
 	writeInProgress.Add(1)
@@ -131,14 +579,26 @@ func DoCreateObject(c net.Conn, config RouterConfig) error {
 	// Consumers waiting on Object write to complete
 	writeWaiters := make(chan CounterEvent, 2)
 
+	// Split the bytes of the object into tickets
+	chunker, err := NewChunker(limitReader(tenant, c), contentLength)
+	if err != nil {
+		log.Printf("Unable to chunk Object %s: %v\n", string(objectID), err)
+		return err
+	}
+
 	// Write regions of bytes for this object
+	addresses := nodeAddresses(config)
 	nodeIndex := 0
 	for {
 		log.Printf("-- -- --\n")
-		dataStream := make([]byte, 1450)
-		ticketID := NextTicketID()
+		ticketID, err := NextTicketID()
+		if err != nil {
+			log.Printf("Unable to grant a TicketID of Object %s: %v\n", string(objectID), err)
+			return err
+		}
 		log.Printf("Trying to read bytes from Object %s stream\n", string(objectID))
-		n, err = c.Read(dataStream)
+		dataStream, err := chunker.Next()
+		n = len(dataStream)
 		log.Printf("\tRead %d bytes from Object %s stream\n", n, string(objectID))
 		if err != nil && err != io.EOF {
 			log.Printf("Error reading bytes from %d onward: %v\n", objBytesCnt, err)
@@ -158,6 +618,23 @@ func DoCreateObject(c net.Conn, config RouterConfig) error {
 			ByteEnd:   objBytesCnt + int64(n),
 			ByteCount: int64(n),
 			Data:      dataStream,
+			DataKey:   dataKey,
+			// Empty outside of a bucket uses the node default
+			Compression: bucket.Compression,
+		}
+
+		// Chunks already stored by another object are referenced instead of written again
+		var contentHash, duplicateTicketID string
+		if dedupEnabled && dataKey == nil {
+			contentHash = DedupHash(tenant, dataStream)
+			duplicateTicketID, err = FindDuplicateTicket(writeRequest.ObjectId, contentHash)
+			if err != nil {
+				log.Printf("Unable to look up duplicate tickets of %s: %v\n", writeRequest.ObjectId, err)
+				return err
+			}
+			if len(duplicateTicketID) > 0 {
+				writeRequest.TicketId = duplicateTicketID
+			}
 		}
 
 		// Create a new object
@@ -174,58 +651,41 @@ func DoCreateObject(c net.Conn, config RouterConfig) error {
 		}
 
 		// Counter of Tickets assigned to the Object
-		_, err := TouchTicketCounter(writeRequest.ObjectId)
-
-		// TODO: Should use service lookup to find nodes
-		// during each segment
-		nodeConfig := config.Nodes[nodeIndex]
-		nodeAddress := fmt.Sprintf("%s:%d", nodeConfig.Host, nodeConfig.Port)
-		fmt.Println("Creating nodeClient")
-		nodeClient, err := NewClient(nodeAddress)
-		fmt.Println("Created nodeClient")
-		if err != nil {
-			log.Printf("Unable to create NodeClient: %v\n", err)
-			return err
-		}
-		defer nodeClient.Close()
-
-		// Write the data to some node
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		response, err := nodeClient.Write(ctx, &writeRequest)
-		defer cancel()
-		log.Printf("TicketWriteResponse for %s of %s: %d\n", response.TicketId, response.ObjectId, response.Status)
-		if err != nil {
-			log.Printf("Error writing ticket %s of %s to NodeWriter: %v\n",
-				writeRequest.TicketId,
-				writeRequest.ObjectId,
-				err)
-
-		}
-		// Use the next node for the next ticket
-		if nodeIndex < len(config.Nodes)-1 {
-			nodeIndex++
+		_, err = TouchTicketCounter(writeRequest.ObjectId)
+
+		if len(duplicateTicketID) > 0 {
+			log.Printf("Referencing ticket %s for bytes %d of %s\n", duplicateTicketID, writeRequest.ByteStart, writeRequest.ObjectId)
+			err = AddTicketReference(writeRequest.ObjectId, duplicateTicketID, writeRequest.ByteStart)
+			if err != nil {
+				log.Printf("Unable to reference ticket %s: %v\n", duplicateTicketID, err)
+				return err
+			}
 		} else {
-			nodeIndex = 0
-		}
-
-		if response.Status != 0 {
-			log.Printf("Error writing ticket %s of %s, got status %d\n",
-				writeRequest.TicketId,
-				writeRequest.ObjectId,
-				response.Status)
-		}
-
-		// Create the ticket in the datastore on the response
-		err = CreateTicket(response.TicketId, response.ObjectId, response.NodeId, response.ByteStart, response.ByteEnd, response.ByteCount)
-		if err != nil {
-			log.Printf("Unable to save ticket to datastore: %v\n", err)
-			return err
+			// TODO: Should use service lookup to find nodes
+			// during each segment
+			response, index, err := writeTicketToNodes(addresses, nodeIndex, &writeRequest)
+			if err != nil {
+				if err := SetObjectStatus(writeRequest.ObjectId, ObjectStatus[ObjectError]); err != nil {
+					log.Printf("Unable to put object %s in error status: %v\n", writeRequest.ObjectId, err)
+				}
+				return err
+			}
+			// Use the node after the one written to for the next ticket
+			nodeIndex = (index + 1) % len(addresses)
+
+			if len(contentHash) > 0 {
+				if err := IndexTicketHash(response.TicketId, contentHash); err != nil {
+					log.Printf("Unable to index hash of ticket %s: %v\n", response.TicketId, err)
+					return err
+				}
+			}
 		}
 		objBytesCnt += int64(n)
+		checksum.Write(dataStream)
 		log.Printf("[%d/%d] Read %d of %d bytes\n", objBytesCnt, contentLength, n, contentLength)
-		_, err = TouchWriteCounter(response.ObjectId)
+		_, err = TouchWriteCounter(writeRequest.ObjectId)
 		if err != nil {
-			log.Printf("Unable to update write counter of object %s: %v\n", response.ObjectId, err)
+			log.Printf("Unable to update write counter of object %s: %v\n", writeRequest.ObjectId, err)
 			return err
 		}
 		if objBytesCnt == contentLength {
@@ -246,7 +706,31 @@ func DoCreateObject(c net.Conn, config RouterConfig) error {
 	writeInProgress.Wait()
 	// close(writeWaiters)
 	SetObjectByteSize(string(objectID), objBytesCnt)
+	if err := SetObjectChecksum(string(objectID), hex.EncodeToString(checksum.Sum(nil))); err != nil {
+		log.Printf("Unable to save checksum of Object %s: %v\n", string(objectID), err)
+		c.Write([]byte("_FAILED_"))
+		return err
+	}
+	if len(tenant) > 0 {
+		if err := SetObjectOwner(string(objectID), tenant); err != nil {
+			log.Printf("Unable to save owner of Object %s: %v\n", string(objectID), err)
+			c.Write([]byte("_FAILED_"))
+			return err
+		}
+		owned = true
+		AdjustQuota(tenant, objBytesCnt-contentLength)
+	}
 	log.Printf("Persisted all %d bytes of Object %s\n", objBytesCnt, string(objectID))
+	if err := SetObjectStatus(string(objectID), ObjectStatus[ObjectSaved]); err != nil {
+		log.Printf("Unable to put object %s in saved status: %v\n", string(objectID), err)
+		return err
+	}
+	if len(bucket.Name) > 0 {
+		if err := storeObjectKey(bucket.Name, key, string(objectID)); err != nil {
+			c.Write([]byte("_FAILED_"))
+			return err
+		}
+	}
 
 	// Send the created objectID to the client
 	n, err = c.Write(objectID)
@@ -259,6 +743,169 @@ func DoCreateObject(c net.Conn, config RouterConfig) error {
 	return c.Close()
 }
 
+// readBucketKeyPart Reads a bucket name or key preceded by its 2 byte
+// big endian length
+func readBucketKeyPart(c net.Conn) (string, error) {
+	lengthBuf := make([]byte, 2)
+	if _, err := io.ReadFull(c, lengthBuf); err != nil {
+		return "", err
+	}
+	part := make([]byte, binary.BigEndian.Uint16(lengthBuf))
+	if _, err := io.ReadFull(c, part); err != nil {
+		return "", err
+	}
+	return string(part), nil
+}
+
+// storeObjectKey Points a key at a saved object and deletes the object it
+// replaced
+func storeObjectKey(bucketName, key, objectID string) error {
+	previous, err := PutObjectKey(bucketName, key, objectID)
+	if err != nil {
+		log.Printf("Unable to store %s as %s/%s: %v\n", objectID, bucketName, key, err)
+		return err
+	}
+	if len(previous) > 0 && previous != objectID {
+		_, err := DeleteObject(previous)
+		if err != nil {
+			log.Printf("Unable to delete %s replaced by %s/%s: %v\n", previous, bucketName, key, err)
+		}
+		Audit(AuditEntry{Operation: AuditDelete, Reason: AuditReplaced, ObjectID: previous}, err)
+	}
+	return nil
+}
+
+// writeTicketToNode: Sends the bytes of a ticket to a WriteNode and creates
+// the ticket in the datastore from its response
+func writeTicketToNode(nodeAddress string, writeRequest *NodeWriteRequest) (*NodeResponse, error) {
+	nodeClient, err := dialWriteNode(nodeAddress)
+	if err != nil {
+		log.Printf("Unable to create NodeClient: %v\n", err)
+		return nil, err
+	}
+	defer nodeClient.Close()
+
+	// Write the data to some node
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	response, err := nodeClient.Write(ctx, writeRequest)
+	defer cancel()
+	if err != nil {
+		log.Printf("Error writing ticket %s of %s to NodeWriter: %v\n",
+			writeRequest.TicketId,
+			writeRequest.ObjectId,
+			err)
+		if isNodeBusy(nil, err) {
+			return nil, ErrNodeBusy
+		}
+		return nil, err
+	}
+	log.Printf("TicketWriteResponse for %s of %s: %d\n", response.TicketId, response.ObjectId, response.Status)
+	// Busy nodes didn't take the write, it's redirected without a ticket
+	if isNodeBusy(response, nil) {
+		return response, ErrNodeBusy
+	}
+
+	// Create the ticket in the datastore on the response
+	err = CreateTicket(response.TicketId, response.ObjectId, response.NodeId, response.ByteStart, response.ByteEnd, response.ByteCount)
+	if err != nil {
+		log.Printf("Unable to save ticket to datastore: %v\n", err)
+		return response, err
+	}
+
+	if response.Status != 0 {
+		log.Printf("Error writing ticket %s of %s, got status %d\n",
+			writeRequest.TicketId,
+			writeRequest.ObjectId,
+			response.Status)
+		if err := SetTicketStatus(response.TicketId, TicketStatus[TicketError]); err != nil {
+			log.Printf("Unable to put ticket %s in error status: %v\n", response.TicketId, err)
+		}
+		return response, fmt.Errorf("WriteNode %s failed ticket %s with status %d\n", response.NodeId, response.TicketId, response.Status)
+	}
+	if err := SetTicketStatus(response.TicketId, TicketStatus[TicketSaved]); err != nil {
+		log.Printf("Unable to put ticket %s in saved status: %v\n", response.TicketId, err)
+		return response, err
+	}
+	if response.StoredByteCount > 0 {
+		err = SetTicketStoredByteCount(response.ObjectId, response.TicketId, response.StoredByteCount, response.Compression)
+		if err != nil {
+			log.Printf("Unable to save stored size of ticket %s: %v\n", response.TicketId, err)
+			return response, err
+		}
+	}
+	return response, nil
+}
+
+// doDeleteObject: Handles a delete request, replying with the ObjectID
+// once it's deleted. Shredding destroys the object's data key before its
+// bytes are deleted.
+//
+// Delete an object
+// [8B Delete Header][ObjectID][16B API Key]
+//
+// Shred an object
+// [8B Shred Header][ObjectID][16B API Key]
+//
+// The ObjectID is sent as WireID. The API key is only read when ROUTER_AUTH
+// is set.
+func doDeleteObject(c net.Conn, shred bool) (err error) {
+	log.Printf("Handling delete request\n")
+	audit := AuditEntry{Operation: AuditDelete}
+	if shred {
+		audit.Operation = AuditShred
+	}
+	defer func() { Audit(audit, err) }()
+
+	objectID, err := readWireID(c)
+	if err != nil {
+		log.Printf("Unable to read delete request objectID: %v\n", err)
+		c.Write([]byte("_FAILED_"))
+		return err
+	}
+	audit.ObjectID = objectID
+	if authRequired {
+		var tenant string
+		tenant, err = readAPIKey(c)
+		audit.Tenant = tenant
+		if err == nil {
+			err = AuthorizeObject(tenant, objectID, AccessWrite)
+		}
+		if err != nil {
+			log.Printf("Refused to delete %s: %v\n", objectID, err)
+			c.Write([]byte("_FAILED_"))
+			return err
+		}
+	}
+
+	if shred {
+		log.Printf("Handling a shred request for objectID: %s\n", objectID)
+		if err := ShredObject(objectID); err != nil {
+			log.Printf("Failed to shred %s: %v\n", objectID, err)
+			c.Write([]byte("_FAILED_"))
+			return err
+		}
+		c.Write(WireID(objectID))
+		return nil
+	}
+
+	log.Printf("Handling a delete request for objectID: %s\n", objectID)
+
+	// DeleteTicketHandler
+	tickets, err := DeleteObject(
+		objectID,
+	)
+	if err != nil {
+		log.Printf("Failed to delete %s: %v\n", objectID, err)
+		c.Write([]byte("_FAILED_"))
+		return err
+	}
+	c.Write(WireID(objectID))
+	for _, ticket := range tickets {
+		log.Printf("Deleted %s\n", ticket)
+	}
+	return nil
+}
+
 func spinWhileObjectWriting(objectID string, countEvents chan CounterEvent, wg *sync.WaitGroup) {
 
 	log.Printf("Wait on object write to complete")
@@ -19,6 +19,17 @@ const _ = grpc.SupportPackageIsVersion7
 type RouterClient interface {
 	CreateObject(ctx context.Context, in *CreateObjectRequest, opts ...grpc.CallOption) (*ObjectActionResponse, error)
 	DeleteObject(ctx context.Context, in *DeleteObjectRequest, opts ...grpc.CallOption) (*ObjectActionResponse, error)
+	ListObjects(ctx context.Context, in *ListObjectsRequest, opts ...grpc.CallOption) (*ListObjectsResponse, error)
+	StatObject(ctx context.Context, in *StatObjectRequest, opts ...grpc.CallOption) (*StatObjectResponse, error)
+	CreateBucket(ctx context.Context, in *CreateBucketRequest, opts ...grpc.CallOption) (*BucketResponse, error)
+	DeleteBucket(ctx context.Context, in *DeleteBucketRequest, opts ...grpc.CallOption) (*BucketResponse, error)
+	ListBuckets(ctx context.Context, in *ListBucketsRequest, opts ...grpc.CallOption) (*ListBucketsResponse, error)
+	ListBucketKeys(ctx context.Context, in *ListBucketKeysRequest, opts ...grpc.CallOption) (*ListBucketKeysResponse, error)
+	GetBucketKey(ctx context.Context, in *BucketKeyRequest, opts ...grpc.CallOption) (*BucketKeyResponse, error)
+	DeleteBucketKey(ctx context.Context, in *BucketKeyRequest, opts ...grpc.CallOption) (*BucketKeyResponse, error)
+	PutBucketPolicy(ctx context.Context, in *BucketPolicyRequest, opts ...grpc.CallOption) (*BucketPolicyResponse, error)
+	GetBucketPolicy(ctx context.Context, in *BucketPolicyRequest, opts ...grpc.CallOption) (*BucketPolicyResponse, error)
+	PresignObject(ctx context.Context, in *PresignRequest, opts ...grpc.CallOption) (*PresignResponse, error)
 }
 
 type routerClient struct {
@@ -47,12 +58,122 @@ func (c *routerClient) DeleteObject(ctx context.Context, in *DeleteObjectRequest
 	return out, nil
 }
 
+func (c *routerClient) ListObjects(ctx context.Context, in *ListObjectsRequest, opts ...grpc.CallOption) (*ListObjectsResponse, error) {
+	out := new(ListObjectsResponse)
+	err := c.cc.Invoke(ctx, "/Router/ListObjects", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) StatObject(ctx context.Context, in *StatObjectRequest, opts ...grpc.CallOption) (*StatObjectResponse, error) {
+	out := new(StatObjectResponse)
+	err := c.cc.Invoke(ctx, "/Router/StatObject", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) CreateBucket(ctx context.Context, in *CreateBucketRequest, opts ...grpc.CallOption) (*BucketResponse, error) {
+	out := new(BucketResponse)
+	err := c.cc.Invoke(ctx, "/Router/CreateBucket", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) DeleteBucket(ctx context.Context, in *DeleteBucketRequest, opts ...grpc.CallOption) (*BucketResponse, error) {
+	out := new(BucketResponse)
+	err := c.cc.Invoke(ctx, "/Router/DeleteBucket", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) ListBuckets(ctx context.Context, in *ListBucketsRequest, opts ...grpc.CallOption) (*ListBucketsResponse, error) {
+	out := new(ListBucketsResponse)
+	err := c.cc.Invoke(ctx, "/Router/ListBuckets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) ListBucketKeys(ctx context.Context, in *ListBucketKeysRequest, opts ...grpc.CallOption) (*ListBucketKeysResponse, error) {
+	out := new(ListBucketKeysResponse)
+	err := c.cc.Invoke(ctx, "/Router/ListBucketKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) GetBucketKey(ctx context.Context, in *BucketKeyRequest, opts ...grpc.CallOption) (*BucketKeyResponse, error) {
+	out := new(BucketKeyResponse)
+	err := c.cc.Invoke(ctx, "/Router/GetBucketKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) DeleteBucketKey(ctx context.Context, in *BucketKeyRequest, opts ...grpc.CallOption) (*BucketKeyResponse, error) {
+	out := new(BucketKeyResponse)
+	err := c.cc.Invoke(ctx, "/Router/DeleteBucketKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) PutBucketPolicy(ctx context.Context, in *BucketPolicyRequest, opts ...grpc.CallOption) (*BucketPolicyResponse, error) {
+	out := new(BucketPolicyResponse)
+	err := c.cc.Invoke(ctx, "/Router/PutBucketPolicy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) GetBucketPolicy(ctx context.Context, in *BucketPolicyRequest, opts ...grpc.CallOption) (*BucketPolicyResponse, error) {
+	out := new(BucketPolicyResponse)
+	err := c.cc.Invoke(ctx, "/Router/GetBucketPolicy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) PresignObject(ctx context.Context, in *PresignRequest, opts ...grpc.CallOption) (*PresignResponse, error) {
+	out := new(PresignResponse)
+	err := c.cc.Invoke(ctx, "/Router/PresignObject", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RouterServer is the server API for Router service.
 // All implementations must embed UnimplementedRouterServer
 // for forward compatibility
 type RouterServer interface {
 	CreateObject(context.Context, *CreateObjectRequest) (*ObjectActionResponse, error)
 	DeleteObject(context.Context, *DeleteObjectRequest) (*ObjectActionResponse, error)
+	ListObjects(context.Context, *ListObjectsRequest) (*ListObjectsResponse, error)
+	StatObject(context.Context, *StatObjectRequest) (*StatObjectResponse, error)
+	CreateBucket(context.Context, *CreateBucketRequest) (*BucketResponse, error)
+	DeleteBucket(context.Context, *DeleteBucketRequest) (*BucketResponse, error)
+	ListBuckets(context.Context, *ListBucketsRequest) (*ListBucketsResponse, error)
+	ListBucketKeys(context.Context, *ListBucketKeysRequest) (*ListBucketKeysResponse, error)
+	GetBucketKey(context.Context, *BucketKeyRequest) (*BucketKeyResponse, error)
+	DeleteBucketKey(context.Context, *BucketKeyRequest) (*BucketKeyResponse, error)
+	PutBucketPolicy(context.Context, *BucketPolicyRequest) (*BucketPolicyResponse, error)
+	GetBucketPolicy(context.Context, *BucketPolicyRequest) (*BucketPolicyResponse, error)
+	PresignObject(context.Context, *PresignRequest) (*PresignResponse, error)
 	mustEmbedUnimplementedRouterServer()
 }
 
@@ -66,6 +187,39 @@ func (UnimplementedRouterServer) CreateObject(context.Context, *CreateObjectRequ
 func (UnimplementedRouterServer) DeleteObject(context.Context, *DeleteObjectRequest) (*ObjectActionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteObject not implemented")
 }
+func (UnimplementedRouterServer) ListObjects(context.Context, *ListObjectsRequest) (*ListObjectsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListObjects not implemented")
+}
+func (UnimplementedRouterServer) StatObject(context.Context, *StatObjectRequest) (*StatObjectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StatObject not implemented")
+}
+func (UnimplementedRouterServer) CreateBucket(context.Context, *CreateBucketRequest) (*BucketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateBucket not implemented")
+}
+func (UnimplementedRouterServer) DeleteBucket(context.Context, *DeleteBucketRequest) (*BucketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteBucket not implemented")
+}
+func (UnimplementedRouterServer) ListBuckets(context.Context, *ListBucketsRequest) (*ListBucketsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBuckets not implemented")
+}
+func (UnimplementedRouterServer) ListBucketKeys(context.Context, *ListBucketKeysRequest) (*ListBucketKeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBucketKeys not implemented")
+}
+func (UnimplementedRouterServer) GetBucketKey(context.Context, *BucketKeyRequest) (*BucketKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBucketKey not implemented")
+}
+func (UnimplementedRouterServer) DeleteBucketKey(context.Context, *BucketKeyRequest) (*BucketKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteBucketKey not implemented")
+}
+func (UnimplementedRouterServer) PutBucketPolicy(context.Context, *BucketPolicyRequest) (*BucketPolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutBucketPolicy not implemented")
+}
+func (UnimplementedRouterServer) GetBucketPolicy(context.Context, *BucketPolicyRequest) (*BucketPolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBucketPolicy not implemented")
+}
+func (UnimplementedRouterServer) PresignObject(context.Context, *PresignRequest) (*PresignResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PresignObject not implemented")
+}
 func (UnimplementedRouterServer) mustEmbedUnimplementedRouterServer() {}
 
 // UnsafeRouterServer may be embedded to opt out of forward compatibility for this service.
@@ -115,6 +269,204 @@ func _Router_DeleteObject_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Router_ListObjects_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListObjectsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).ListObjects(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/ListObjects",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).ListObjects(ctx, req.(*ListObjectsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_StatObject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatObjectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).StatObject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/StatObject",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).StatObject(ctx, req.(*StatObjectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_CreateBucket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateBucketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).CreateBucket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/CreateBucket",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).CreateBucket(ctx, req.(*CreateBucketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_DeleteBucket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteBucketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).DeleteBucket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/DeleteBucket",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).DeleteBucket(ctx, req.(*DeleteBucketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_ListBuckets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBucketsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).ListBuckets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/ListBuckets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).ListBuckets(ctx, req.(*ListBucketsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_ListBucketKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBucketKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).ListBucketKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/ListBucketKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).ListBucketKeys(ctx, req.(*ListBucketKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_GetBucketKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BucketKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).GetBucketKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/GetBucketKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).GetBucketKey(ctx, req.(*BucketKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_DeleteBucketKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BucketKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).DeleteBucketKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/DeleteBucketKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).DeleteBucketKey(ctx, req.(*BucketKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_PutBucketPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BucketPolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).PutBucketPolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/PutBucketPolicy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).PutBucketPolicy(ctx, req.(*BucketPolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_GetBucketPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BucketPolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).GetBucketPolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/GetBucketPolicy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).GetBucketPolicy(ctx, req.(*BucketPolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_PresignObject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PresignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).PresignObject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Router/PresignObject",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).PresignObject(ctx, req.(*PresignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Router_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Router",
 	HandlerType: (*RouterServer)(nil),
@@ -127,6 +479,50 @@ var _Router_serviceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteObject",
 			Handler:    _Router_DeleteObject_Handler,
 		},
+		{
+			MethodName: "ListObjects",
+			Handler:    _Router_ListObjects_Handler,
+		},
+		{
+			MethodName: "StatObject",
+			Handler:    _Router_StatObject_Handler,
+		},
+		{
+			MethodName: "CreateBucket",
+			Handler:    _Router_CreateBucket_Handler,
+		},
+		{
+			MethodName: "DeleteBucket",
+			Handler:    _Router_DeleteBucket_Handler,
+		},
+		{
+			MethodName: "ListBuckets",
+			Handler:    _Router_ListBuckets_Handler,
+		},
+		{
+			MethodName: "ListBucketKeys",
+			Handler:    _Router_ListBucketKeys_Handler,
+		},
+		{
+			MethodName: "GetBucketKey",
+			Handler:    _Router_GetBucketKey_Handler,
+		},
+		{
+			MethodName: "DeleteBucketKey",
+			Handler:    _Router_DeleteBucketKey_Handler,
+		},
+		{
+			MethodName: "PutBucketPolicy",
+			Handler:    _Router_PutBucketPolicy_Handler,
+		},
+		{
+			MethodName: "GetBucketPolicy",
+			Handler:    _Router_GetBucketPolicy_Handler,
+		},
+		{
+			MethodName: "PresignObject",
+			Handler:    _Router_PresignObject_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "dataputter/router.proto",
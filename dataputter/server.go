@@ -2,14 +2,45 @@
 //
 // A Server, or PutterNode, is responsible for writing
 // the data from WriteTickets to disk
+//
+// 	[13B TicketID][8B Checksum][nB Data] : Queued, compressed with NODE_COMPRESSION
+// 	[8B "0PUT0PUT"][TicketID][8B Checksum][1B length][Compression][1B length][DataKey][8B Size][nB Data]
+// 		-> [8B Stored Size][1B length][Codec]
+// 	[8B "0GET0GET"][TicketID][1B length][DataKey] -> [8B Size][nB Data]
+//
+// TicketIDs of the put and get requests are sent as WireID, sizes are big
+// endian. Tickets put with a DataKey are encrypted with it, the node never
+// stores the key. Requests which fail are answered with _FAILED_, or
+// _DECRYPT when the DataKey can't decrypt the ticket, which no size up to
+// MaxTicketBytes starts with.
 package dataputter
 
 import (
+	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 )
 
+const (
+	// NODE_PUT_HEADER Stores a ticket with the codec it names
+	NODE_PUT_HEADER = "0PUT0PUT"
+	// NODE_GET_HEADER Reads a ticket back as it was sent
+	NODE_GET_HEADER = "0GET0GET"
+	// REPLY_FAILED Reply to a put or get which failed
+	REPLY_FAILED = "_FAILED_"
+	// REPLY_DECRYPT_FAILED Reply to a get the DataKey can't decrypt
+	REPLY_DECRYPT_FAILED = "_DECRYPT"
+	// MaxTicketBytes Most bytes of data a put may send
+	MaxTicketBytes = 1 << 24
+)
+
+// ErrTicketTooLarge A put sent more than MaxTicketBytes
+var ErrTicketTooLarge = errors.New("Ticket data is too large")
+
 // WriteTicketHandler Receives WriteTickets and writes them serially to disk
 func WriteTicketHandler(work chan WriteTicket) {
 	for wt := range work {
@@ -23,13 +54,13 @@ func WriteTicketHandler(work chan WriteTicket) {
 	}
 }
 
-// [8B TicketID][8B Checksum][nB Data]
+// [13B TicketID][8B Checksum][nB Data]
 // Data should be 1458 Bytes for best results
 func parseTicketRequest(b []byte) WriteTicket {
 	return WriteTicket{
-		b[0:8],
-		b[8:16],
-		b[16:],
+		TicketID: b[0:IDLength],
+		Checksum: b[IDLength : IDLength+8],
+		Data:     b[IDLength+8:],
 	}
 }
 
@@ -37,10 +68,24 @@ func parseTicketRequest(b []byte) WriteTicket {
 func handleConnection(c net.Conn, intake chan WriteTicket) {
 	defer c.Close()
 	fmt.Println("Handling connection")
+	r := bufio.NewReaderSize(c, 1500)
+	header, err := r.Peek(len(NODE_PUT_HEADER))
+	if err == nil {
+		switch string(header) {
+		case NODE_PUT_HEADER:
+			r.Discard(len(header))
+			servePutTicket(c, r)
+			return
+		case NODE_GET_HEADER:
+			r.Discard(len(header))
+			serveGetTicket(c, r)
+			return
+		}
+	}
 	ticketRequest := make([]byte, 1500)
 	// Read until nil
-	n, err := c.Read(ticketRequest)
-	if n < 16 {
+	n, err := r.Read(ticketRequest)
+	if n < IDLength+8 {
 		err = fmt.Errorf("Too few bytes %d", n)
 	}
 	log.Printf("Read %d byte WriteTicket\n", n)
@@ -54,9 +99,109 @@ func handleConnection(c net.Conn, intake chan WriteTicket) {
 	}
 }
 
+// readShortField Reads bytes preceded by their 1 byte length
+func readShortField(r io.Reader) ([]byte, error) {
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+	field := make([]byte, length[0])
+	_, err := io.ReadFull(r, field)
+	return field, err
+}
+
+// readPutRequest Reads a WriteTicket sent after NODE_PUT_HEADER
+func readPutRequest(r io.Reader) (WriteTicket, error) {
+	wt := WriteTicket{Checksum: make([]byte, 8)}
+	ticketID, err := readWireID(r)
+	if err != nil {
+		return wt, err
+	}
+	wt.TicketID = []byte(ticketID)
+	if _, err := io.ReadFull(r, wt.Checksum); err != nil {
+		return wt, err
+	}
+	compression, err := readShortField(r)
+	if err != nil {
+		return wt, err
+	}
+	wt.Compression = string(compression)
+	if len(wt.Compression) > 0 && !IsCompressionCodec(wt.Compression) {
+		return wt, fmt.Errorf("Unknown compression %s of ticket %s\n", wt.Compression, ticketID)
+	}
+	if wt.DataKey, err = readShortField(r); err != nil {
+		return wt, err
+	}
+
+	size := make([]byte, 8)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return wt, err
+	}
+	if binary.BigEndian.Uint64(size) > MaxTicketBytes {
+		return wt, ErrTicketTooLarge
+	}
+	wt.Data = make([]byte, binary.BigEndian.Uint64(size))
+	_, err = io.ReadFull(r, wt.Data)
+	return wt, err
+}
+
+// servePutTicket Stores a ticket right away and replies with the bytes
+// written to disk and the codec used
+func servePutTicket(c io.Writer, r io.Reader) error {
+	wt, err := readPutRequest(r)
+	if err != nil {
+		log.Printf("Unable to read ticket to put: %v\n", err)
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+	storedByteCount, codec, err := wt.Store(wt.Compression, wt.DataKey)
+	if err != nil {
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+
+	reply := make([]byte, 8, 9+len(codec))
+	binary.BigEndian.PutUint64(reply, uint64(storedByteCount))
+	reply = append(append(reply, byte(len(codec))), codec...)
+	_, err = c.Write(reply)
+	return err
+}
+
+// serveGetTicket Replies with the data of a ticket as it was sent
+func serveGetTicket(c io.Writer, r io.Reader) error {
+	ticketID, err := readWireID(r)
+	if err != nil {
+		log.Printf("Unable to read ticket to get: %v\n", err)
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+	dataKey, err := readShortField(r)
+	if err != nil {
+		log.Printf("Unable to read the data key of ticket %s: %v\n", ticketID, err)
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+	data, err := ReadTicket(ticketID, dataKey)
+	if err == ErrDecryptFailed {
+		log.Printf("Unable to decrypt ticket %s\n", ticketID)
+		c.Write([]byte(REPLY_DECRYPT_FAILED))
+		return err
+	}
+	if err != nil {
+		log.Printf("Unable to read ticket %s: %v\n", ticketID, err)
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	_, err = c.Write(append(size, data...))
+	return err
+}
+
 // CreateServer create a TCP server to listen for WriteTickets
 func CreateServer(port string, intake chan WriteTicket) error {
-	s, err := net.Listen("tcp", port)
+	s, err := listenTCP(port)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,487 @@
+// Metadata Snapshots
+//
+// The metadata of every object and ticket can be exported to, and imported
+// from, newline delimited JSON. The first line is a header naming the format
+// and its version, each following line is one record
+//
+// 	{"kind":"header","header":{"format":"dataputter-metadata","version":1,...}}
+// 	{"kind":"object","object":{"id":"ObjectID","status":"saved","tickets":{"TicketID":0},...}}
+// 	{"kind":"ticket","ticket":{"ticket":"TicketID","object":"ObjectID","node":"NodeID",...}}
+// 	{"kind":"bucket","bucket":{"name":"Name","keys":{"Key":"ObjectID"},"policy":[...],...}}
+// 	{"kind":"tenant","tenant":{"tenant":"Tenant","apiKeys":["SHA-256"],"quota":{...},"usage":{...}}}
+// 	{"kind":"counters","counters":{"objectIDCounter":10000,"ticketIDCounter":20000}}
+//
+// Each ticket follows the first object referencing it, then come buckets,
+// tenants and the ID counters. Version 2 added buckets, version 3 tenants,
+// counters and the shredded objects whose bytes still need cleaning up.
+// Snapshots are imported into an empty MetadataStore exactly as they were
+// exported. The ID counters are raised to at least the largest ID imported,
+// so older snapshots without them never have their IDs granted again.
+package dataputter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// SnapshotFormat Names the format in the header of a snapshot
+	SnapshotFormat = "dataputter-metadata"
+	// SnapshotVersion Version of snapshots written by ExportMetadata
+	SnapshotVersion = 3
+)
+
+var (
+	// ErrStoreNotEmpty Snapshots are only imported into an empty store
+	ErrStoreNotEmpty = errors.New("Metadata store already has objects")
+	// ErrNotSnapshot The stream doesn't start with a snapshot header
+	ErrNotSnapshot = errors.New("Not a metadata snapshot")
+)
+
+// SnapshotHeader First line of a snapshot
+type SnapshotHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ObjectRecord Everything known about an object
+type ObjectRecord struct {
+	ObjectID      string `json:"id"`
+	Status        string `json:"status,omitempty"`
+	Size          *int64 `json:"size,omitempty"`
+	StoredSize    int64  `json:"storedSize,omitempty"`
+	DataKey       string `json:"dataKey,omitempty"`
+	ContentType   string `json:"contentType,omitempty"`
+	Checksum      string `json:"checksum,omitempty"`
+	Owner         string `json:"owner,omitempty"`
+	TicketCounter int64  `json:"ticketCounter,omitempty"`
+	WriteCounter  int64  `json:"writeCounter,omitempty"`
+	// CreatedAt: Unix seconds
+	CreatedAt int64 `json:"createdAt,omitempty"`
+	// Tickets: Start byte of each ticket within the object
+	Tickets map[string]int64 `json:"tickets"`
+	Nodes   []string         `json:"nodes"`
+	// PendingCleanup: Shredded, its bytes may remain on nodes
+	PendingCleanup bool `json:"pendingCleanup,omitempty"`
+}
+
+// snapshotLine One line of a snapshot
+type snapshotLine struct {
+	Kind   string          `json:"kind"`
+	Header *SnapshotHeader `json:"header,omitempty"`
+	Object *ObjectRecord   `json:"object,omitempty"`
+	Ticket *Ticket         `json:"ticket,omitempty"`
+	Bucket *BucketRecord   `json:"bucket,omitempty"`
+	Tenant *TenantRecord   `json:"tenant,omitempty"`
+	// Counters: Value of each ID counter
+	Counters map[string]int64 `json:"counters,omitempty"`
+}
+
+// idCounterKeys Counters TicketIDs and ObjectIDs are leased from
+var idCounterKeys = []string{objectIDCounterKey, ticketIDCounterKey}
+
+// BucketRecord A bucket and the ObjectID of each of its keys
+type BucketRecord struct {
+	Bucket
+	Keys   map[string]string `json:"keys"`
+	Policy []Grant           `json:"policy,omitempty"`
+}
+
+// TenantRecord The API keys, by their SHA-256, quota and usage of a tenant
+type TenantRecord struct {
+	Tenant  string   `json:"tenant"`
+	APIKeys []string `json:"apiKeys,omitempty"`
+	Quota   Quota    `json:"quota"`
+	Usage   Quota    `json:"usage"`
+}
+
+// SnapshotReport What an export or import covered
+type SnapshotReport struct {
+	Objects, Tickets, Buckets, Tenants int
+	// Unverified: Tickets which their WriteNode couldn't serve
+	Unverified []string
+}
+
+// GetObjectRecord Collects the metadata of an object
+// * Has Datastore access
+func GetObjectRecord(objectID string) (ObjectRecord, error) {
+	var err error
+	object := ObjectRecord{ObjectID: objectID}
+
+	if object.Status, err = GetObjectStatus(objectID); err != nil {
+		return object, err
+	}
+	// Objects which are still being written have no size
+	if size, err := GetObjectSize(objectID); err == nil {
+		object.Size = &size
+	}
+	if object.StoredSize, err = GetObjectStoredSize(objectID); err != nil {
+		return object, err
+	}
+	if object.DataKey, err = GetObjectDataKey(objectID); err != nil {
+		return object, err
+	}
+	metadata, err := GetObjectMetadata(objectID)
+	if err != nil {
+		return object, err
+	}
+	object.ContentType = metadata.ContentType
+	object.Checksum = metadata.Checksum
+	object.Owner = metadata.Owner
+	createdAt, err := GetObjectCreatedAt(objectID)
+	if err != nil {
+		return object, err
+	}
+	if !createdAt.IsZero() {
+		object.CreatedAt = createdAt.Unix()
+	}
+	if object.TicketCounter, err = GetTicketCounterValue(objectID); err != nil {
+		return object, err
+	}
+	if object.WriteCounter, err = GetWriteCounterValue(objectID); err != nil {
+		return object, err
+	}
+	if object.Tickets, err = GetObjectTicketOffsets(objectID); err != nil {
+		return object, err
+	}
+	object.Nodes, err = GetObjectNodes(objectID)
+	return object, err
+}
+
+// ExportMetadata Streams a snapshot of all metadata to w
+// * Has Datastore access
+func ExportMetadata(w io.Writer) (SnapshotReport, error) {
+	report := SnapshotReport{}
+	encoder := json.NewEncoder(w)
+
+	err := encoder.Encode(snapshotLine{
+		Kind: "header",
+		Header: &SnapshotHeader{
+			Format:    SnapshotFormat,
+			Version:   SnapshotVersion,
+			CreatedAt: time.Now().UTC(),
+		},
+	})
+	if err != nil {
+		return report, err
+	}
+
+	objectIDs, err := GetObjects()
+	if err != nil {
+		return report, err
+	}
+	sort.Strings(objectIDs)
+	shredded, err := GetShreddedObjects()
+	if err != nil {
+		return report, err
+	}
+	pendingCleanup := map[string]bool{}
+	for _, objectID := range shredded {
+		pendingCleanup[objectID] = true
+	}
+
+	exported := map[string]bool{}
+	for _, objectID := range objectIDs {
+		object, err := GetObjectRecord(objectID)
+		if err != nil {
+			return report, fmt.Errorf("Unable to export object %s: %v\n", objectID, err)
+		}
+		object.PendingCleanup = pendingCleanup[objectID]
+		if err := encoder.Encode(snapshotLine{Kind: "object", Object: &object}); err != nil {
+			return report, err
+		}
+		report.Objects++
+
+		for _, ticketID := range sortedByOffset(object.Tickets) {
+			if exported[ticketID] {
+				continue
+			}
+			ticket, err := GetTicketMetadata(ticketID)
+			if err != nil {
+				return report, fmt.Errorf("Unable to export ticket %s: %v\n", ticketID, err)
+			}
+			if len(ticket.TicketID) == 0 {
+				log.Printf("Object %s references missing ticket %s\n", objectID, ticketID)
+				continue
+			}
+			if err := encoder.Encode(snapshotLine{Kind: "ticket", Ticket: &ticket}); err != nil {
+				return report, err
+			}
+			exported[ticketID] = true
+			report.Tickets++
+		}
+	}
+
+	names, err := GetBuckets()
+	if err != nil {
+		return report, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bucket, err := GetBucketRecord(name)
+		if err != nil {
+			return report, fmt.Errorf("Unable to export bucket %s: %v\n", name, err)
+		}
+		if err := encoder.Encode(snapshotLine{Kind: "bucket", Bucket: &bucket}); err != nil {
+			return report, err
+		}
+		report.Buckets++
+	}
+
+	tenants, err := GetTenantRecords()
+	if err != nil {
+		return report, err
+	}
+	for i := range tenants {
+		if err := encoder.Encode(snapshotLine{Kind: "tenant", Tenant: &tenants[i]}); err != nil {
+			return report, err
+		}
+		report.Tenants++
+	}
+
+	counters := map[string]int64{}
+	for _, counterKey := range idCounterKeys {
+		if counters[counterKey], err = metadataStore.GetCounter(counterKey); err != nil {
+			return report, err
+		}
+	}
+	return report, encoder.Encode(snapshotLine{Kind: "counters", Counters: counters})
+}
+
+// GetTenantRecords Every tenant with an API key, a quota or usage, by name
+// * Has Datastore access
+func GetTenantRecords() ([]TenantRecord, error) {
+	keys, err := metadataStore.GetAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	names, err := metadataStore.GetTenants()
+	if err != nil {
+		return nil, err
+	}
+	records := map[string]*TenantRecord{}
+	for _, name := range names {
+		records[name] = &TenantRecord{Tenant: name}
+	}
+	for keyHash, name := range keys {
+		if _, ok := records[name]; !ok {
+			records[name] = &TenantRecord{Tenant: name}
+		}
+		records[name].APIKeys = append(records[name].APIKeys, keyHash)
+	}
+
+	tenants := []TenantRecord{}
+	for _, record := range records {
+		sort.Strings(record.APIKeys)
+		if record.Quota, err = metadataStore.GetTenantQuota(record.Tenant); err != nil {
+			return nil, err
+		}
+		if record.Usage, err = metadataStore.GetTenantUsage(record.Tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *record)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Tenant < tenants[j].Tenant })
+	return tenants, nil
+}
+
+// GetBucketRecord A bucket with all of its keys
+// * Has Datastore access
+func GetBucketRecord(name string) (BucketRecord, error) {
+	record := BucketRecord{Keys: map[string]string{}}
+	bucket, err := GetBucket(name)
+	if err != nil {
+		return record, err
+	}
+	record.Bucket = bucket
+	if record.Policy, err = GetBucketPolicy(name); err != nil {
+		return record, err
+	}
+
+	cursor := ""
+	for {
+		keys, next, err := ListBucketKeys(name, "", cursor, MaxListLimit)
+		if err != nil {
+			return record, err
+		}
+		for _, key := range keys {
+			record.Keys[key.Key] = key.ObjectID
+		}
+		if cursor = next; len(cursor) == 0 {
+			return record, nil
+		}
+	}
+}
+
+// ImportMetadata Restores a snapshot into the empty MetadataStore in use.
+// With verify, each ticket is read back from its WriteNode and those which
+// can't be are listed in the report.
+// * Has Datastore access
+func ImportMetadata(r io.Reader, verify bool) (SnapshotReport, error) {
+	report := SnapshotReport{}
+
+	objectIDs, err := GetObjects()
+	if err != nil {
+		return report, err
+	}
+	if len(objectIDs) > 0 {
+		return report, ErrStoreNotEmpty
+	}
+
+	decoder := json.NewDecoder(r)
+	line := snapshotLine{}
+	if err := decoder.Decode(&line); err != nil || line.Header == nil || line.Header.Format != SnapshotFormat {
+		return report, ErrNotSnapshot
+	}
+	if line.Header.Version > SnapshotVersion {
+		return report, fmt.Errorf("Snapshot version %d is newer than %d\n", line.Header.Version, SnapshotVersion)
+	}
+
+	// counters: Lowest value of each ID counter which grants no imported ID
+	counters := map[string]int64{}
+	for {
+		line := snapshotLine{}
+		err := decoder.Decode(&line)
+		if err == io.EOF {
+			return report, raiseIDCounters(counters)
+		}
+		if err != nil {
+			return report, err
+		}
+
+		switch {
+		case line.Kind == "object" && line.Object != nil:
+			if err := metadataStore.RestoreObject(*line.Object); err != nil {
+				return report, err
+			}
+			report.Objects++
+			raiseToID(counters, objectIDCounterKey, line.Object.ObjectID)
+		case line.Kind == "ticket" && line.Ticket != nil:
+			if err := metadataStore.RestoreTicket(*line.Ticket); err != nil {
+				return report, err
+			}
+			raiseToID(counters, ticketIDCounterKey, line.Ticket.TicketID)
+			report.Tickets++
+			if verify && !VerifyTicket(*line.Ticket) {
+				report.Unverified = append(report.Unverified, line.Ticket.TicketID)
+			}
+		case line.Kind == "bucket" && line.Bucket != nil:
+			if err := metadataStore.CreateBucket(line.Bucket.Bucket); err != nil {
+				return report, err
+			}
+			for key, objectID := range line.Bucket.Keys {
+				if _, err := metadataStore.PutBucketKey(line.Bucket.Name, key, objectID); err != nil {
+					return report, err
+				}
+				if err := metadataStore.SetObjectKey(objectID, line.Bucket.Name, key); err != nil {
+					return report, err
+				}
+			}
+			if len(line.Bucket.Policy) > 0 {
+				if err := metadataStore.PutBucketPolicy(line.Bucket.Name, line.Bucket.Policy); err != nil {
+					return report, err
+				}
+			}
+			report.Buckets++
+		case line.Kind == "tenant" && line.Tenant != nil:
+			if err := restoreTenant(*line.Tenant); err != nil {
+				return report, err
+			}
+			report.Tenants++
+		case line.Kind == "counters":
+			for _, counterKey := range idCounterKeys {
+				if line.Counters[counterKey] > counters[counterKey] {
+					counters[counterKey] = line.Counters[counterKey]
+				}
+			}
+		default:
+			log.Printf("Skipping unknown snapshot line of kind %s\n", line.Kind)
+		}
+	}
+}
+
+// restoreTenant Writes the API keys, quota and usage of a tenant
+// * Has Datastore access
+func restoreTenant(tenant TenantRecord) error {
+	for _, keyHash := range tenant.APIKeys {
+		if err := metadataStore.PutAPIKey(keyHash, tenant.Tenant); err != nil {
+			return err
+		}
+	}
+	if tenant.Quota != (Quota{}) {
+		if err := metadataStore.SetTenantQuota(tenant.Tenant, tenant.Quota); err != nil {
+			return err
+		}
+	}
+	if tenant.Usage != (Quota{}) {
+		return metadataStore.AddTenantUsage(tenant.Tenant, tenant.Usage.Bytes, tenant.Usage.Objects)
+	}
+	return nil
+}
+
+// raiseToID Raises a counter to the number of an ID. Legacy IDs are shorter
+// than IDLength so they never collide and are skipped.
+func raiseToID(counters map[string]int64, counterKey, id string) {
+	if len(id) != IDLength {
+		return
+	}
+	if n, err := strconv.ParseInt(id, 36, 64); err == nil && n > counters[counterKey] {
+		counters[counterKey] = n
+	}
+}
+
+// raiseIDCounters Raises each ID counter in the store which is below its
+// value in counters, so IDs are never granted twice
+// * Has Datastore access
+func raiseIDCounters(counters map[string]int64) error {
+	for _, counterKey := range idCounterKeys {
+		current, err := metadataStore.GetCounter(counterKey)
+		if err != nil {
+			return err
+		}
+		if counters[counterKey] > current {
+			if _, err := metadataStore.IncrementCounter(counterKey, counters[counterKey]-current); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyTicket True when the node of a ticket serves all of its bytes
+func VerifyTicket(ticket Ticket) bool {
+	response, err := ReadTicketFromNode(ticket)
+	if err != nil {
+		log.Printf("Unable to read ticket %s from %s: %v\n", ticket.TicketID, ticket.NodeID, err)
+		return false
+	}
+	if response.Status != NodeSuccess || int64(len(response.Data)) != ticket.ByteCount {
+		log.Printf("Node %s has %d of %d bytes of ticket %s with status %d\n",
+			ticket.NodeID, len(response.Data), ticket.ByteCount, ticket.TicketID, response.Status)
+		return false
+	}
+	return true
+}
+
+// sortedByOffset TicketIDs in the order of their bytes
+func sortedByOffset(offsets map[string]int64) []string {
+	ticketIDs := make([]string, 0, len(offsets))
+	for ticketID := range offsets {
+		ticketIDs = append(ticketIDs, ticketID)
+	}
+	sort.Slice(ticketIDs, func(i, j int) bool {
+		a, b := offsets[ticketIDs[i]], offsets[ticketIDs[j]]
+		if a == b {
+			return ticketIDs[i] < ticketIDs[j]
+		}
+		return a < b
+	})
+	return ticketIDs
+}
@@ -0,0 +1,129 @@
+package dataputter
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImportMetadata(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	CreateObject("SNAPSHOT_OBJECT_A", "TICKET_A1")
+	CreateTicket("TICKET_A1", "SNAPSHOT_OBJECT_A", "TEST_NODE_ID", 0, 9, 10)
+	CreateTicket("TICKET_A2", "SNAPSHOT_OBJECT_A", "TEST_NODE_ID", 10, 19, 10)
+	SetTicketStoredByteCount("SNAPSHOT_OBJECT_A", "TICKET_A1", 4, CompressionZstd)
+	IndexTicketHash("TICKET_A2", "HASH_A2")
+	SetObjectByteSize("SNAPSHOT_OBJECT_A", 20)
+	SetObjectStatus("SNAPSHOT_OBJECT_A", ObjectStatus[ObjectWriting])
+	SetObjectStatus("SNAPSHOT_OBJECT_A", ObjectStatus[ObjectSaved])
+	TouchTicketCounter("SNAPSHOT_OBJECT_A")
+	TouchWriteCounter("SNAPSHOT_OBJECT_A")
+
+	// The second object references a ticket of the first
+	CreateObject("SNAPSHOT_OBJECT_B", "TICKET_A2")
+	AddTicketReference("SNAPSHOT_OBJECT_B", "TICKET_A2", 0)
+	SetObjectDataKey("SNAPSHOT_OBJECT_B", "k1:c2VhbGVk")
+	CreateBucket(Bucket{Name: "snapshots", Compression: CompressionSnappy})
+	PutObjectKey("snapshots", "b.bin", "SNAPSHOT_OBJECT_B")
+
+	// The third object is shredded and its bytes not yet cleaned up
+	CreateObject("SNAPSHOT_OBJECT_C", "")
+	SetObjectStatus("SNAPSHOT_OBJECT_C", ObjectStatus[ObjectWriting])
+	SetObjectStatus("SNAPSHOT_OBJECT_C", ObjectStatus[ObjectSaved])
+	ShredObjectDataKey("SNAPSHOT_OBJECT_C")
+
+	CreateAPIKey("tenant-a")
+	SetTenantQuota("tenant-b", Quota{Bytes: 100})
+	metadataStore.AddTenantUsage("tenant-b", 20, 1)
+	metadataStore.IncrementCounter(objectIDCounterKey, 500)
+	metadataStore.IncrementCounter(ticketIDCounterKey, 900)
+
+	snapshot := &bytes.Buffer{}
+	exported, err := ExportMetadata(snapshot)
+	if err != nil || exported.Objects != 3 || exported.Tickets != 2 || exported.Buckets != 1 || exported.Tenants != 2 {
+		t.Fatalf("Expected 3 objects, 2 tickets, 1 bucket and 2 tenants exported, got %+v %v\n", exported, err)
+	}
+	tenants, _ := GetTenantRecords()
+	before := map[string]interface{}{}
+	for _, id := range []string{"SNAPSHOT_OBJECT_A", "SNAPSHOT_OBJECT_B", "SNAPSHOT_OBJECT_C"} {
+		before[id], _ = GetObjectRecord(id)
+	}
+	for _, id := range []string{"TICKET_A1", "TICKET_A2"} {
+		before[id], _ = GetTicketMetadata(id)
+	}
+
+	if _, err := ImportMetadata(bytes.NewReader(snapshot.Bytes()), false); err != ErrStoreNotEmpty {
+		t.Errorf("Expected ErrStoreNotEmpty, got %v\n", err)
+	}
+
+	UseMetadataStore(NewMemoryStore())
+	imported, err := ImportMetadata(bytes.NewReader(snapshot.Bytes()), false)
+	if err != nil || imported.Objects != 3 || imported.Tickets != 2 || imported.Tenants != 2 {
+		t.Fatalf("Expected 3 objects, 2 tickets and 2 tenants imported, got %+v %v\n", imported, err)
+	}
+	for id, record := range before {
+		var after interface{}
+		if strings.HasPrefix(id, "TICKET") {
+			after, _ = GetTicketMetadata(id)
+		} else {
+			after, _ = GetObjectRecord(id)
+		}
+		if !reflect.DeepEqual(record, after) {
+			t.Errorf("Expected %s to be restored as %+v, got %+v\n", id, record, after)
+		}
+	}
+	if ticketID, _ := GetTicketByHash("HASH_A2"); ticketID != "TICKET_A2" {
+		t.Errorf("Expected the hash index to be restored, got %s\n", ticketID)
+	}
+	bucket, err := GetBucketRecord("snapshots")
+	if err != nil || bucket.Compression != CompressionSnappy || bucket.Keys["b.bin"] != "SNAPSHOT_OBJECT_B" {
+		t.Errorf("Expected bucket snapshots to be restored, got %+v %v\n", bucket, err)
+	}
+	if after, _ := GetTenantRecords(); !reflect.DeepEqual(tenants, after) {
+		t.Errorf("Expected tenants to be restored as %+v, got %+v\n", tenants, after)
+	}
+	if shredded, _ := GetShreddedObjects(); !reflect.DeepEqual(shredded, []string{"SNAPSHOT_OBJECT_C"}) {
+		t.Errorf("Expected SNAPSHOT_OBJECT_C to still need cleaning up, got %v\n", shredded)
+	}
+	for counterKey, value := range map[string]int64{objectIDCounterKey: 500, ticketIDCounterKey: 900} {
+		if restored, _ := metadataStore.GetCounter(counterKey); restored != value {
+			t.Errorf("Expected %s to be restored as %d, got %d\n", counterKey, value, restored)
+		}
+	}
+}
+
+func TestImportRaisesIDCounters(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	// Version 2 snapshots have no counters
+	snapshot := `{"kind":"header","header":{"format":"dataputter-metadata","version":2}}
+{"kind":"object","object":{"id":"` + FormatID(12345) + `","tickets":{"` + FormatID(777) + `":0}}}
+{"kind":"ticket","ticket":{"ticket":"` + FormatID(777) + `","object":"` + FormatID(12345) + `"}}
+{"kind":"object","object":{"id":"00000042","tickets":{}}}
+`
+	if _, err := ImportMetadata(strings.NewReader(snapshot), false); err != nil {
+		t.Fatalf("Expected the snapshot to be imported, got %v\n", err)
+	}
+	for counterKey, value := range map[string]int64{objectIDCounterKey: 12345, ticketIDCounterKey: 777} {
+		if raised, _ := metadataStore.GetCounter(counterKey); raised != value {
+			t.Errorf("Expected %s to be raised to %d, got %d\n", counterKey, value, raised)
+		}
+	}
+}
+
+func TestImportRejectsNewerSnapshots(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	snapshot := `{"kind":"header","header":{"format":"dataputter-metadata","version":99}}`
+	if _, err := ImportMetadata(strings.NewReader(snapshot), false); err == nil {
+		t.Errorf("Expected version 99 to be rejected\n")
+	}
+	if _, err := ImportMetadata(strings.NewReader(`{"kind":"object"}`), false); err != ErrNotSnapshot {
+		t.Errorf("Expected ErrNotSnapshot, got %v\n", err)
+	}
+}
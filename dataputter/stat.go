@@ -0,0 +1,88 @@
+// Stat
+//
+// StatObject answers what an upload verifier or an origin needs to know
+// about an object without reading any of its bytes. The read server stats
+// an object with a request instead of a TicketID
+//
+// 	[8B "0STA0STA"][ObjectID][16B API Key] -> [8B Size][JSON ObjectStat]
+//
+// The ObjectID is sent as WireID and the API key is only read when
+// ROUTER_AUTH is set. Objects which don't exist or which the tenant can't
+// read are answered with _FAILED_ like any other failure.
+package dataputter
+
+import (
+	"errors"
+	"io"
+	"log"
+)
+
+// ErrObjectNotExist No object has the ObjectID
+var ErrObjectNotExist = errors.New("Object does not exist")
+
+// ObjectStat The metadata of an object with its tickets counted and the
+// nodes holding them
+type ObjectStat struct {
+	ObjectMetadata
+	TicketCount int      `json:"ticketCount"`
+	Nodes       []string `json:"nodes"`
+}
+
+// StatObject Metadata of an object without its data
+// * Has Datastore access
+func StatObject(objectID string) (ObjectStat, error) {
+	stat := ObjectStat{}
+
+	metadata, err := GetObjectMetadata(objectID)
+	if err != nil {
+		return stat, err
+	}
+	if len(metadata.Status) == 0 {
+		return stat, ErrObjectNotExist
+	}
+	stat.ObjectMetadata = metadata
+
+	tickets, err := GetObjectTickets(objectID)
+	if err != nil {
+		return stat, err
+	}
+	stat.TicketCount = len(tickets)
+	stat.Nodes, err = GetObjectNodes(objectID)
+	return stat, err
+}
+
+// serveStatObject Answers a stat request of the read server
+// * Has Datastore access
+func serveStatObject(c io.Writer, r io.Reader) error {
+	objectID, err := readWireID(r)
+	if err != nil {
+		log.Printf("Unable to read stat request: %v\n", err)
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+	var tenant string
+	if authRequired {
+		tenant, err = readAPIKey(r)
+		if err == nil {
+			err = AuthorizeObject(tenant, objectID, AccessRead)
+		}
+		if err != nil {
+			log.Printf("Refused to stat %s: %v\n", objectID, err)
+			c.Write([]byte(REPLY_FAILED))
+			return err
+		}
+	}
+	if err := AllowRequest(tenant); err != nil {
+		log.Printf("Refused to stat %s: %v\n", objectID, err)
+		c.Write(limitReply(err))
+		return err
+	}
+
+	stat, err := StatObject(objectID)
+	if err != nil {
+		log.Printf("Failed to stat %s: %v\n", objectID, err)
+		c.Write([]byte(REPLY_FAILED))
+		return err
+	}
+	return writeJSONReply(c, stat)
+}
@@ -0,0 +1,68 @@
+package dataputter
+
+import "testing"
+
+func TestStatObject(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+
+	if _, err := StatObject("STAT_OBJECT"); err != ErrObjectNotExist {
+		t.Errorf("Expected ErrObjectNotExist, got %v\n", err)
+	}
+
+	CreateObject("STAT_OBJECT", "STAT_T1")
+	CreateTicket("STAT_T1", "STAT_OBJECT", "NODE_A", 0, 9, 10)
+	CreateTicket("STAT_T2", "STAT_OBJECT", "NODE_B", 10, 19, 10)
+	SetObjectStatus("STAT_OBJECT", ObjectStatus[ObjectWriting])
+	SetObjectByteSize("STAT_OBJECT", 20)
+	SetObjectChecksum("STAT_OBJECT", "c0ffee")
+	SetObjectStatus("STAT_OBJECT", ObjectStatus[ObjectSaved])
+
+	stat, err := StatObject("STAT_OBJECT")
+	if err != nil {
+		t.Fatalf("Expected to stat STAT_OBJECT, got %v\n", err)
+	}
+	if stat.Size != 20 || stat.Status != "saved" || stat.Checksum != "c0ffee" {
+		t.Errorf("Expected a saved 20 byte object with its checksum, got %+v\n", stat)
+	}
+	if stat.TicketCount != 2 || len(stat.Nodes) != 2 {
+		t.Errorf("Expected 2 tickets on 2 nodes, got %d on %v\n", stat.TicketCount, stat.Nodes)
+	}
+	if stat.CreatedAt.IsZero() {
+		t.Errorf("Expected a creation time\n")
+	}
+}
+
+func TestServeStatObject(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer useAuth()()
+
+	keyA, _ := CreateAPIKey("tenant-a")
+	keyB, _ := CreateAPIKey("tenant-b")
+	objectID := FormatID(1)
+	CreateObject(objectID, "STAT_T1")
+	CreateTicket("STAT_T1", objectID, "NODE_A", 0, 9, 10)
+	SetObjectStatus(objectID, ObjectStatus[ObjectWriting])
+	SetObjectByteSize(objectID, 10)
+	SetObjectChecksum(objectID, "c0ffee")
+	SetObjectStatus(objectID, ObjectStatus[ObjectSaved])
+	SetObjectOwner(objectID, "tenant-a")
+
+	stat := ObjectStat{}
+	if err := readServerRequest([]byte(STAT_HEADER+objectID+keyA), &stat); err != nil {
+		t.Fatalf("Expected tenant-a to stat its object, got %v\n", err)
+	}
+	if stat.ObjectID != objectID || stat.Size != 10 || stat.Checksum != "c0ffee" || stat.Owner != "tenant-a" {
+		t.Errorf("Expected the metadata of %s, got %+v\n", objectID, stat)
+	}
+	if stat.TicketCount != 1 || len(stat.Nodes) != 1 || stat.Nodes[0] != "NODE_A" {
+		t.Errorf("Expected 1 ticket on NODE_A, got %d on %v\n", stat.TicketCount, stat.Nodes)
+	}
+	if err := readServerRequest([]byte(STAT_HEADER+objectID+keyB), &stat); err == nil {
+		t.Errorf("Expected tenant-b to be refused the object of tenant-a\n")
+	}
+	if err := readServerRequest([]byte(STAT_HEADER+FormatID(2)+keyA), &stat); err == nil {
+		t.Errorf("Expected an object which doesn't exist to fail\n")
+	}
+}
@@ -1,39 +1,187 @@
+// Ticket Generator
+//
+// TicketIDs and ObjectIDs are IDLength characters of zero padded base36, so
+// they're all the same width. The IDs one Router grants increase, but each
+// Router grants from its own block so IDs from several Routers don't sort
+// in the order they were granted. Each Router leases a block of numbers
+// from a counter in the datastore with one INCRBY and grants IDs from it
+// locally. Once a tenth of the block is left the next block is leased in
+// the background, so granting an ID only waits on the datastore when a
+// block runs out before it's renewed. Numbers left in a block when a
+// Router stops are never granted.
+//
+// 	ID_LEASE_SIZE : Numbers leased from a counter at a time, default 10000
+//
+// IDs granted before this were 8 digits of the same counters, they're
+// shorter so they never collide with the IDs granted now. TCP requests send
+// IDs as IDLength characters, or as a byte of their length followed by the
+// ID for those of any other length
+//
+// 	[13B ID] or [1B length][ID]
+//
+// IDs are base36, so a first byte below '0' can only be a length.
 package dataputter
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
 	objectIDCounterKey = "objectIDCounter"
 	ticketIDCounterKey = "ticketIDCounter"
+
+	// IDLength Characters in every TicketID and ObjectID, enough for any int64
+	IDLength = 13
 )
 
-// Unique ticketID generation
-func NextTicketID() []byte {
-	v, err := touchCounter(ticketIDCounterKey)
-	if err != nil {
-		log.Printf("Unable to get objectID from %s: %v\n",
-			objectIDCounterKey, err,
-		)
-		return []byte{}
+var (
+	// idLeaseSize: Numbers leased from a counter at a time
+	idLeaseSize = int64(10000)
+
+	ticketIDLease = &idLease{counterKey: ticketIDCounterKey}
+	objectIDLease = &idLease{counterKey: objectIDCounterKey}
+)
+
+func init() {
+	if size := os.Getenv("ID_LEASE_SIZE"); len(size) > 0 {
+		v, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || v <= 0 {
+			log.Printf("Ignoring ID_LEASE_SIZE=%s: %v\n", size, err)
+		} else {
+			idLeaseSize = v
+		}
 	}
-	ticketID := fmt.Sprintf("%08d", v)
+}
 
-	return []byte(ticketID)
+// idBlock Numbers of a lease, [next, last]
+type idBlock struct {
+	next int64
+	last int64
 }
 
-// Unique objectID generation
-func NextObjectID() []byte {
-	v, err := touchCounter(objectIDCounterKey)
+func (b idBlock) remaining() int64 {
+	if b.next == 0 {
+		return 0
+	}
+	return b.last - b.next + 1
+}
+
+// idLease Blocks of numbers leased from a counter. The renewal is the block
+// after the current one, leased in the background.
+type idLease struct {
+	sync.Mutex
+	counterKey string
+	current    idBlock
+	renewal    idBlock
+	renewing   bool
+}
+
+// nextID Grants the next ID of the lease, starting on the renewal or leasing
+// a block when the current one is used up
+// * Has Datastore access
+func (l *idLease) nextID() (string, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.current.remaining() == 0 {
+		if l.renewal.remaining() > 0 {
+			l.current, l.renewal = l.renewal, idBlock{}
+		} else {
+			block, err := leaseIDBlock(l.counterKey)
+			if err != nil {
+				return "", err
+			}
+			l.current = block
+		}
+	}
+	id := FormatID(l.current.next)
+	l.current.next++
+
+	if !l.renewing && l.renewal.remaining() == 0 && l.current.remaining() <= idLeaseSize/10 {
+		l.renewing = true
+		go l.renew()
+	}
+	return id, nil
+}
+
+// renew Leases the block after the current one
+// * Has Datastore access
+func (l *idLease) renew() {
+	block, err := leaseIDBlock(l.counterKey)
+
+	l.Lock()
+	defer l.Unlock()
+	l.renewing = false
 	if err != nil {
-		log.Printf("Unable to get objectID from %s: %v\n",
-			objectIDCounterKey, err,
-		)
-		return []byte{}
+		log.Printf("Unable to renew the lease of %s: %v\n", l.counterKey, err)
+		return
 	}
-	objectID := fmt.Sprintf("%08d", v)
+	l.renewal = block
+}
+
+// leaseIDBlock Takes the next idLeaseSize numbers of a counter. INCRBY is
+// atomic so no two Routers, or restarts of one, lease the same numbers.
+// * Has Datastore access
+func leaseIDBlock(counterKey string) (idBlock, error) {
+	size := idLeaseSize
+	last, err := metadataStore.IncrementCounter(counterKey, size)
+	if err != nil {
+		return idBlock{}, fmt.Errorf("Unable to lease IDs from %s: %v\n", counterKey, err)
+	}
+	return idBlock{next: last - size + 1, last: last}, nil
+}
+
+// FormatID The ID of n, zero padded base36 of IDLength characters
+func FormatID(n int64) string {
+	id := strconv.FormatInt(n, 36)
+	return strings.Repeat("0", IDLength-len(id)) + id
+}
+
+// WireID An ID as it's sent in TCP requests
+func WireID(id string) []byte {
+	if len(id) == IDLength {
+		return []byte(id)
+	}
+	return append([]byte{byte(len(id))}, id...)
+}
 
-	return []byte(objectID)
+// readWireID Reads an ID sent by WireID
+func readWireID(r io.Reader) (string, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(r, first); err != nil {
+		return "", err
+	}
+	if first[0] >= '0' {
+		rest := make([]byte, IDLength-1)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return "", err
+		}
+		return string(first) + string(rest), nil
+	}
+	if first[0] == 0 {
+		return "", fmt.Errorf("Empty ID\n")
+	}
+	id := make([]byte, first[0])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
+
+// Unique ticketID generation
+func NextTicketID() ([]byte, error) {
+	ticketID, err := ticketIDLease.nextID()
+	return []byte(ticketID), err
+}
+
+// Unique objectID generation
+func NextObjectID() ([]byte, error) {
+	objectID, err := objectIDLease.nextID()
+	return []byte(objectID), err
 }
@@ -1,16 +1,103 @@
 package dataputter
 
 import (
+	"bytes"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestNextTicketID(t *testing.T) {
-	deleteKeyPath(ticketIDCounterKey)
-
+	previous := ""
 	for i := 0; i < 10; i++ {
-		id := NextTicketID()
-		if len(id) != 8 {
-			t.Errorf("Expected 8 bytes, got %d\n", len(id))
+		id, err := NextTicketID()
+		if err != nil {
+			t.Fatalf("Expected a TicketID, got %v\n", err)
+		}
+		if len(id) != IDLength {
+			t.Errorf("Expected %d bytes, got %d\n", IDLength, len(id))
+		}
+		if string(id) <= previous {
+			t.Errorf("Expected %s to sort after %s\n", id, previous)
+		}
+		previous = string(id)
+	}
+}
+
+func TestIDLease(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer func(size int64) { idLeaseSize = size }(idLeaseSize)
+	idLeaseSize = 10
+
+	lease := &idLease{counterKey: "leaseTestCounter"}
+	// The last tenth of the block starts a renewal
+	for i := 0; i < 9; i++ {
+		if _, err := lease.nextID(); err != nil {
+			t.Fatalf("Expected an ID, got %v\n", err)
 		}
 	}
+	waitForRenewal(lease)
+	if v, _ := getCounter("leaseTestCounter"); v != 2*idLeaseSize {
+		t.Errorf("Expected 2 leased blocks, got a counter of %d\n", v)
+	}
+
+	// The renewal is granted once the block is used up
+	ids := map[string]bool{}
+	for i := 0; i < 11; i++ {
+		id, err := lease.nextID()
+		if err != nil {
+			t.Fatalf("Expected an ID, got %v\n", err)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 11 || !ids[FormatID(11)] || !ids[FormatID(20)] {
+		t.Errorf("Expected IDs 10 through 20, got %v\n", ids)
+	}
+	waitForRenewal(lease)
+}
+
+// waitForRenewal Waits up to a second for a lease to finish renewing
+func waitForRenewal(lease *idLease) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		lease.Lock()
+		renewing := lease.renewing
+		lease.Unlock()
+		if !renewing {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFormatID(t *testing.T) {
+	if id := FormatID(35); id != "000000000000z" {
+		t.Errorf("Expected 000000000000z, got %s\n", id)
+	}
+	if id := FormatID(math.MaxInt64); len(id) != IDLength {
+		t.Errorf("Expected %d characters, got %s\n", IDLength, id)
+	}
+	if FormatID(99999999) <= FormatID(36) {
+		t.Errorf("Expected larger IDs to sort after smaller ones\n")
+	}
+}
+
+func TestWireID(t *testing.T) {
+	for _, id := range []string{FormatID(42), "00000042"} {
+		r := bytes.NewReader(append(WireID(id), "API_KEY"...))
+		read, err := readWireID(r)
+		if err != nil || read != id {
+			t.Errorf("Expected to read %s back, got %s %v\n", id, read, err)
+		}
+		if r.Len() != len("API_KEY") {
+			t.Errorf("Expected only the ID of %s to be read, %d bytes left\n", id, r.Len())
+		}
+	}
+	if len(WireID(FormatID(42))) != IDLength {
+		t.Errorf("Expected IDs of IDLength to be sent as they are\n")
+	}
+	if _, err := readWireID(bytes.NewReader([]byte{0})); err == nil {
+		t.Errorf("Expected an empty ID to be refused\n")
+	}
 }
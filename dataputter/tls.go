@@ -0,0 +1,457 @@
+// TLS
+//
+// Every listener of a Router or WriteNode can be served over TLS, and
+// Routers dial WriteNodes over TLS with a client certificate so nodes can
+// verify them (mTLS). TLS is configured in the tls section of the YAML file
+// named by TLS_CONFIG
+//
+//     tls:
+//       cert: /etc/dataputter/node.crt
+//       key: /etc/dataputter/node.key
+//       ca: /etc/dataputter/ca.crt
+//       clientAuth: true
+//
+// 	cert, key  : PEM certificate and key presented to peers
+// 	ca         : PEM CAs peers are verified with, the system roots when empty
+// 	clientAuth : Listeners require a client certificate signed by ca, which
+// 	             is required with it
+// 	ephemeral  : Generate a CA and certificate in memory instead of reading files
+//
+// The files are checked every tlsReloadInterval and certificates are
+// replaced when they change, without dropping connections. A certificate
+// which fails to load is logged and the previous one is kept.
+//
+// Ephemeral certificates only live as long as the process, so only peers in
+// the same process (standAlone and tests) trust each other.
+package dataputter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrNoPeerCertificate The peer of a TLS connection sent no certificate
+	ErrNoPeerCertificate = errors.New("No peer certificate")
+	// ErrClientAuthWithoutCA Client certificates would be verified against
+	// the system roots, which any public CA can issue for
+	ErrClientAuthWithoutCA = errors.New("TLS clientAuth requires a ca")
+
+	// tlsReloadInterval: How often certificate files are checked for changes
+	tlsReloadInterval = 10 * time.Second
+
+	// transportTLS: Certificates of listeners and dials, nil when TLS is off
+	transportTLS *certReloader
+	// transportTLSErr: Why TLS_CONFIG couldn't be loaded, nothing is served
+	// in plaintext in its place
+	transportTLSErr error
+)
+
+func init() {
+	filename := os.Getenv("TLS_CONFIG")
+	if len(filename) == 0 {
+		return
+	}
+	config, err := LoadTLSConfig(filename)
+	if err == nil {
+		err = UseTLSConfig(config)
+	}
+	if err != nil {
+		log.Printf("Unable to load TLS from %s: %v\n", filename, err)
+		transportTLSErr = err
+		return
+	}
+	if transportTLS != nil {
+		log.Printf("Loaded TLS from %s, client auth %v\n", filename, config.ClientAuth)
+		if !config.Ephemeral {
+			go transportTLS.watch(tlsReloadInterval)
+		}
+	}
+}
+
+// TLSConfig Certificates of a Router or WriteNode
+type TLSConfig struct {
+	CertFile   string `yaml:"cert"`
+	KeyFile    string `yaml:"key"`
+	CAFile     string `yaml:"ca"`
+	ClientAuth bool   `yaml:"clientAuth"`
+	Ephemeral  bool   `yaml:"ephemeral"`
+}
+
+// Enabled True when listeners and dials use TLS
+func (c TLSConfig) Enabled() bool {
+	return c.Ephemeral || len(c.CertFile) > 0
+}
+
+// Validate ErrClientAuthWithoutCA when client certificates are required
+// without a CA to verify them with
+func (c TLSConfig) Validate() error {
+	if c.ClientAuth && !c.Ephemeral && len(c.CAFile) == 0 {
+		return ErrClientAuthWithoutCA
+	}
+	return nil
+}
+
+type tlsConfigFile struct {
+	TLS TLSConfig `yaml:"tls"`
+}
+
+// LoadTLSConfig Reads the tls section of a YAML file
+func LoadTLSConfig(filename string) (TLSConfig, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return TLSConfig{}, err
+	}
+	file := tlsConfigFile{}
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return TLSConfig{}, err
+	}
+	return file.TLS, file.TLS.Validate()
+}
+
+// UseTLSConfig Loads the certificates of config for every listener and dial
+// after this, turning TLS off when it isn't enabled
+func UseTLSConfig(config TLSConfig) error {
+	if !config.Enabled() {
+		transportTLS, transportTLSErr = nil, nil
+		return nil
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	reloader := &certReloader{config: config, modTimes: map[string]time.Time{}}
+	if err := reloader.load(); err != nil {
+		return err
+	}
+	transportTLS, transportTLSErr = reloader, nil
+	return nil
+}
+
+// certReloader Certificate and CAs of a TLSConfig, replaced when their
+// files change
+type certReloader struct {
+	sync.RWMutex
+	config   TLSConfig
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// files Files the certificates are read from
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if len(r.config.CAFile) > 0 {
+		files = append(files, r.config.CAFile)
+	}
+	return files
+}
+
+// load Reads the certificate and CAs, or generates them when ephemeral
+func (r *certReloader) load() error {
+	if r.config.Ephemeral {
+		return r.generate()
+	}
+
+	modTimes := map[string]time.Time{}
+	for _, filename := range r.files() {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		modTimes[filename] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	var roots *x509.CertPool
+	if len(r.config.CAFile) > 0 {
+		b, err := ioutil.ReadFile(r.config.CAFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(b) {
+			return fmt.Errorf("No certificates in CA file %s\n", r.config.CAFile)
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.cert, r.roots, r.modTimes = &cert, roots, modTimes
+	return nil
+}
+
+// changed True when a file was modified since it was loaded
+func (r *certReloader) changed() bool {
+	r.RLock()
+	defer r.RUnlock()
+	for _, filename := range r.files() {
+		info, err := os.Stat(filename)
+		if err != nil || !info.ModTime().Equal(r.modTimes[filename]) {
+			return true
+		}
+	}
+	return false
+}
+
+// reload Loads the files again when they've changed, keeping the previous
+// certificates when they can't be loaded
+func (r *certReloader) reload() {
+	if !r.changed() {
+		return
+	}
+	if err := r.load(); err != nil {
+		log.Printf("Keeping previous certificate, unable to reload %s: %v\n", r.config.CertFile, err)
+		return
+	}
+	log.Printf("Reloaded certificate %s\n", r.config.CertFile)
+}
+
+// Periodically reload certificates which have changed
+func (r *certReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.reload()
+	}
+}
+
+func (r *certReloader) certificate() *tls.Certificate {
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert
+}
+
+func (r *certReloader) certPool() *x509.CertPool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.roots
+}
+
+// serverConfig TLS of listeners, each handshake uses the current certificate.
+// nextProtos are the protocols offered with ALPN.
+func (r *certReloader) serverConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*r.certificate()},
+			}
+			if r.config.ClientAuth {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = r.certPool()
+			}
+			return config, nil
+		},
+	}
+}
+
+// clientConfig TLS of dials. Servers are verified by verifyServer so the
+// current CAs are used rather than the ones at the time of the dial.
+func (r *certReloader) clientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		VerifyConnection:   r.verifyServer,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+	}
+}
+
+// verifyServer Verifies the certificate chain of a server against the
+// current CAs and the name it was dialed with
+func (r *certReloader) verifyServer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return ErrNoPeerCertificate
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         r.certPool(),
+		DNSName:       state.ServerName,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// generate Creates an ephemeral CA and a certificate for this host signed by it
+func (r *certReloader) generate() error {
+	ca, caKey, err := newEphemeralCA()
+	if err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := newEphemeralCertificate(ca, caKey)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	r.Lock()
+	defer r.Unlock()
+	r.cert, r.roots = &cert, roots
+	return nil
+}
+
+// newEphemeralCA A self-signed CA valid for a day
+func newEphemeralCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "data-putter ephemeral CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+// newEphemeralCertificate A PEM certificate and key for this host, signed by
+// ca, which serves and dials
+func newEphemeralCertificate(ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil {
+		names = append(names, hostname)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[len(names)-1]},
+		DNSNames:     names,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     ca.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// Listen Listens for TCP on port, with TLS when it's configured. gRPC
+// servers listen without it and use GRPCServerOptions.
+func Listen(port int) (net.Listener, error) {
+	return listenTCP(fmt.Sprintf(":%d", port))
+}
+
+// listenTCP Listens for TCP on address, with TLS when it's configured
+func listenTCP(address string) (net.Listener, error) {
+	if transportTLSErr != nil {
+		return nil, transportTLSErr
+	}
+	s, err := net.Listen("tcp", address)
+	if err != nil || transportTLS == nil {
+		return s, err
+	}
+	return tls.NewListener(s, transportTLS.serverConfig()), nil
+}
+
+// GRPCServerOptions Options of gRPC servers, serving TLS when it's configured
+func GRPCServerOptions() ([]grpc.ServerOption, error) {
+	if transportTLSErr != nil {
+		return nil, transportTLSErr
+	}
+	if transportTLS == nil {
+		return []grpc.ServerOption{}, nil
+	}
+	return []grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(transportTLS.serverConfig("h2"))),
+	}, nil
+}
+
+// dialTCP Dials a host:port, with TLS when it's configured
+func dialTCP(hostPort string) (net.Conn, error) {
+	if transportTLSErr != nil {
+		return nil, transportTLSErr
+	}
+	if transportTLS == nil {
+		return net.Dial("tcp", hostPort)
+	}
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", hostPort, transportTLS.clientConfig(host))
+}
+
+// writeNodeConn A client of a WriteNode and its connection
+type writeNodeConn struct {
+	WriteNodeClient
+	conn *grpc.ClientConn
+}
+
+// Close Closes the connection to the WriteNode
+func (c *writeNodeConn) Close() error {
+	return c.conn.Close()
+}
+
+// dialWriteNode Connects to the WriteNode at host:port, with TLS and the
+// client certificate when it's configured
+func dialWriteNode(hostPort string) (*writeNodeConn, error) {
+	if transportTLSErr != nil {
+		return nil, transportTLSErr
+	}
+	option := grpc.WithInsecure()
+	if transportTLS != nil {
+		host, _, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			return nil, err
+		}
+		option = grpc.WithTransportCredentials(credentials.NewTLS(transportTLS.clientConfig(host)))
+	}
+	conn, err := grpc.Dial(hostPort, option)
+	if err != nil {
+		return nil, err
+	}
+	return &writeNodeConn{NewWriteNodeClient(conn), conn}, nil
+}
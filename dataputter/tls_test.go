@@ -0,0 +1,173 @@
+package dataputter
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestMutualTLS(t *testing.T) {
+	defer UseTLSConfig(TLSConfig{})
+	if err := UseTLSConfig(TLSConfig{Ephemeral: true, ClientAuth: true}); err != nil {
+		t.Fatalf("Expected ephemeral certificates, got %v\n", err)
+	}
+
+	s, err := Listen(0)
+	if err != nil {
+		t.Fatalf("Expected to listen, got %v\n", err)
+	}
+	defer s.Close()
+	go func() {
+		for {
+			c, err := s.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.CopyN(c, c, 5)
+			}()
+		}
+	}()
+	address := fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+
+	c, err := dialTCP(address)
+	if err != nil {
+		t.Fatalf("Expected to dial with TLS, got %v\n", err)
+	}
+	defer c.Close()
+	c.Write([]byte("hello"))
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(c, reply); err != nil || string(reply) != "hello" {
+		t.Errorf("Expected hello, got %s %v\n", reply, err)
+	}
+
+	// Clients without a certificate are refused
+	anonymous, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		defer anonymous.Close()
+		anonymous.Write([]byte("hello"))
+		_, err = io.ReadFull(anonymous, reply)
+	}
+	if err == nil {
+		t.Errorf("Expected a client without a certificate to be refused\n")
+	}
+}
+
+func TestRouterRPCOverTLS(t *testing.T) {
+	defer UseMetadataStore(GetMetadataStore())
+	UseMetadataStore(NewMemoryStore())
+	defer UseTLSConfig(TLSConfig{})
+	if err := UseTLSConfig(TLSConfig{Ephemeral: true, ClientAuth: true}); err != nil {
+		t.Fatalf("Expected ephemeral certificates, got %v\n", err)
+	}
+
+	s, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected to listen, got %v\n", err)
+	}
+	options, _ := GRPCServerOptions()
+	rpcServer := grpc.NewServer(options...)
+	RegisterRouterServer(rpcServer, &routerServer{})
+	go rpcServer.Serve(s)
+	defer rpcServer.Stop()
+
+	address := fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port)
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(
+		credentials.NewTLS(transportTLS.clientConfig("localhost")),
+	))
+	if err != nil {
+		t.Fatalf("Expected to dial the Router, got %v\n", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := NewRouterClient(conn).ListObjects(ctx, &ListObjectsRequest{}); err != nil {
+		t.Errorf("Expected to list objects over TLS, got %v\n", err)
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey, err := newEphemeralCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := TLSConfig{
+		CertFile: filepath.Join(dir, "node.crt"),
+		KeyFile:  filepath.Join(dir, "node.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	ioutil.WriteFile(config.CAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644)
+	writeCertificate := func(modTime time.Time) {
+		certPEM, keyPEM, err := newEphemeralCertificate(ca, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(config.CertFile, certPEM, 0644)
+		ioutil.WriteFile(config.KeyFile, keyPEM, 0600)
+		os.Chtimes(config.CertFile, modTime, modTime)
+		os.Chtimes(config.KeyFile, modTime, modTime)
+	}
+	writeCertificate(time.Now().Add(-time.Hour))
+
+	reloader := &certReloader{config: config, modTimes: map[string]time.Time{}}
+	if err := reloader.load(); err != nil {
+		t.Fatalf("Expected to load certificates, got %v\n", err)
+	}
+	first := reloader.certificate()
+
+	reloader.reload()
+	if reloader.certificate() != first {
+		t.Errorf("Expected unchanged files to be kept\n")
+	}
+
+	writeCertificate(time.Now())
+	reloader.reload()
+	if reloader.certificate() == first {
+		t.Errorf("Expected the new certificate to be loaded\n")
+	}
+
+	second := reloader.certificate()
+	ioutil.WriteFile(config.CertFile, []byte("not a certificate"), 0644)
+	reloader.reload()
+	if reloader.certificate() != second {
+		t.Errorf("Expected a broken certificate to keep the previous one\n")
+	}
+}
+
+func TestClientAuthRequiresCA(t *testing.T) {
+	defer UseTLSConfig(TLSConfig{})
+	config := TLSConfig{CertFile: "node.crt", KeyFile: "node.key", ClientAuth: true}
+	if err := UseTLSConfig(config); err != ErrClientAuthWithoutCA {
+		t.Errorf("Expected clientAuth without a ca to be refused, got %v\n", err)
+	}
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tls.yaml")
+	ioutil.WriteFile(filename, []byte("tls:\n  cert: node.crt\n  key: node.key\n  clientAuth: true\n"), 0644)
+	if _, err := LoadTLSConfig(filename); err != ErrClientAuthWithoutCA {
+		t.Errorf("Expected clientAuth without a ca to be refused, got %v\n", err)
+	}
+}
@@ -11,21 +11,6 @@ var (
 	dataRoot = "data"
 )
 
-// ObjectPathComponents Provides a list of path components for an object
-// as chunks of two characters
-func ObjectPathComponents(objectID string) []string {
-	return strings.Split(objectID, "")
-}
-
-// ObjectPathString Provide the object path string
-func ObjectPathString(objectID string) string {
-	return strings.Join(
-		append([]string{dataRoot}, ObjectPathComponents(objectID)...),
-		string(os.PathSeparator),
-	)
-
-}
-
 // CreateObjectPath Creates the directory structure to store bytes
 // of data in the tail'th node of the path components
 func CreateObjectPath(objectID string) error {
@@ -14,6 +14,10 @@ type WriteTicket struct {
 	Checksum []byte
 	// Data: Opaque
 	Data []byte
+	// Compression: Codec to store Data with, empty uses the node default
+	Compression string
+	// DataKey: Encrypts the stored Data when set, it's never stored
+	DataKey []byte
 }
 
 type DeleteTicketConfirmation struct {
@@ -66,21 +70,56 @@ func (wt WriteTicket) String() string {
 
 // Write The data to a AB/CD/EF/obj file
 func (wt WriteTicket) Write() error {
+	_, _, err := wt.Store(wt.Compression, wt.DataKey)
+	return err
+}
+
+// Store Writes the data to a AB/CD/EF/obj file, compressing it with the
+// compression codec, or the node default when empty, if it shrinks. When a
+// dataKey is given the stored bytes are encrypted with it.
+// Returns the number of bytes written to disk and the codec used.
+func (wt WriteTicket) Store(compression string, dataKey []byte) (int64, string, error) {
 	err := CreateObjectPath(string(wt.TicketID))
 	if err != nil {
 		log.Printf("Unable to write ticket %s: %v\n", string(wt.TicketID), err)
-		return err
+		return 0, CompressionNone, err
 	}
 
+	stored, codec := compressTicketData(compression, wt.Data)
+	if len(dataKey) > 0 {
+		stored, err = encryptTicketData(dataKey, string(wt.TicketID), stored)
+		if err != nil {
+			log.Printf("Unable to encrypt ticket %s: %v\n", string(wt.TicketID), err)
+			return 0, codec, err
+		}
+	}
+	log.Printf("Storing %d of %d bytes of ticket %s as %s\n",
+		len(stored), len(wt.Data), string(wt.TicketID), codec,
+	)
 	filename := ObjectPathString(string(wt.TicketID)) + "/obj"
-	return putBytes(filename, wt.Data)
+	return int64(len(stored)), codec, putBytes(filename, stored)
+}
+
+// ReadTicket Reads the data of a ticket from disk as it was originally written.
+// Encrypted tickets need the dataKey of their object, ErrDecryptFailed is
+// returned when it is missing or wrong.
+func ReadTicket(ticketID string, dataKey []byte) ([]byte, error) {
+	stored, err := readTicketFile(ticketID)
+	if err != nil {
+		return nil, err
+	}
+	stored, err = decryptTicketData(dataKey, ticketID, stored)
+	if err != nil {
+		return nil, err
+	}
+	return decompressTicketData(stored)
 }
 
-// NewWriteTicket Creates a new write ticket with first 8 bytes of ticketID,
-// first 8 bytes of checksum, and all of the data bytes
+// NewWriteTicket Creates a new write ticket with the ticketID, first 8 bytes
+// of checksum, and all of the data bytes
 func NewWriteTicket(ticketID, checksum string, data []byte) WriteTicket {
 	return WriteTicket{
-		TicketID: []byte(ticketID)[0:8],
+		TicketID: []byte(ticketID),
 		Checksum: []byte(checksum)[0:8],
 		Data:     data,
 	}
@@ -8,8 +8,12 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/mrmod/data-putter/dataputter"
 )
@@ -43,8 +47,439 @@ func StartRouter(config dataputter.RouterConfig) {
 	fmt.Printf("Starting router on %d\n", config.Port)
 	dataputter.RunRouterServer(config)
 }
+
+// RotateKeys Re-wraps object data keys with the active key of MASTER_KEYFILE
+func RotateKeys() {
+	keyring, err := dataputter.LoadMasterKeyring(os.Getenv("MASTER_KEYFILE"))
+	if err != nil {
+		fmt.Printf("Unable to load MASTER_KEYFILE: %v\n", err)
+		return
+	}
+	rotated, err := dataputter.RotateMasterKey(keyring)
+	fmt.Printf("Rotated %d data keys to master key %s\n", rotated, keyring.Active)
+	if err != nil {
+		fmt.Printf("Rotation stopped: %v\n", err)
+	}
+}
+
+// MigrateMetadata Converts a Redis keyspace with a key per field into hashes
+func MigrateMetadata() {
+	store, ok := dataputter.GetMetadataStore().(*dataputter.RedisStore)
+	if !ok {
+		fmt.Println("Only metadata in Redis can be migrated")
+		return
+	}
+	moved, err := store.MigrateHashLayout()
+	fmt.Printf("Moved %d fields into hashes\n", moved)
+	if err != nil {
+		fmt.Printf("Migration stopped: %v\n", err)
+		return
+	}
+	migrated, err := store.MigrateStatuses()
+	fmt.Printf("Set %d statuses\n", migrated)
+	if err != nil {
+		fmt.Printf("Migration stopped: %v\n", err)
+	}
+}
+
+// ExportMetadata Writes a snapshot of all metadata to a file
+func ExportMetadata(args []string) {
+	if len(args) != 1 {
+		fmt.Println("USAGE: app exportMetadata FILE")
+		return
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		fmt.Printf("Unable to create %s: %v\n", args[0], err)
+		return
+	}
+	defer f.Close()
+
+	report, err := dataputter.ExportMetadata(f)
+	fmt.Printf("Exported %d objects, %d tickets, %d buckets and %d tenants\n", report.Objects, report.Tickets, report.Buckets, report.Tenants)
+	if err != nil {
+		fmt.Printf("Export stopped: %v\n", err)
+	}
+}
+
+// ImportMetadata Restores a snapshot file into an empty metadata store
+func ImportMetadata(args []string) {
+	flags := flag.NewFlagSet("importMetadata", flag.ExitOnError)
+	verify := flags.Bool("verify", false, "Read every ticket back from its WriteNode")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("USAGE: app importMetadata [--verify] FILE")
+		return
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Printf("Unable to open %s: %v\n", flags.Arg(0), err)
+		return
+	}
+	defer f.Close()
+
+	report, err := dataputter.ImportMetadata(f, *verify)
+	fmt.Printf("Imported %d objects, %d tickets, %d buckets and %d tenants\n", report.Objects, report.Tickets, report.Buckets, report.Tenants)
+	for _, ticketID := range report.Unverified {
+		fmt.Printf("Ticket %s is missing from its node\n", ticketID)
+	}
+	if err != nil {
+		fmt.Printf("Import stopped: %v\n", err)
+	}
+}
+
+// Fsck Reports, and optionally repairs, metadata which disagrees with nodes
+func Fsck(args []string) {
+	options := dataputter.FsckOptions{}
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	flags.BoolVar(&options.Repair, "repair", false, "Fix counters, mark objects with missing tickets and delete old orphans")
+	flags.BoolVar(&options.SkipNodes, "skip-nodes", false, "Don't read tickets back from their nodes")
+	flags.StringVar(&options.DataRoot, "data-root", "", "dataRoot of a WriteNode on this machine to check for orphaned files")
+	flags.DurationVar(&options.OrphanAge, "orphan-age", time.Hour, "Age before an orphaned file may be deleted")
+	flags.Parse(args)
+
+	problems, err := dataputter.Fsck(options)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("Found %d problems\n", len(problems))
+	if err != nil {
+		fmt.Printf("Fsck stopped: %v\n", err)
+	}
+}
+
+// MigrateLayout Moves the tickets of a WriteNode to the configured fan-out
+func MigrateLayout(args []string) {
+	flags := flag.NewFlagSet("migrateLayout", flag.ExitOnError)
+	dataRoot := flags.String("data-root", "data", "dataRoot of the WriteNode on this machine")
+	flags.Parse(args)
+
+	migration, err := dataputter.MigrateLayout(*dataRoot)
+	fmt.Printf("Moved %d tickets, skipped %d\n", migration.Moved, migration.Skipped)
+	if err != nil {
+		fmt.Printf("Migration stopped: %v\n", err)
+	}
+}
+
+// CreateAPIKey Creates and prints an API key of a tenant
+func CreateAPIKey(args []string) {
+	if len(args) != 1 {
+		fmt.Println("USAGE: app createAPIKey TENANT")
+		return
+	}
+	key, err := dataputter.CreateAPIKey(args[0])
+	if err != nil {
+		fmt.Printf("Unable to create an API key of %s: %v\n", args[0], err)
+		return
+	}
+	fmt.Println(key)
+}
+
+// RevokeAPIKey Deletes an API key
+func RevokeAPIKey(args []string) {
+	if len(args) != 1 {
+		fmt.Println("USAGE: app revokeAPIKey KEY")
+		return
+	}
+	if err := dataputter.RevokeAPIKey(args[0]); err != nil {
+		fmt.Printf("Unable to revoke API key: %v\n", err)
+	}
+}
+
+// ListObjects Prints a page of objects and the cursor of the next one
+func ListObjects(args []string) {
+	flags := flag.NewFlagSet("listObjects", flag.ExitOnError)
+	cursor := flags.String("cursor", "", "Cursor of the page to list")
+	limit := flags.Int("limit", dataputter.DefaultListLimit, "Objects in the page")
+	status := flags.String("status", "", "Only list objects with this status")
+	owner := flags.String("owner", "", "Only list objects of this tenant")
+	flags.Parse(args)
+
+	objects, next, err := dataputter.ListObjects(*cursor, *limit, *status, *owner)
+	for _, object := range objects {
+		fmt.Printf("%s\t%s\t%d\t%s\n", object.ObjectID, object.Status, object.Size, object.ContentType)
+	}
+	if err != nil {
+		fmt.Printf("Listing stopped: %v\n", err)
+		return
+	}
+	if len(next) > 0 {
+		fmt.Printf("Next page: --cursor %s\n", next)
+	}
+}
+
+// StatObject Prints the metadata of an object as JSON
+func StatObject(args []string) {
+	if len(args) != 1 {
+		fmt.Println("USAGE: app statObject OBJECT_ID")
+		return
+	}
+	stat, err := dataputter.StatObject(args[0])
+	if err != nil {
+		fmt.Printf("Unable to stat %s: %v\n", args[0], err)
+		return
+	}
+	out, _ := json.MarshalIndent(stat, "", "  ")
+	fmt.Println(string(out))
+}
+
+// CreateBucket Creates a bucket with its settings
+func CreateBucket(args []string) {
+	bucket := dataputter.Bucket{}
+	flags := flag.NewFlagSet("createBucket", flag.ExitOnError)
+	flags.StringVar(&bucket.Compression, "compression", "", "none, zstd or snappy, empty uses the node default")
+	flags.DurationVar(&bucket.Retention, "retention", 0, "Age objects are deleted at, 0 keeps them")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("USAGE: app createBucket [--compression CODEC] [--retention AGE] NAME")
+		return
+	}
+	bucket.Name = flags.Arg(0)
+
+	if err := dataputter.CreateBucket(bucket); err != nil {
+		fmt.Printf("Unable to create bucket %s: %v\n", bucket.Name, err)
+		return
+	}
+	fmt.Printf("Created bucket %s\n", bucket.Name)
+}
+
+// DeleteBucket Deletes an empty bucket
+func DeleteBucket(args []string) {
+	if len(args) != 1 {
+		fmt.Println("USAGE: app deleteBucket NAME")
+		return
+	}
+	if err := dataputter.DeleteBucket(args[0]); err != nil {
+		fmt.Printf("Unable to delete bucket %s: %v\n", args[0], err)
+		return
+	}
+	fmt.Printf("Deleted bucket %s\n", args[0])
+}
+
+// ListBuckets Prints every bucket with its settings
+func ListBuckets() {
+	names, err := dataputter.GetBuckets()
+	if err != nil {
+		fmt.Printf("Unable to list buckets: %v\n", err)
+		return
+	}
+	for _, name := range names {
+		bucket, err := dataputter.GetBucket(name)
+		if err != nil {
+			fmt.Printf("Unable to get bucket %s: %v\n", name, err)
+			continue
+		}
+		fmt.Printf("%s\tcompression=%s retention=%s\n",
+			bucket.Name, bucket.Compression, bucket.Retention)
+	}
+}
+
+// ListKeys Prints a page of the keys of a bucket and the cursor of the next one
+func ListKeys(args []string) {
+	flags := flag.NewFlagSet("listKeys", flag.ExitOnError)
+	prefix := flags.String("prefix", "", "Only list keys starting with prefix")
+	cursor := flags.String("cursor", "", "Cursor of the page to list")
+	limit := flags.Int("limit", dataputter.DefaultListLimit, "Keys in the page")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("USAGE: app listKeys [--prefix PREFIX] [--cursor CURSOR] [--limit N] BUCKET")
+		return
+	}
+
+	keys, next, err := dataputter.ListBucketKeys(flags.Arg(0), *prefix, *cursor, *limit)
+	for _, key := range keys {
+		fmt.Printf("%s\t%s\n", key.Key, key.ObjectID)
+	}
+	if err != nil {
+		fmt.Printf("Listing stopped: %v\n", err)
+		return
+	}
+	if len(next) > 0 {
+		fmt.Printf("Next page: --cursor %s\n", next)
+	}
+}
+
+// PutPolicy Replaces the policy of a bucket with the JSON grants of a file
+func PutPolicy(args []string) {
+	if len(args) != 2 {
+		fmt.Println("USAGE: app putPolicy BUCKET GRANTS.json")
+		return
+	}
+	policy, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		fmt.Printf("Unable to read %s: %v\n", args[1], err)
+		return
+	}
+	grants := []dataputter.Grant{}
+	if err := json.Unmarshal(policy, &grants); err != nil {
+		fmt.Printf("Unable to parse %s: %v\n", args[1], err)
+		return
+	}
+	if err := dataputter.PutBucketPolicy(args[0], grants); err != nil {
+		fmt.Printf("Unable to put policy of %s: %v\n", args[0], err)
+		return
+	}
+	fmt.Printf("Put %d grants on bucket %s\n", len(grants), args[0])
+}
+
+// GetPolicy Prints the policy of a bucket as JSON
+func GetPolicy(args []string) {
+	if len(args) != 1 {
+		fmt.Println("USAGE: app getPolicy BUCKET")
+		return
+	}
+	grants, err := dataputter.GetBucketPolicy(args[0])
+	if err != nil {
+		fmt.Printf("Unable to get policy of %s: %v\n", args[0], err)
+		return
+	}
+	out, _ := json.MarshalIndent(grants, "", "  ")
+	fmt.Println(string(out))
+}
+
+// Presign Prints a pre-signed path downloading an object, or uploading one
+func Presign(args []string) {
+	flags := flag.NewFlagSet("presign", flag.ExitOnError)
+	upload := flags.Bool("upload", false, "Upload an object, under BUCKET KEY when they're given")
+	ttl := flags.Duration("ttl", 15*time.Minute, "How long the token is valid")
+	tenant := flags.String("tenant", "", "Tenant the token acts as")
+	flags.Parse(args)
+
+	var token, path string
+	var err error
+	switch {
+	case !*upload && flags.NArg() == 1:
+		token, err = dataputter.PresignObjectDownload(*tenant, flags.Arg(0), *ttl)
+		path = "/objects/" + flags.Arg(0)
+	case *upload && flags.NArg() == 0:
+		token, err = dataputter.PresignObjectUpload(*tenant, "", "", *ttl)
+		path = "/objects"
+	case *upload && flags.NArg() == 2:
+		token, err = dataputter.PresignObjectUpload(*tenant, flags.Arg(0), flags.Arg(1), *ttl)
+		path = "/objects"
+	default:
+		fmt.Println("USAGE: app presign [--ttl AGE] [--tenant TENANT] OBJECT_ID | --upload [BUCKET KEY]")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Unable to presign: %v\n", err)
+		return
+	}
+	fmt.Printf("%s?token=%s\n", path, token)
+}
+
+// VerifyAudit Checks the hash chain of the audit log
+func VerifyAudit(args []string) {
+	flags := flag.NewFlagSet("verifyAudit", flag.ExitOnError)
+	path := flags.String("log", os.Getenv("AUDIT_LOG"), "Audit log to verify")
+	flags.Parse(args)
+
+	f, err := os.Open(*path)
+	if err != nil {
+		fmt.Printf("Unable to open audit log: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	verified, err := dataputter.VerifyAuditLog(f)
+	if err != nil {
+		fmt.Printf("Verified %d entries before: %v\n", verified, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Verified %d entries\n", verified)
+}
+
+// AuditLog Prints the entries of the audit log of an object, a tenant or a
+// time range as JSON lines
+func AuditLog(args []string) {
+	filter := dataputter.AuditFilter{}
+	flags := flag.NewFlagSet("auditLog", flag.ExitOnError)
+	path := flags.String("log", os.Getenv("AUDIT_LOG"), "Audit log to read")
+	flags.StringVar(&filter.ObjectID, "object", "", "Only entries of this ObjectID")
+	flags.StringVar(&filter.Tenant, "tenant", "", "Only entries of this tenant")
+	since := flags.String("since", "", "Only entries at or after this RFC 3339 time")
+	until := flags.String("until", "", "Only entries before this RFC 3339 time")
+	flags.Parse(args)
+
+	var err error
+	if len(*since) > 0 {
+		if filter.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			fmt.Printf("Invalid --since: %v\n", err)
+			return
+		}
+	}
+	if len(*until) > 0 {
+		if filter.Until, err = time.Parse(time.RFC3339, *until); err != nil {
+			fmt.Printf("Invalid --until: %v\n", err)
+			return
+		}
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		fmt.Printf("Unable to open audit log: %v\n", err)
+		return
+	}
+	defer f.Close()
+	entries, err := dataputter.FilterAuditLog(f, filter)
+	encoder := json.NewEncoder(os.Stdout)
+	for _, entry := range entries {
+		encoder.Encode(entry)
+	}
+	if err != nil {
+		fmt.Printf("Reading stopped: %v\n", err)
+	}
+}
+
+// SetQuota Limits the stored bytes and objects of a tenant
+func SetQuota(args []string) {
+	quota := dataputter.Quota{}
+	flags := flag.NewFlagSet("setQuota", flag.ExitOnError)
+	flags.Int64Var(&quota.Bytes, "bytes", 0, "Stored bytes, 0 is unlimited")
+	flags.Int64Var(&quota.Objects, "objects", 0, "Stored objects, 0 is unlimited")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("USAGE: app setQuota [--bytes N] [--objects N] TENANT")
+		return
+	}
+	if err := dataputter.SetTenantQuota(flags.Arg(0), quota); err != nil {
+		fmt.Printf("Unable to set quota of %s: %v\n", flags.Arg(0), err)
+		return
+	}
+	fmt.Printf("Set quota of %s to %d bytes and %d objects\n", flags.Arg(0), quota.Bytes, quota.Objects)
+}
+
+// GetQuota Prints the quota of a tenant and how much of it is used
+func GetQuota(args []string) {
+	if len(args) != 1 {
+		fmt.Println("USAGE: app getQuota TENANT")
+		return
+	}
+	quota, usage, err := dataputter.GetTenantQuota(args[0])
+	if err != nil {
+		fmt.Printf("Unable to get quota of %s: %v\n", args[0], err)
+		return
+	}
+	fmt.Printf("%s\tbytes=%d/%d objects=%d/%d\n", args[0], usage.Bytes, quota.Bytes, usage.Objects, quota.Objects)
+}
+
+// CollectGarbage Collects abandoned uploads once
+func CollectGarbage(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	ttl := flags.Duration("ttl", time.Hour, "Age of an upload before it's abandoned")
+	flags.Parse(args)
+
+	records, err := dataputter.CollectAbandonedObjects(*ttl)
+	for _, record := range records {
+		fmt.Printf("%s: %d tickets %s\n", record.ObjectID, len(record.Tickets), record.Error)
+	}
+	fmt.Printf("Collected %d abandoned objects\n", len(records))
+	if err != nil {
+		fmt.Printf("Garbage collection stopped: %v\n", err)
+	}
+}
+
 func showUsage() {
-	fmt.Println("USAGE: app [router|writeNode|standAlone]")
+	fmt.Println("USAGE: app [router|writeNode|standAlone|rotateKeys|migrateMetadata|exportMetadata|importMetadata|fsck|migrateLayout|gc|listObjects|statObject|createBucket|deleteBucket|listBuckets|listKeys|putPolicy|getPolicy|presign|createAPIKey|revokeAPIKey|setQuota|getQuota|verifyAudit|auditLog]")
 	os.Exit(1)
 }
 func main() {
@@ -66,6 +501,11 @@ func main() {
 			return
 		}
 	}
+	// Only a standAlone process can serve reads from the metadata it writes
+	if os.Getenv("METADATA_STORE") == "memory" {
+		fmt.Println("Keeping metadata in memory")
+		dataputter.UseMetadataStore(dataputter.NewMemoryStore())
+	}
 	fmt.Printf("Starting in %s mode\n", startupMode)
 	switch startupMode {
 	case "standAlone":
@@ -75,6 +515,50 @@ func main() {
 		StartRouter(config)
 	case "writeNode":
 		StartWriteNode("0.0.0.0", 5002)
+	case "rotateKeys":
+		RotateKeys()
+	case "migrateMetadata":
+		MigrateMetadata()
+	case "exportMetadata":
+		ExportMetadata(os.Args[2:])
+	case "importMetadata":
+		ImportMetadata(os.Args[2:])
+	case "fsck":
+		Fsck(os.Args[2:])
+	case "migrateLayout":
+		MigrateLayout(os.Args[2:])
+	case "gc":
+		CollectGarbage(os.Args[2:])
+	case "listObjects":
+		ListObjects(os.Args[2:])
+	case "statObject":
+		StatObject(os.Args[2:])
+	case "createBucket":
+		CreateBucket(os.Args[2:])
+	case "deleteBucket":
+		DeleteBucket(os.Args[2:])
+	case "listBuckets":
+		ListBuckets()
+	case "listKeys":
+		ListKeys(os.Args[2:])
+	case "putPolicy":
+		PutPolicy(os.Args[2:])
+	case "getPolicy":
+		GetPolicy(os.Args[2:])
+	case "presign":
+		Presign(os.Args[2:])
+	case "setQuota":
+		SetQuota(os.Args[2:])
+	case "getQuota":
+		GetQuota(os.Args[2:])
+	case "verifyAudit":
+		VerifyAudit(os.Args[2:])
+	case "auditLog":
+		AuditLog(os.Args[2:])
+	case "createAPIKey":
+		CreateAPIKey(os.Args[2:])
+	case "revokeAPIKey":
+		RevokeAPIKey(os.Args[2:])
 	default:
 		showUsage()
 	}